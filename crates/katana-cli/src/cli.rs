@@ -1,8 +1,22 @@
-use std::path::PathBuf;
+use std::{path::PathBuf, time::Duration};
 
 use clap::{Args, Parser};
-use katana_core::{constants::DEFAULT_GAS_PRICE, starknet::StarknetConfig};
-use katana_rpc::config::RpcConfig;
+use katana_core::{
+    constants::{DEFAULT_DEDUP_CACHE_SIZE, DEFAULT_GAS_PRICE},
+    schedule::BlockSchedule,
+    starknet::{
+        genesis::{load_genesis_calls, load_genesis_messages},
+        StarknetConfig, TimestampSource, DETERMINISTIC_BLOCK_TIME_STEP,
+    },
+};
+use katana_rpc::{config::RpcConfig, error_codes::load_error_codes};
+use starknet_api::{
+    core::{ContractAddress, PatriciaKey},
+    hash::StarkFelt,
+};
+
+/// Seconds `addTransaction` waits for the transaction to be mined with `--instant-confirm`.
+const DEFAULT_INSTANT_CONFIRM_TIMEOUT: u64 = 30;
 
 #[derive(Parser, Debug)]
 #[command(about = "A fast and lightweight local Starknet development node.")]
@@ -26,6 +40,29 @@ pub struct RpcOptions {
     #[arg(default_value = "5050")]
     #[arg(help = "Port number to listen on.")]
     pub port: u16,
+
+    #[arg(long)]
+    #[arg(help = "Log the names of the unknown methods called.")]
+    #[arg(
+        long_help = "Log the name of every unknown method called, for auditing in shared deployments. Callers still get the standard `method not found` error. The logging is rate-limited so that scanners can't flood the logs."
+    )]
+    pub log_unknown_methods: bool,
+
+    #[arg(long)]
+    #[arg(value_name = "BYTES")]
+    #[arg(help = "Gzip the responses of at least BYTES bytes for clients accepting it.")]
+    #[arg(
+        long_help = "Gzip-compress the HTTP responses of at least BYTES bytes, such as large blocks and receipts, for the clients sending `Accept-Encoding: gzip`. Smaller responses are sent uncompressed, as compressing them costs more than it saves. Disabled by default."
+    )]
+    pub compression_threshold: Option<usize>,
+
+    #[arg(long)]
+    #[arg(value_name = "PATH")]
+    #[arg(help = "Custom codes and messages for the RPC errors.")]
+    #[arg(
+        long_help = "Path to a JSON object mapping RPC error names, such as `BlockNotFound` or `InsufficientAccountBalance`, to the custom `code` and optional `message` to return instead, for integrations expecting specific codes. Unmapped errors keep their spec-compliant codes."
+    )]
+    pub error_codes: Option<PathBuf>,
 }
 
 #[derive(Debug, Args, Clone)]
@@ -48,6 +85,37 @@ pub struct StarknetOptions {
     )]
     pub account_path: Option<PathBuf>,
 
+    #[arg(long)]
+    #[arg(value_name = "SALT")]
+    #[arg(help = "Base salt used to derive the addresses of the predeployed accounts.")]
+    #[arg(
+        long_help = "Base salt used to derive the addresses of the predeployed accounts. Each account is deployed with the base salt offset by its index, making the genesis addresses reproducible across runs."
+    )]
+    pub genesis_salt: Option<u64>,
+
+    #[arg(long)]
+    #[arg(value_name = "SALTS")]
+    #[arg(value_delimiter = ',')]
+    #[arg(help = "Comma-separated salts of the predeployed accounts, in order.")]
+    #[arg(
+        long_help = "Comma-separated salts used to deploy the predeployed accounts, in order, so that fixtures can rely on fully controlled account addresses. They take precedence over `--genesis-salt`; accounts without an explicit salt fall back to it. Startup fails if an account address collides with another genesis contract."
+    )]
+    pub genesis_account_salts: Vec<u64>,
+
+    #[arg(long)]
+    #[arg(value_name = "WEI")]
+    #[arg(help = "Predeploy a deployer account with the given balance.")]
+    #[arg(
+        long_help = "Predeploy a deployer account with the given balance, set apart from the other predeployed accounts to simplify deployment-heavy setups. Its address is derived from the seed like the other accounts', so it is reproducible, and it is printed at startup."
+    )]
+    pub genesis_deployer_balance: Option<u128>,
+
+    #[arg(long)]
+    #[arg(value_name = "NONCE")]
+    #[arg(requires = "genesis_deployer_balance")]
+    #[arg(help = "The nonce the deployer account starts with.")]
+    pub genesis_deployer_nonce: Option<u64>,
+
     #[arg(long)]
     #[arg(help = "Block generation on demand via an endpoint.")]
     pub blocks_on_demand: bool,
@@ -56,6 +124,203 @@ pub struct StarknetOptions {
     #[arg(help = "Allow transaction max fee to be zero.")]
     pub allow_zero_max_fee: bool,
 
+    #[arg(long)]
+    #[arg(conflicts_with_all = ["blocks_on_demand", "min_txs_per_block"])]
+    #[arg(help = "Only return from `addTransaction` once the transaction has been mined.")]
+    #[arg(
+        long_help = "Only return from `addTransaction` once the transaction has been mined, so that its receipt can be fetched right away. Transactions that are rejected, or not mined before `--instant-confirm-timeout`, are reported as errors instead of returning their hash."
+    )]
+    pub instant_confirm: bool,
+
+    #[arg(long)]
+    #[arg(value_name = "SECONDS")]
+    #[arg(requires = "instant_confirm")]
+    #[arg(value_parser = clap::value_parser!(u64).range(1..))]
+    #[arg(help = "How long `addTransaction` waits for the transaction to be mined.")]
+    #[arg(
+        long_help = "How long `addTransaction` waits for the transaction to be mined with `--instant-confirm`, before reporting an error. Defaults to 30 seconds."
+    )]
+    pub instant_confirm_timeout: Option<u64>,
+
+    #[arg(long)]
+    #[arg(value_name = "URL")]
+    #[arg(
+        help = "URL to POST a notification to whenever a transaction is rejected or the chain is reorganized."
+    )]
+    #[arg(
+        long_help = "URL to POST a notification to whenever a transaction is rejected or the chain is reorganized. The JSON payload is tagged with its `type`: `rejected_transaction` payloads contain the transaction hash, the sender address and the rejection reason, and `chain_reorg` payloads contain the common ancestor, the new tip and the dropped transactions. Notifications are delivered in the background and dropped if the webhook can't keep up."
+    )]
+    pub rejection_webhook_url: Option<String>,
+
+    #[arg(long)]
+    #[arg(value_name = "NUM")]
+    #[arg(conflicts_with = "blocks_on_demand")]
+    #[arg(help = "Only mine a block once it contains at least NUM transactions.")]
+    pub min_txs_per_block: Option<usize>,
+
+    #[arg(long)]
+    #[arg(value_name = "MILLISECONDS")]
+    #[arg(requires = "min_txs_per_block")]
+    #[arg(help = "Mine the pending block anyway once its transactions have waited this long.")]
+    #[arg(
+        long_help = "Maximum time transactions wait for the minimum transaction count to be reached. Once elapsed, the pending block is mined with however many transactions it contains."
+    )]
+    pub block_wait_timeout: Option<u64>,
+
+    #[arg(long)]
+    #[arg(value_name = "CRON")]
+    #[arg(help = "Mine blocks at the times of a cron schedule, in UTC.")]
+    #[arg(
+        long_help = "Mine blocks at the times of a cron expression, in UTC, for wall-clock aligned block times. The expression has five fields (minute, hour, day of month, month, day of week), or six with a leading seconds field; for example `0 * * * * *` mines a block every minute on the minute. Each field accepts `*`, values, ranges `a-b`, steps `*/n` and comma-separated lists."
+    )]
+    pub block_schedule: Option<BlockSchedule>,
+
+    #[arg(long)]
+    #[arg(value_name = "MILLISECONDS")]
+    #[arg(help = "Serve identical fee estimates from a cache for this long.")]
+    #[arg(
+        long_help = "Cache fee estimates for the given duration, so that repeated identical `starknet_estimateFee` requests are served without re-executing the transaction. The cache is cleared whenever a new block is mined."
+    )]
+    pub fee_estimate_cache_ttl: Option<u64>,
+
+    #[arg(long)]
+    #[arg(help = "Serve identical calls against unchanged state from a cache.")]
+    #[arg(
+        long_help = "Cache the results of `starknet_call`, so that identical calls against unchanged state are served without re-executing them. Results are only correct for calls whose result depends solely on the state and their inputs, which is why the cache is disabled by default."
+    )]
+    pub call_cache: bool,
+
+    #[arg(long)]
+    #[arg(value_name = "RATIO")]
+    #[arg(help = "Reject transactions whose max fee exceeds this fraction of the sender balance.")]
+    #[arg(
+        long_help = "Reject transactions whose max fee exceeds this fraction of the sender balance (e.g. 0.5), to catch likely mistaken fee settings. Disabled by default."
+    )]
+    pub max_fee_balance_ratio: Option<f64>,
+
+    #[arg(long)]
+    #[arg(help = "Reject transactions whose max fee exceeds the sender balance.")]
+    #[arg(
+        long_help = "Reject transactions whose max fee exceeds the sender balance before executing them, with the `InsufficientAccountBalance` error. Disabled by default, in which case such transactions are executed and only fail if the actual fee can't be paid."
+    )]
+    pub reject_insufficient_balance: bool,
+
+    #[arg(long)]
+    #[arg(value_name = "PATH")]
+    #[arg(help = "L1 -> L2 messages to process in the first block.")]
+    #[arg(
+        long_help = "Path to a JSON file containing an array of L1 -> L2 messages, each with `from_address`, `to_address`, `selector` and `payload` fields. The messages are processed as L1 handler transactions in the first block."
+    )]
+    pub genesis_messages: Option<PathBuf>,
+
+    #[arg(long)]
+    #[arg(value_name = "PATH")]
+    #[arg(help = "Contract calls to execute at genesis, to initialize deployed contracts.")]
+    #[arg(
+        long_help = "Path to a JSON file containing an ordered array of calls, each with `contract_address`, `selector`, `calldata` and an optional `caller` field. The calls are executed after the genesis deployments and their effects are committed in the first block; startup fails if any call fails."
+    )]
+    pub genesis_calls: Option<PathBuf>,
+
+    #[arg(long)]
+    #[arg(value_name = "PATH")]
+    #[arg(help = "Contract storage to set at genesis, from a CSV or JSON file.")]
+    #[arg(
+        long_help = "Path to a file of contract storage values to set at genesis. A `.csv` file contains one `contract_address,key,value` row per value; any other file must contain a JSON array of objects with `contract_address`, `key` and `value` fields. The file is streamed, so it can hold large datasets, and startup fails if it references a contract that is not deployed."
+    )]
+    pub genesis_storage: Option<PathBuf>,
+
+    #[arg(long)]
+    #[arg(help = "Deploy the predeployed accounts through transactions in the first block.")]
+    #[arg(
+        long_help = "Deploy the predeployed accounts through `deploy_account` transactions in the first block instead of placing them directly in the genesis state, so that their deployment is visible in the block history. The accounts are funded beforehand to pay for their deployment, and their nonces start at 1."
+    )]
+    pub deploy_accounts_as_txs: bool,
+
+    #[arg(long)]
+    #[arg(help = "Produce identical block hashes for identical transaction sequences.")]
+    #[arg(
+        long_help = "Remove the sources of nondeterminism from block production so that replaying the same sequence of transactions always yields identical block hashes. Block timestamps advance by a fixed step per block instead of following the wall clock, as with `--timestamp-source step`."
+    )]
+    pub deterministic: bool,
+
+    #[arg(long)]
+    #[arg(value_name = "SOURCE")]
+    #[arg(conflicts_with = "deterministic")]
+    #[arg(help = "Where block timestamps come from: wall, monotonic or step.")]
+    #[arg(
+        long_help = "Where block timestamps come from. `wall` (the default) follows the system time. `monotonic` starts from the system time and then follows a monotonic clock, so that block times never go backward when the system clock is adjusted. `step` starts from zero at the genesis block and advances by a fixed number of seconds per block, set with `--timestamp-step`."
+    )]
+    pub timestamp_source: Option<TimestampSource>,
+
+    #[arg(long)]
+    #[arg(value_name = "SECONDS")]
+    #[arg(value_parser = clap::value_parser!(u64).range(1..))]
+    #[arg(requires = "timestamp_source")]
+    #[arg(help = "Seconds between consecutive blocks with the step timestamp source.")]
+    #[arg(
+        long_help = "The number of seconds block timestamps advance by from one block to the next with the `step` timestamp source. Defaults to 1."
+    )]
+    pub timestamp_step: Option<u64>,
+
+    #[arg(long)]
+    #[arg(help = "Log the ordering decisions made for every produced block.")]
+    #[arg(
+        long_help = "Log, for every produced block, each transaction the block producer considered and whether it was selected, deferred to a later block or rejected, along with the reason. The output is verbose and meant for debugging unexpected inclusion behavior."
+    )]
+    pub trace_ordering: bool,
+
+    #[arg(long)]
+    #[arg(value_name = "NUM")]
+    #[arg(value_parser = clap::value_parser!(u64).range(1..))]
+    #[arg(help = "Maximum number of declare transactions included in a block.")]
+    #[arg(
+        long_help = "Maximum number of declare transactions included in a block. Excess declare transactions are queued and included in subsequent blocks, while other transactions are not affected."
+    )]
+    pub max_declares_per_block: Option<u64>,
+
+    #[arg(long)]
+    #[arg(value_name = "FELTS")]
+    #[arg(value_parser = clap::value_parser!(u64).range(1..))]
+    #[arg(help = "Seal the pending block once its transactions' calldata reaches this size.")]
+    #[arg(
+        long_help = "Seal the pending block once the total calldata of its transactions reaches this number of felts, emulating data availability constrained block sizes. A transaction that would push the block over the limit is included in the next block instead. This applies regardless of the mining mode."
+    )]
+    pub max_block_calldata: Option<u64>,
+
+    #[arg(long)]
+    #[arg(value_name = "NUM")]
+    #[arg(help = "Maximum number of classes that can be declared on the node.")]
+    #[arg(
+        long_help = "Maximum number of classes declared through transactions over the lifetime of the node, excluding the genesis classes. Once reached, declare transactions are rejected while transactions using the existing classes keep working."
+    )]
+    pub max_declared_classes: Option<u64>,
+
+    #[arg(long)]
+    #[arg(value_name = "BLOCKS")]
+    #[arg(help = "How many blocks behind the latest one historical state queries can reach.")]
+    #[arg(
+        long_help = "How many blocks behind the latest one historical state queries, such as `starknet_getStorageAt`, can reach. This covers storage reads, calls, fee estimates and validations. Queries against older blocks are refused with a `query too deep` error, bounding their cost on shared nodes. The state of those blocks is still kept, this is not pruning. Unlimited by default."
+    )]
+    pub max_historical_query_depth: Option<u64>,
+
+    #[arg(long)]
+    #[arg(visible_alias = "sequencer-address")]
+    #[arg(value_name = "ADDRESS")]
+    #[arg(value_parser = parse_contract_address)]
+    #[arg(help = "The sequencer address, which the transaction fees are paid to.")]
+    #[arg(
+        long_help = "The sequencer address, which the transaction fees are paid to and which blocks report in their `sequencer_address` field. Must be a valid contract address. Defaults to 0x69420."
+    )]
+    pub fee_recipient: Option<ContractAddress>,
+
+    #[arg(long)]
+    #[arg(value_name = "NUM")]
+    #[arg(help = "Number of recent transaction hashes kept to reject duplicate submissions.")]
+    #[arg(
+        long_help = "Number of recent transaction hashes kept to reject duplicate submissions, trading memory for deduplication coverage. A transaction resubmitted after this many other transactions were received is no longer detected as a duplicate and is admitted again. Zero disables duplicate detection. Defaults to 1024."
+    )]
+    pub dedup_cache_size: Option<usize>,
+
     #[command(flatten)]
     #[command(next_help_heading = "Environment options")]
     pub environment: EnvironmentOptions,
@@ -71,12 +336,44 @@ pub struct EnvironmentOptions {
     #[arg(long)]
     #[arg(help = "The gas price.")]
     pub gas_price: Option<u128>,
+
+    #[arg(long)]
+    #[arg(value_name = "NUM_TXS")]
+    #[arg(value_parser = clap::value_parser!(u64).range(1..))]
+    #[arg(help = "Adjust the gas price every block towards this many transactions per block.")]
+    #[arg(
+        long_help = "Emulate EIP-1559 fee-market dynamics: after every block, the gas price rises if the block contained more transactions than the target, and falls if it contained fewer. The change per block is bounded by `--base-fee-change-denominator`."
+    )]
+    pub base_fee_target: Option<u64>,
+
+    #[arg(long)]
+    #[arg(value_name = "DENOMINATOR")]
+    #[arg(requires = "base_fee_target")]
+    #[arg(value_parser = clap::value_parser!(u64).range(1..))]
+    #[arg(default_value = "8")]
+    #[arg(help = "Bound the gas price change per block to 1/DENOMINATOR of its value.")]
+    pub base_fee_change_denominator: u64,
 }
 
 impl App {
     pub fn rpc_config(&self) -> RpcConfig {
         RpcConfig {
             port: self.rpc.port,
+            log_unknown_methods: self.rpc.log_unknown_methods,
+            compression_threshold: self.rpc.compression_threshold,
+            error_codes: self
+                .rpc
+                .error_codes
+                .as_ref()
+                .map(|path| load_error_codes(path).expect("should be able to load error codes"))
+                .unwrap_or_default(),
+            instant_confirm_timeout: self.starknet.instant_confirm.then(|| {
+                Duration::from_secs(
+                    self.starknet
+                        .instant_confirm_timeout
+                        .unwrap_or(DEFAULT_INSTANT_CONFIRM_TIMEOUT),
+                )
+            }),
         }
     }
 
@@ -92,11 +389,78 @@ impl App {
             blocks_on_demand: self.starknet.blocks_on_demand,
             account_path: self.starknet.account_path.clone(),
             allow_zero_max_fee: self.starknet.allow_zero_max_fee,
+            genesis_salt: self.starknet.genesis_salt,
+            genesis_account_salts: self.starknet.genesis_account_salts.clone(),
+            genesis_deployer_balance: self.starknet.genesis_deployer_balance,
+            genesis_deployer_nonce: self.starknet.genesis_deployer_nonce.unwrap_or_default(),
+            min_txs_per_block: self.starknet.min_txs_per_block,
+            block_wait_timeout: self.starknet.block_wait_timeout.map(Duration::from_millis),
+            fee_estimate_cache_ttl: self
+                .starknet
+                .fee_estimate_cache_ttl
+                .map(Duration::from_millis),
+            call_cache: self.starknet.call_cache,
+            max_fee_balance_ratio: self.starknet.max_fee_balance_ratio,
+            reject_insufficient_balance: self.starknet.reject_insufficient_balance,
+            genesis_messages: self
+                .starknet
+                .genesis_messages
+                .as_ref()
+                .map(|path| {
+                    load_genesis_messages(path).expect("should be able to load genesis messages")
+                })
+                .unwrap_or_default(),
+            genesis_calls: self
+                .starknet
+                .genesis_calls
+                .as_ref()
+                .map(|path| load_genesis_calls(path).expect("should be able to load genesis calls"))
+                .unwrap_or_default(),
+            genesis_storage: self.starknet.genesis_storage.clone(),
+            deploy_accounts_as_txs: self.starknet.deploy_accounts_as_txs,
+            // Deterministic mode is the step timestamp source with the default step
+            timestamp_source: if self.starknet.deterministic {
+                TimestampSource::Step
+            } else {
+                self.starknet
+                    .timestamp_source
+                    .unwrap_or(TimestampSource::Wall)
+            },
+            timestamp_step: self
+                .starknet
+                .timestamp_step
+                .unwrap_or(DETERMINISTIC_BLOCK_TIME_STEP),
+            trace_ordering: self.starknet.trace_ordering,
+            base_fee_target: self
+                .starknet
+                .environment
+                .base_fee_target
+                .map(|target| target as usize),
+            base_fee_change_denominator: u128::from(
+                self.starknet.environment.base_fee_change_denominator,
+            ),
+            max_declares_per_block: self.starknet.max_declares_per_block.map(|max| max as usize),
+            max_block_calldata: self.starknet.max_block_calldata.map(|max| max as usize),
+            max_declared_classes: self.starknet.max_declared_classes.map(|max| max as usize),
+            max_historical_query_depth: self.starknet.max_historical_query_depth,
+            fee_recipient: self.starknet.fee_recipient,
+            dedup_cache_size: self
+                .starknet
+                .dedup_cache_size
+                .unwrap_or(DEFAULT_DEDUP_CACHE_SIZE),
+            block_schedule: self.starknet.block_schedule.clone(),
             chain_id: self.starknet.environment.chain_id.clone(),
         }
     }
 }
 
+fn parse_contract_address(address: &str) -> Result<ContractAddress, String> {
+    let felt = StarkFelt::try_from(address).map_err(|e| e.to_string())?;
+    PatriciaKey::try_from(felt)
+        .map(ContractAddress)
+        .map_err(|e| e.to_string())
+}
+
 fn parse_seed(seed: Option<String>) -> [u8; 32] {
     seed.map(|seed| {
         let seed = seed.as_bytes();
@@ -1,8 +1,10 @@
-use std::{process::exit, sync::Arc};
+use std::{process::exit, sync::Arc, time::Duration};
 
 use clap::Parser;
 use env_logger::Env;
-use katana_core::sequencer::KatanaSequencer;
+use katana_core::{
+    schedule::produce_scheduled_blocks, sequencer::KatanaSequencer, webhook::RejectionWebhook,
+};
 use katana_rpc::KatanaNodeRpc;
 use log::error;
 use tokio::sync::RwLock;
@@ -12,6 +14,9 @@ mod cli;
 
 use cli::App;
 
+// How often the pending block is checked for having waited too long to be mined
+const BLOCK_WAIT_POLL_INTERVAL: Duration = Duration::from_millis(100);
+
 #[tokio::main]
 async fn main() {
     env_logger::Builder::from_env(Env::default().default_filter_or("info")).init();
@@ -20,20 +25,47 @@ async fn main() {
     let rpc_config = config.rpc_config();
     let starknet_config = config.starknet_config();
 
-    let sequencer = Arc::new(RwLock::new(KatanaSequencer::new(starknet_config)));
-    sequencer.write().await.start();
+    let block_wait_timeout = starknet_config.block_wait_timeout;
+    let block_schedule = starknet_config.block_schedule.clone();
+
+    let mut sequencer = KatanaSequencer::new(starknet_config);
+    sequencer.starknet.rejection_webhook = config
+        .starknet
+        .rejection_webhook_url
+        .clone()
+        .map(RejectionWebhook::spawn);
+    sequencer.start();
+
+    let sequencer = Arc::new(RwLock::new(sequencer));
+
+    if block_wait_timeout.is_some() {
+        let sequencer = sequencer.clone();
+        tokio::spawn(async move {
+            let mut interval = tokio::time::interval(BLOCK_WAIT_POLL_INTERVAL);
+            loop {
+                interval.tick().await;
+                if let Err(err) = sequencer
+                    .write()
+                    .await
+                    .starknet
+                    .mine_timed_out_pending_block()
+                {
+                    error!("Failed to mine timed out pending block: {err}");
+                }
+            }
+        });
+    }
+
+    if let Some(schedule) = block_schedule {
+        tokio::spawn(produce_scheduled_blocks(sequencer.clone(), schedule));
+    }
 
-    let predeployed_accounts = if config.hide_predeployed_accounts {
-        None
+    let (predeployed_accounts, deployer) = if config.hide_predeployed_accounts {
+        (None, None)
     } else {
-        Some(
-            sequencer
-                .read()
-                .await
-                .starknet
-                .predeployed_accounts
-                .display(),
-        )
+        let sequencer = sequencer.read().await;
+        let accounts = &sequencer.starknet.predeployed_accounts;
+        (Some(accounts.display()), accounts.display_deployer())
     };
 
     match KatanaNodeRpc::new(sequencer.clone(), rpc_config)
@@ -43,6 +75,7 @@ async fn main() {
         Ok((addr, server_handle)) => {
             print_intro(
                 predeployed_accounts,
+                deployer,
                 config.starknet.seed,
                 format!(
                     "🚀 JSON-RPC server started: {}",
@@ -59,7 +92,12 @@ async fn main() {
     };
 }
 
-fn print_intro(accounts: Option<String>, seed: Option<String>, address: String) {
+fn print_intro(
+    accounts: Option<String>,
+    deployer: Option<String>,
+    seed: Option<String>,
+    address: String,
+) {
     println!(
         "{}",
         Paint::red(
@@ -87,6 +125,16 @@ PREFUNDED ACCOUNTS
         );
     }
 
+    if let Some(deployer) = deployer {
+        println!(
+            r"
+DEPLOYER ACCOUNT
+================
+{deployer}
+    "
+        );
+    }
+
     if let Some(seed) = seed {
         println!(
             r"
@@ -1,25 +1,39 @@
-use std::{fs, path::PathBuf, sync::Arc};
+use std::{collections::HashSet, fs, path::PathBuf, sync::Arc};
 
-use anyhow::Result;
+use anyhow::{ensure, Result};
 use blockifier::{
-    abi::abi_utils::get_storage_var_address,
+    abi::abi_utils::{get_storage_var_address, selector_from_name},
     execution::contract_class::{ContractClass, ContractClassV0},
 };
 use rand::{rngs::SmallRng, RngCore, SeedableRng};
-use starknet::{core::types::FieldElement, signers::SigningKey};
+use starknet::{
+    core::{
+        crypto::compute_hash_on_elements, types::FieldElement, utils::cairo_short_string_to_felt,
+    },
+    signers::SigningKey,
+};
 use starknet_api::{
-    core::{calculate_contract_address, ClassHash, ContractAddress, PatriciaKey},
+    core::{calculate_contract_address, ChainId, ClassHash, ContractAddress, Nonce, PatriciaKey},
     hash::{StarkFelt, StarkHash},
     patricia_key, stark_felt,
-    transaction::{Calldata, ContractAddressSalt},
+    transaction::{
+        Calldata, ContractAddressSalt, DeployAccountTransaction, Fee, InvokeTransactionV1,
+        TransactionHash, TransactionSignature, TransactionVersion,
+    },
 };
 
 use crate::{
-    constants::{DEFAULT_ACCOUNT_CONTRACT, DEFAULT_ACCOUNT_CONTRACT_CLASS_HASH, FEE_TOKEN_ADDRESS},
+    constants::{
+        DEFAULT_ACCOUNT_CONTRACT, DEFAULT_ACCOUNT_CONTRACT_CLASS_HASH, FEE_TOKEN_ADDRESS,
+        UDC_ADDRESS,
+    },
     state::DictStateReader,
-    util::compute_legacy_class_hash,
+    util::{compute_legacy_class_hash, starkfelt_to_u128},
 };
 
+/// The salt used for every predeployed account when no salt base is configured.
+const DEFAULT_ACCOUNT_SALT: u64 = 666;
+
 #[derive(Debug, Clone)]
 pub struct Account {
     pub balance: StarkFelt,
@@ -28,6 +42,7 @@ pub struct Account {
     pub private_key: StarkFelt,
     pub contract_class: ContractClass,
     pub account_address: ContractAddress,
+    pub salt: ContractAddressSalt,
 }
 
 impl Account {
@@ -37,9 +52,10 @@ impl Account {
         private_key: StarkFelt,
         class_hash: ClassHash,
         contract_class: ContractClass,
+        salt: ContractAddressSalt,
     ) -> Self {
         let account_address = calculate_contract_address(
-            ContractAddressSalt(stark_felt!(666)),
+            salt,
             class_hash,
             &Calldata(Arc::new(vec![public_key])),
             ContractAddress(patricia_key!(0)),
@@ -53,16 +69,31 @@ impl Account {
             class_hash,
             contract_class,
             account_address,
+            salt,
         }
     }
 
     pub fn deploy(&self, state: &mut DictStateReader) {
-        self.declare(state);
+        self.fund(state);
 
         // set the contract
         state
             .address_to_class_hash
             .insert(self.account_address, self.class_hash);
+        // set the public key in the account contract
+        state.storage_view.insert(
+            (
+                self.account_address,
+                get_storage_var_address("Account_public_key", &[]).unwrap(),
+            ),
+            self.public_key,
+        );
+    }
+
+    // Declares the account class and sets the balance of the account, without deploying it
+    pub fn fund(&self, state: &mut DictStateReader) {
+        self.declare(state);
+
         // set the balance in the FEE CONTRACT
         state.storage_view.insert(
             (
@@ -72,14 +103,103 @@ impl Account {
             ),
             self.balance,
         );
-        // set the public key in the account contract
-        state.storage_view.insert(
-            (
-                self.account_address,
-                get_storage_var_address("Account_public_key", &[]).unwrap(),
+    }
+
+    /// Returns a signed `deploy_account` transaction deploying this account, paying for
+    /// the deployment with its own balance.
+    pub fn deploy_account_transaction(
+        &self,
+        chain_id: &ChainId,
+    ) -> Result<DeployAccountTransaction> {
+        let version = TransactionVersion(stark_felt!(1));
+        let nonce = Nonce(stark_felt!(0));
+        let max_fee = Fee(starkfelt_to_u128(self.balance)?);
+        let constructor_calldata = Calldata(Arc::new(vec![self.public_key]));
+
+        let transaction_hash = compute_hash_on_elements(&[
+            cairo_short_string_to_felt("deploy_account")?,
+            FieldElement::from(version.0),
+            FieldElement::from(*self.account_address.0.key()),
+            FieldElement::ZERO, // entry_point_selector
+            compute_hash_on_elements(
+                &[self.class_hash.0, self.salt.0]
+                    .iter()
+                    .chain(constructor_calldata.0.iter())
+                    .map(|felt| FieldElement::from(*felt))
+                    .collect::<Vec<_>>(),
             ),
-            self.public_key,
-        );
+            FieldElement::from(self.balance), // max_fee
+            cairo_short_string_to_felt(&chain_id.0)?,
+            FieldElement::from(nonce.0),
+        ]);
+
+        let signature = SigningKey::from_secret_scalar(FieldElement::from(self.private_key))
+            .sign(&transaction_hash)?;
+
+        Ok(DeployAccountTransaction {
+            transaction_hash: TransactionHash(transaction_hash.into()),
+            max_fee,
+            version,
+            signature: TransactionSignature(vec![signature.r.into(), signature.s.into()]),
+            nonce,
+            class_hash: self.class_hash,
+            contract_address: self.account_address,
+            contract_address_salt: self.salt,
+            constructor_calldata,
+        })
+    }
+
+    /// Returns a signed invoke transaction transferring fee tokens to each recipient, in a
+    /// single multicall. Only accounts of the default account class support multicalls.
+    pub fn transfer_transaction(
+        &self,
+        transfers: &[(ContractAddress, u128)],
+        nonce: Nonce,
+        max_fee: Fee,
+        chain_id: &ChainId,
+    ) -> Result<InvokeTransactionV1> {
+        // The call array, followed by the concatenated calldata of the calls
+        let mut calldata = vec![stark_felt!(transfers.len() as u64)];
+        for (i, _) in transfers.iter().enumerate() {
+            calldata.extend([
+                *FEE_TOKEN_ADDRESS,
+                selector_from_name("transfer").0,
+                stark_felt!(i as u64 * 3), // data_offset
+                stark_felt!(3_u64),        // data_len
+            ]);
+        }
+        calldata.push(stark_felt!(transfers.len() as u64 * 3));
+        for (recipient, amount) in transfers {
+            calldata.extend([*recipient.0.key(), stark_felt!(*amount), stark_felt!(0_u64)]);
+        }
+
+        let transaction_hash = compute_hash_on_elements(&[
+            cairo_short_string_to_felt("invoke")?,
+            FieldElement::ONE, // version
+            FieldElement::from(*self.account_address.0.key()),
+            FieldElement::ZERO, // entry_point_selector
+            compute_hash_on_elements(
+                &calldata
+                    .iter()
+                    .map(|felt| FieldElement::from(*felt))
+                    .collect::<Vec<_>>(),
+            ),
+            FieldElement::from(max_fee.0),
+            cairo_short_string_to_felt(&chain_id.0)?,
+            FieldElement::from(nonce.0),
+        ]);
+
+        let signature = SigningKey::from_secret_scalar(FieldElement::from(self.private_key))
+            .sign(&transaction_hash)?;
+
+        Ok(InvokeTransactionV1 {
+            transaction_hash: TransactionHash(transaction_hash.into()),
+            max_fee,
+            signature: TransactionSignature(vec![signature.r.into(), signature.s.into()]),
+            nonce,
+            sender_address: self.account_address,
+            calldata: Calldata(Arc::new(calldata)),
+        })
     }
 
     fn declare(&self, state: &mut DictStateReader) {
@@ -94,7 +214,10 @@ pub struct PredeployedAccounts {
     pub seed: [u8; 32],
     pub accounts: Vec<Account>,
     pub initial_balance: StarkFelt,
+    pub class_hash: ClassHash,
     pub contract_class: ContractClass,
+    /// An account set apart from the others to deploy contracts, usually with a larger balance.
+    pub deployer: Option<Account>,
 }
 
 impl PredeployedAccounts {
@@ -103,7 +226,15 @@ impl PredeployedAccounts {
         seed: [u8; 32],
         initial_balance: StarkFelt,
         contract_class_path: Option<PathBuf>,
+        salt: Option<u64>,
+        account_salts: &[u64],
     ) -> Result<Self> {
+        ensure!(
+            account_salts.len() <= usize::from(total),
+            "{} account salts given for {total} accounts",
+            account_salts.len()
+        );
+
         let (class_hash, contract_class) = if let Some(path) = contract_class_path {
             let contract_class_str = fs::read_to_string(path)?;
             let contract_class = serde_json::from_str::<ContractClassV0>(&contract_class_str)
@@ -122,35 +253,61 @@ impl PredeployedAccounts {
             initial_balance,
             class_hash,
             contract_class.clone(),
+            salt,
+            account_salts,
         );
 
+        Self::check_address_collisions(&accounts)?;
+
         Ok(Self {
             seed,
             accounts,
+            class_hash,
             contract_class,
             initial_balance,
+            deployer: None,
         })
     }
 
+    /// Adds the deployer account with the given balance. Its private key follows the ones of
+    /// the other accounts in the sequence derived from the seed, and its salt is offset from
+    /// the salt base as if it were the next account, so that its address is reproducible.
+    pub fn add_deployer(&mut self, balance: StarkFelt, salt: Option<u64>) -> Result<()> {
+        let mut seed = self.accounts.last().map_or(self.seed, |account| {
+            FieldElement::from(account.private_key).to_bytes_be()
+        });
+        let private_key = next_private_key(&mut seed);
+
+        let deployer = Account::new(
+            balance,
+            compute_public_key_from_private_key(private_key),
+            private_key,
+            self.class_hash,
+            self.contract_class.clone(),
+            ContractAddressSalt(indexed_salt(salt, self.accounts.len() as u64)),
+        );
+
+        let mut accounts = self.accounts.clone();
+        accounts.push(deployer.clone());
+        Self::check_address_collisions(&accounts)?;
+
+        self.deployer = Some(deployer);
+        Ok(())
+    }
+
     pub fn deploy_accounts(&self, state: &mut DictStateReader) {
         for account in &self.accounts {
             account.deploy(state);
         }
     }
 
-    pub fn display(&self) -> String {
-        fn print_account(account: &Account) -> String {
-            format!(
-                r"
-| Account address |  {} 
-| Private key     |  {}
-| Public key      |  {}",
-                account.account_address.0.key(),
-                account.private_key,
-                account.public_key
-            )
+    pub fn fund_accounts(&self, state: &mut DictStateReader) {
+        for account in &self.accounts {
+            account.fund(state);
         }
+    }
 
+    pub fn display(&self) -> String {
         self.accounts
             .iter()
             .map(print_account)
@@ -158,26 +315,30 @@ impl PredeployedAccounts {
             .join("\n")
     }
 
+    pub fn display_deployer(&self) -> Option<String> {
+        self.deployer.as_ref().map(print_account)
+    }
+
     fn generate_accounts(
         total: u8,
         seed: [u8; 32],
         balance: StarkFelt,
         class_hash: ClassHash,
         contract_class: ContractClass,
+        salt: Option<u64>,
+        account_salts: &[u64],
     ) -> Vec<Account> {
         let mut seed = seed;
         let mut accounts = vec![];
 
-        for _ in 0..total {
-            let mut rng = SmallRng::from_seed(seed);
-            let mut private_key_bytes = [0u8; 32];
+        for i in 0..total {
+            let private_key = next_private_key(&mut seed);
 
-            rng.fill_bytes(&mut private_key_bytes);
-            private_key_bytes[0] = 0;
-            seed = private_key_bytes;
-
-            let private_key =
-                StarkFelt::new(private_key_bytes).expect("should create StarkFelt from bytes");
+            // Explicit salts take precedence over the salt base
+            let salt = match account_salts.get(usize::from(i)) {
+                Some(account_salt) => stark_felt!(*account_salt),
+                None => indexed_salt(salt, u64::from(i)),
+            };
 
             accounts.push(Account::new(
                 balance,
@@ -185,12 +346,30 @@ impl PredeployedAccounts {
                 private_key,
                 class_hash,
                 contract_class.clone(),
+                ContractAddressSalt(salt),
             ));
         }
 
         accounts
     }
 
+    fn check_address_collisions(accounts: &[Account]) -> Result<()> {
+        let mut addresses = HashSet::from([
+            ContractAddress(patricia_key!(*FEE_TOKEN_ADDRESS)),
+            ContractAddress(patricia_key!(*UDC_ADDRESS)),
+        ]);
+
+        for account in accounts {
+            ensure!(
+                addresses.insert(account.account_address),
+                "account address {} collides with another genesis contract",
+                account.account_address.0.key()
+            );
+        }
+
+        Ok(())
+    }
+
     pub fn default_account_class() -> (ClassHash, ContractClass) {
         (
             ClassHash(*DEFAULT_ACCOUNT_CONTRACT_CLASS_HASH),
@@ -199,6 +378,39 @@ impl PredeployedAccounts {
     }
 }
 
+fn print_account(account: &Account) -> String {
+    format!(
+        r"
+| Account address |  {} 
+| Private key     |  {}
+| Public key      |  {}",
+        account.account_address.0.key(),
+        account.private_key,
+        account.public_key
+    )
+}
+
+// The salt of the account at the index when it has no explicit salt. Accounts are offset from
+// the salt base by their index, so that each of them gets its own salt.
+fn indexed_salt(salt: Option<u64>, index: u64) -> StarkFelt {
+    match salt {
+        Some(base) => StarkFelt::from(FieldElement::from(base) + FieldElement::from(index)),
+        None => stark_felt!(DEFAULT_ACCOUNT_SALT),
+    }
+}
+
+// Derives a private key from the seed, then replaces the seed with it to derive the next one
+fn next_private_key(seed: &mut [u8; 32]) -> StarkFelt {
+    let mut rng = SmallRng::from_seed(*seed);
+    let mut private_key_bytes = [0u8; 32];
+
+    rng.fill_bytes(&mut private_key_bytes);
+    private_key_bytes[0] = 0;
+    *seed = private_key_bytes;
+
+    StarkFelt::new(private_key_bytes).expect("should create StarkFelt from bytes")
+}
+
 // TODO: remove starknet-rs dependency
 fn compute_public_key_from_private_key(private_key: StarkFelt) -> StarkFelt {
     StarkFelt::from(
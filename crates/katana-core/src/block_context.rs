@@ -47,7 +47,9 @@ pub fn block_context_from_config(config: &StarknetConfig) -> BlockContext {
         block_number: BlockNumber::default(),
         chain_id: ChainId(config.chain_id.clone()),
         block_timestamp: BlockTimestamp::default(),
-        sequencer_address: ContractAddress(patricia_key!(*SEQUENCER_ADDRESS)),
+        sequencer_address: config
+            .fee_recipient
+            .unwrap_or(ContractAddress(patricia_key!(*SEQUENCER_ADDRESS))),
         fee_token_address: ContractAddress(patricia_key!(*FEE_TOKEN_ADDRESS)),
         vm_resource_fee_cost: HashMap::from([
             (String::from("n_steps"), 1_f64),
@@ -0,0 +1,91 @@
+use std::collections::HashMap;
+
+use starknet_api::{
+    block::BlockNumber,
+    core::{ContractAddress, EntryPointSelector},
+    hash::StarkFelt,
+    transaction::Calldata,
+};
+
+/// Maximum number of cached call results. Once full, the least recently used result is
+/// evicted.
+const CALL_CACHE_CAPACITY: usize = 1024;
+
+/// The state a call was executed against. State roots aren't computed, so the state is
+/// identified by its block instead.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Hash)]
+pub enum CallState {
+    /// The state at the end of a mined block, which never changes.
+    Block(BlockNumber),
+    /// The pending state, along with the number of transactions of the pending block so that
+    /// results computed before new transactions were executed aren't reused.
+    Pending(BlockNumber, usize),
+}
+
+#[derive(Debug, Clone, PartialEq, Eq, Hash)]
+pub struct CallCacheKey {
+    pub contract_address: ContractAddress,
+    pub entry_point_selector: EntryPointSelector,
+    pub calldata: Calldata,
+    pub state: CallState,
+}
+
+struct CachedCall {
+    retdata: Vec<StarkFelt>,
+    last_used: u64,
+}
+
+/// Cache of the results of `call`s, so that identical view calls against unchanged state are
+/// served without re-executing them. Calls are assumed to be pure: their result only depends
+/// on the state and their inputs.
+#[derive(Default)]
+pub struct CallCache {
+    entries: HashMap<CallCacheKey, CachedCall>,
+    // Logical clock used to find the least recently used entry
+    clock: u64,
+    hits: u64,
+}
+
+impl CallCache {
+    pub fn get(&mut self, key: &CallCacheKey) -> Option<Vec<StarkFelt>> {
+        self.clock += 1;
+        let entry = self.entries.get_mut(key)?;
+
+        entry.last_used = self.clock;
+        self.hits += 1;
+        Some(entry.retdata.clone())
+    }
+
+    pub fn insert(&mut self, key: CallCacheKey, retdata: Vec<StarkFelt>) {
+        if self.entries.len() >= CALL_CACHE_CAPACITY && !self.entries.contains_key(&key) {
+            if let Some(lru) = self
+                .entries
+                .iter()
+                .min_by_key(|(_, entry)| entry.last_used)
+                .map(|(key, _)| key.clone())
+            {
+                self.entries.remove(&lru);
+            }
+        }
+
+        self.clock += 1;
+        self.entries.insert(
+            key,
+            CachedCall {
+                retdata,
+                last_used: self.clock,
+            },
+        );
+    }
+
+    /// Drops every cached result, for when the state of past blocks or of the pending block
+    /// changes without the block numbers or transaction count telling it.
+    pub fn clear(&mut self) {
+        self.entries.clear();
+    }
+
+    /// The number of calls served from the cache.
+    pub fn hits(&self) -> u64 {
+        self.hits
+    }
+}
@@ -5,6 +5,11 @@ use starknet_api::{hash::StarkFelt, stark_felt};
 use crate::util::get_contract_class;
 
 pub const DEFAULT_GAS_PRICE: u128 = 100 * u128::pow(10, 9); // Given in units of wei.
+/// Bounds the gas price change between two blocks to `1 / DEFAULT_BASE_FEE_CHANGE_DENOMINATOR`
+/// of the current price, as in EIP-1559.
+pub const DEFAULT_BASE_FEE_CHANGE_DENOMINATOR: u128 = 8;
+/// The number of recent transaction hashes kept to reject duplicate submissions.
+pub const DEFAULT_DEDUP_CACHE_SIZE: usize = 1024;
 
 // Contract artifacts path
 
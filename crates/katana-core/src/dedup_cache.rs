@@ -0,0 +1,64 @@
+use std::collections::{HashSet, VecDeque};
+
+use starknet_api::transaction::TransactionHash;
+
+/// The hashes of the most recently received transactions, used to reject duplicate
+/// submissions. Only the last `capacity` hashes are kept, so a transaction resubmitted after
+/// that many others were received is admitted again.
+#[derive(Debug, Default)]
+pub struct DedupCache {
+    capacity: usize,
+    hashes: HashSet<TransactionHash>,
+    // Insertion order of the hashes, oldest first
+    order: VecDeque<TransactionHash>,
+}
+
+impl DedupCache {
+    /// A cache retaining no hash disables duplicate detection.
+    pub fn new(capacity: usize) -> Self {
+        Self {
+            capacity,
+            ..Default::default()
+        }
+    }
+
+    /// Records the hash, evicting the oldest one when full. Returns false if the hash was
+    /// already recorded.
+    pub fn insert(&mut self, hash: TransactionHash) -> bool {
+        if self.capacity == 0 {
+            return true;
+        }
+
+        if !self.hashes.insert(hash) {
+            return false;
+        }
+
+        self.order.push_back(hash);
+        if self.order.len() > self.capacity {
+            if let Some(oldest) = self.order.pop_front() {
+                self.hashes.remove(&oldest);
+            }
+        }
+
+        true
+    }
+
+    /// Forgets the hash, so that the transaction can be submitted again.
+    pub fn remove(&mut self, hash: &TransactionHash) {
+        if self.hashes.remove(hash) {
+            self.order.retain(|recorded| recorded != hash);
+        }
+    }
+
+    pub fn contains(&self, hash: &TransactionHash) -> bool {
+        self.hashes.contains(hash)
+    }
+
+    pub fn len(&self) -> usize {
+        self.hashes.len()
+    }
+
+    pub fn is_empty(&self) -> bool {
+        self.hashes.is_empty()
+    }
+}
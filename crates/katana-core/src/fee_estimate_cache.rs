@@ -0,0 +1,129 @@
+use std::{
+    collections::HashMap,
+    time::{Duration, Instant},
+};
+
+use starknet::{
+    core::types::{FeeEstimate, FieldElement},
+    providers::jsonrpc::models::{BlockId, BlockTag},
+};
+use starknet_api::{
+    block::BlockNumber,
+    transaction::{TransactionHash, TransactionSignature},
+};
+
+/// Maximum number of cached estimates. Once full, the least recently used estimate is evicted.
+const FEE_ESTIMATE_CACHE_CAPACITY: usize = 1024;
+
+/// The block an estimate was requested on.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Hash)]
+pub enum EstimateBlock {
+    Hash(FieldElement),
+    Number(u64),
+    Latest,
+    /// The pending block, along with its number of transactions so that estimates made
+    /// before new transactions were added to it aren't reused.
+    Pending(usize),
+}
+
+impl EstimateBlock {
+    pub fn new(block_id: BlockId, pending_transactions: usize) -> Self {
+        match block_id {
+            BlockId::Hash(hash) => Self::Hash(hash),
+            BlockId::Number(number) => Self::Number(number),
+            BlockId::Tag(BlockTag::Latest) => Self::Latest,
+            BlockId::Tag(BlockTag::Pending) => Self::Pending(pending_transactions),
+        }
+    }
+}
+
+/// Identifies an estimate request. The transaction hash commits to the whole transaction
+/// payload except its signature, which is part of the key on its own.
+#[derive(Debug, Clone, PartialEq, Eq, Hash)]
+pub struct FeeEstimateKey {
+    pub transaction_hash: TransactionHash,
+    pub signature: TransactionSignature,
+    pub block: EstimateBlock,
+}
+
+struct CachedEstimate {
+    estimate: FeeEstimate,
+    created_at: Instant,
+    last_used: Instant,
+}
+
+/// Short-lived cache of fee estimates, so that clients polling the same estimate don't
+/// re-execute the transaction every time. All the estimates are dropped whenever a new block
+/// is mined, as estimates made on the latest state become stale.
+pub struct FeeEstimateCache {
+    ttl: Duration,
+    entries: HashMap<FeeEstimateKey, CachedEstimate>,
+    latest_block: Option<BlockNumber>,
+    hits: u64,
+}
+
+impl FeeEstimateCache {
+    pub fn new(ttl: Duration) -> Self {
+        Self {
+            ttl,
+            entries: HashMap::new(),
+            latest_block: None,
+            hits: 0,
+        }
+    }
+
+    /// Returns the cached estimate for the request, if it was made within the TTL and no
+    /// block was mined since.
+    pub fn get(
+        &mut self,
+        key: &FeeEstimateKey,
+        latest_block: Option<BlockNumber>,
+    ) -> Option<FeeEstimate> {
+        if latest_block != self.latest_block {
+            self.entries.clear();
+            self.latest_block = latest_block;
+        }
+
+        let entry = self.entries.get_mut(key)?;
+        if entry.created_at.elapsed() >= self.ttl {
+            self.entries.remove(key);
+            return None;
+        }
+
+        entry.last_used = Instant::now();
+        self.hits += 1;
+        Some(entry.estimate.clone())
+    }
+
+    pub fn insert(&mut self, key: FeeEstimateKey, estimate: FeeEstimate) {
+        if self.entries.len() >= FEE_ESTIMATE_CACHE_CAPACITY && !self.entries.contains_key(&key) {
+            if let Some(lru) = self
+                .entries
+                .iter()
+                .min_by_key(|(_, entry)| entry.last_used)
+                .map(|(key, _)| key.clone())
+            {
+                self.entries.remove(&lru);
+            }
+        }
+
+        let now = Instant::now();
+        self.entries.insert(
+            key,
+            CachedEstimate {
+                estimate,
+                created_at: now,
+                last_used: now,
+            },
+        );
+    }
+
+    pub fn clear(&mut self) {
+        self.entries.clear();
+    }
+
+    /// The number of estimates served from the cache.
+    pub fn hits(&self) -> u64 {
+        self.hits
+    }
+}
@@ -1,7 +1,12 @@
 pub mod accounts;
 pub mod block_context;
+pub mod call_cache;
 pub mod constants;
+pub mod dedup_cache;
+pub mod fee_estimate_cache;
+pub mod schedule;
 pub mod sequencer;
 pub mod starknet;
 pub mod state;
 pub mod util;
+pub mod webhook;
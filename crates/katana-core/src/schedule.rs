@@ -0,0 +1,170 @@
+use std::{str::FromStr, sync::Arc};
+
+use anyhow::{anyhow, bail, ensure, Result};
+use chrono::{DateTime, Datelike, Duration, TimeZone, Timelike, Utc};
+use tokio::sync::RwLock;
+use tracing::error;
+
+use crate::sequencer::Sequencer;
+
+/// How far ahead the next scheduled time is searched for, so that expressions that never
+/// match, such as February 30th, don't loop forever.
+const MAX_SCHEDULE_LOOKAHEAD_YEARS: i32 = 5;
+
+/// A cron-like schedule of block production times, in UTC.
+///
+/// Expressions have five fields, `minute hour day-of-month month day-of-week`, or six with a
+/// leading `second` field. Each field is `*`, a value, a range `a-b`, a stepped `*/n` or
+/// `a-b/n`, or a comma-separated list of those. Days of the week go from 0 (Sunday) to 7
+/// (Sunday again). As in cron, when both the day of the month and the day of the week are
+/// restricted, a day matching either of them matches.
+#[derive(Debug, Clone)]
+pub struct BlockSchedule {
+    seconds: Vec<bool>,
+    minutes: Vec<bool>,
+    hours: Vec<bool>,
+    days_of_month: Vec<bool>,
+    months: Vec<bool>,
+    days_of_week: Vec<bool>,
+    days_of_month_restricted: bool,
+    days_of_week_restricted: bool,
+}
+
+impl FromStr for BlockSchedule {
+    type Err = anyhow::Error;
+
+    fn from_str(expression: &str) -> Result<Self> {
+        let fields = expression.split_whitespace().collect::<Vec<_>>();
+        let (seconds, fields) = match fields.len() {
+            5 => ("0", &fields[..]),
+            6 => (fields[0], &fields[1..]),
+            n => bail!("expected 5 or 6 fields in schedule `{expression}`, got {n}"),
+        };
+
+        let mut days_of_week = parse_field(fields[4], 0, 7)?;
+        // Both 0 and 7 are Sunday
+        days_of_week[0] |= days_of_week[7];
+        days_of_week.truncate(7);
+
+        Ok(Self {
+            seconds: parse_field(seconds, 0, 59)?,
+            minutes: parse_field(fields[0], 0, 59)?,
+            hours: parse_field(fields[1], 0, 23)?,
+            days_of_month: parse_field(fields[2], 1, 31)?,
+            months: parse_field(fields[3], 1, 12)?,
+            days_of_week,
+            days_of_month_restricted: fields[2] != "*",
+            days_of_week_restricted: fields[4] != "*",
+        })
+    }
+}
+
+impl BlockSchedule {
+    /// Returns the first scheduled time strictly after `time`.
+    pub fn next_after(&self, time: DateTime<Utc>) -> Option<DateTime<Utc>> {
+        let mut next = time.with_nanosecond(0)? + Duration::seconds(1);
+        let max_year = time.year() + MAX_SCHEDULE_LOOKAHEAD_YEARS;
+
+        while next.year() <= max_year {
+            if !self.months[next.month() as usize] {
+                let (year, month) = if next.month() == 12 {
+                    (next.year() + 1, 1)
+                } else {
+                    (next.year(), next.month() + 1)
+                };
+                next = Utc.with_ymd_and_hms(year, month, 1, 0, 0, 0).single()?;
+            } else if !self.matches_day(next) {
+                next = Utc
+                    .with_ymd_and_hms(next.year(), next.month(), next.day(), 0, 0, 0)
+                    .single()?
+                    + Duration::days(1);
+            } else if !self.hours[next.hour() as usize] {
+                next = next.with_minute(0)?.with_second(0)? + Duration::hours(1);
+            } else if !self.minutes[next.minute() as usize] {
+                next = next.with_second(0)? + Duration::minutes(1);
+            } else if !self.seconds[next.second() as usize] {
+                next += Duration::seconds(1);
+            } else {
+                return Some(next);
+            }
+        }
+
+        None
+    }
+
+    fn matches_day(&self, time: DateTime<Utc>) -> bool {
+        let day_of_month = self.days_of_month[time.day() as usize];
+        let day_of_week = self.days_of_week[time.weekday().num_days_from_sunday() as usize];
+
+        if self.days_of_month_restricted && self.days_of_week_restricted {
+            day_of_month || day_of_week
+        } else {
+            day_of_month && day_of_week
+        }
+    }
+}
+
+// Parses a field into a table indexed by value, telling whether the value is included
+fn parse_field(field: &str, min: u32, max: u32) -> Result<Vec<bool>> {
+    let mut included = vec![false; max as usize + 1];
+
+    for part in field.split(',') {
+        let (range, step) = match part.split_once('/') {
+            Some((range, step)) => {
+                let step = step
+                    .parse::<u32>()
+                    .map_err(|_| anyhow!("invalid step `{step}` in `{field}`"))?;
+                ensure!(step > 0, "step must be positive in `{field}`");
+                (range, step)
+            }
+            None => (part, 1),
+        };
+
+        let (start, end) = if range == "*" {
+            (min, max)
+        } else if let Some((start, end)) = range.split_once('-') {
+            (parse_value(start, field)?, parse_value(end, field)?)
+        } else {
+            let value = parse_value(range, field)?;
+            // `a/n` is a shorthand for `a-max/n`
+            (value, if step > 1 { max } else { value })
+        };
+
+        ensure!(
+            min <= start && start <= end && end <= max,
+            "`{part}` is out of the {min}-{max} range in `{field}`"
+        );
+
+        for value in (start..=end).step_by(step as usize) {
+            included[value as usize] = true;
+        }
+    }
+
+    Ok(included)
+}
+
+fn parse_value(value: &str, field: &str) -> Result<u32> {
+    value
+        .parse()
+        .map_err(|_| anyhow!("invalid value `{value}` in `{field}`"))
+}
+
+/// Mines a block at every time of the schedule. Runs until the task is dropped.
+pub async fn produce_scheduled_blocks<S: Sequencer + Send + Sync + 'static>(
+    sequencer: Arc<RwLock<S>>,
+    schedule: BlockSchedule,
+) {
+    loop {
+        let now = Utc::now();
+        let Some(next) = schedule.next_after(now) else {
+            error!("Block schedule has no upcoming time, stopping scheduled block production");
+            return;
+        };
+
+        tokio::time::sleep((next - now).to_std().unwrap_or_default()).await;
+
+        if let Err(err) = sequencer.write().await.generate_new_block() {
+            error!("Failed to mine scheduled block: {err}");
+        }
+    }
+}
@@ -1,19 +1,29 @@
+use std::{collections::HashMap, sync::Mutex};
+
 use anyhow::Result;
 use starknet::{
-    core::types::{FeeEstimate, FeeUnit},
-    providers::jsonrpc::models::{BlockId, BlockTag, StateUpdate},
+    core::types::{FeeEstimate, FeeUnit, TransactionStatus},
+    providers::jsonrpc::models::{BlockId, BlockTag, DeployedContractItem, StateDiff, StateUpdate},
 };
 
 use crate::{
+    call_cache::{CallCache, CallCacheKey, CallState},
+    fee_estimate_cache::{EstimateBlock, FeeEstimateCache, FeeEstimateKey},
     starknet::{
-        block::StarknetBlock, event::EmittedEvent, transaction::ExternalFunctionCall,
-        StarknetConfig, StarknetWrapper,
+        block::StarknetBlock,
+        event::EmittedEvent,
+        transaction::{ExternalFunctionCall, ValidationResult},
+        ExecutorConfig, FundingOutcome, PendingTransactionAge, PoolPosition, StarknetConfig,
+        StarknetWrapper,
+    },
+    util::{
+        convert_state_diff_to_rpc_state_diff, get_signature, get_transaction_hash,
+        get_transaction_max_fee, starkfelt_to_u128,
     },
-    util::starkfelt_to_u128,
 };
 
 use blockifier::{
-    abi::abi_utils::get_storage_var_address,
+    abi::abi_utils::{get_storage_var_address, selector_from_name},
     fee::fee_utils::{calculate_l1_gas_by_vm_usage, extract_l1_gas_and_vm_usage},
     state::state_api::{State, StateReader},
     transaction::{
@@ -21,35 +31,110 @@ use blockifier::{
         transactions::ExecutableTransaction,
     },
 };
+use tracing::info;
 // use starknet::providers::jsonrpc::models::BlockId;
 use starknet_api::{
-    block::{BlockHash, BlockNumber},
-    core::{calculate_contract_address, ChainId, ClassHash, ContractAddress, Nonce},
+    block::{BlockHash, BlockNumber, BlockTimestamp},
+    core::{
+        calculate_contract_address, ChainId, ClassHash, CompiledClassHash, ContractAddress,
+        GlobalRoot, Nonce,
+    },
     hash::StarkFelt,
     stark_felt,
     state::StorageKey,
     transaction::{
-        Calldata, ContractAddressSalt, DeployAccountTransaction, Fee,
-        Transaction as StarknetApiTransaction, TransactionHash, TransactionSignature,
-        TransactionVersion,
+        Calldata, ContractAddressSalt, DeployAccountTransaction, Fee, InvokeTransactionV1,
+        Transaction as StarknetApiTransaction, TransactionHash, TransactionReceipt,
+        TransactionSignature, TransactionVersion,
     },
 };
 
+/// The selectors probed for interface introspection: the SRC5 `supports_interface`, then the
+/// legacy ERC165-style `supportsInterface` implemented by the Cairo 0 accounts.
+const SUPPORTS_INTERFACE_SELECTORS: [&str; 2] = ["supports_interface", "supportsInterface"];
+
 pub struct KatanaSequencer {
     pub starknet: StarknetWrapper,
+    /// Cached results of `supports_interface`, keyed by contract address and interface id,
+    /// along with the pending state they were computed against.
+    pub interface_support: HashMap<(ContractAddress, StarkFelt), (CallState, bool)>,
+    pub fee_estimate_cache: Option<Mutex<FeeEstimateCache>>,
+    pub call_cache: Option<Mutex<CallCache>>,
 }
 
 impl KatanaSequencer {
     pub fn new(config: StarknetConfig) -> Self {
         Self {
+            fee_estimate_cache: config
+                .fee_estimate_cache_ttl
+                .map(|ttl| Mutex::new(FeeEstimateCache::new(ttl))),
+            call_cache: config.call_cache.then(Mutex::default),
             starknet: StarknetWrapper::new(config),
+            interface_support: HashMap::new(),
         }
     }
 
+    fn execute_fee_estimate(
+        &self,
+        account_transaction: AccountTransaction,
+        block_id: BlockId,
+    ) -> Result<FeeEstimate> {
+        let block_context = self.starknet.block_context_from_block_id(block_id).ok_or(
+            blockifier::state::errors::StateError::StateReadError(format!(
+                "block {block_id:?} not found",
+            )),
+        )?;
+
+        let exec_info = self
+            .starknet
+            .simulate_transaction(account_transaction, block_id)?;
+
+        let (l1_gas_usage, vm_resources) = extract_l1_gas_and_vm_usage(&exec_info.actual_resources);
+        let l1_gas_by_vm_usage = calculate_l1_gas_by_vm_usage(&block_context, &vm_resources)?;
+
+        let total_l1_gas_usage = l1_gas_usage as f64 + l1_gas_by_vm_usage;
+
+        Ok(FeeEstimate {
+            unit: FeeUnit::Wei,
+            overall_fee: total_l1_gas_usage.ceil() as u64 * block_context.gas_price as u64,
+            gas_usage: total_l1_gas_usage.ceil() as u64,
+            gas_price: block_context.gas_price as u64,
+        })
+    }
+
     // The starting point of the sequencer
     // Once we add support periodic block generation, the logic should be here.
     pub fn start(&mut self) {
         self.starknet.generate_pending_block();
+
+        let hashes = self
+            .starknet
+            .process_genesis()
+            .expect("should be able to process genesis");
+        for hash in hashes {
+            info!("Genesis transaction processed | Transaction hash: {hash}");
+        }
+    }
+
+    // The pending state calls are executed against, which changes with every transaction
+    // executed in the pending block
+    fn pending_call_state(&self) -> CallState {
+        CallState::Pending(
+            self.starknet.block_context.block_number,
+            self.starknet.pending_transaction_count(),
+        )
+    }
+
+    // Drops the cached results computed against states that are being rewritten, or against
+    // the pending state when it is written to outside of a transaction
+    fn clear_state_caches(&mut self) {
+        if let Some(cache) = &self.fee_estimate_cache {
+            cache.lock().unwrap().clear();
+        }
+        if let Some(cache) = &self.call_cache {
+            cache.lock().unwrap().clear();
+        }
+        self.interface_support.clear();
     }
 
     pub fn drip_and_deploy_account(
@@ -77,6 +162,7 @@ impl KatanaSequencer {
             deployed_account_balance_key,
             stark_felt!(balance),
         );
+        self.clear_state_caches();
 
         self.deploy_account(
             class_hash,
@@ -127,10 +213,14 @@ impl Sequencer for KatanaSequencer {
             transaction_hash: tx_hash,
         });
 
-        tx.execute(
+        // The deployment writes to the pending state without adding a transaction to the
+        // pending block, which the cache keys can't tell, even when it fails midway
+        let result = tx.execute(
             &mut self.starknet.pending_state,
             &self.starknet.block_context,
-        )?;
+        );
+        self.clear_state_caches();
+        result?;
 
         Ok((tx_hash, contract_address))
     }
@@ -145,29 +235,27 @@ impl Sequencer for KatanaSequencer {
         account_transaction: AccountTransaction,
         block_id: BlockId,
     ) -> Result<FeeEstimate> {
-        let state = self.starknet.state_from_block_id(block_id).ok_or(
-            blockifier::state::errors::StateError::StateReadError(format!(
-                "block {block_id:?} not found",
-            )),
-        )?;
-
-        let exec_info = self
-            .starknet
-            .simulate_transaction(account_transaction, Some(state))?;
+        let Some(cache) = &self.fee_estimate_cache else {
+            return self.execute_fee_estimate(account_transaction, block_id);
+        };
+        if let Some(block_number) = self.starknet.block_number_from_block_id(block_id) {
+            self.starknet.check_query_depth(block_number)?;
+        }
 
-        let (l1_gas_usage, vm_resources) = extract_l1_gas_and_vm_usage(&exec_info.actual_resources);
-        let l1_gas_by_vm_usage =
-            calculate_l1_gas_by_vm_usage(&self.starknet.block_context, &vm_resources)?;
+        let key = FeeEstimateKey {
+            transaction_hash: get_transaction_hash(&account_transaction),
+            signature: get_signature(&account_transaction),
+            block: EstimateBlock::new(block_id, self.starknet.pending_transaction_count()),
+        };
+        let latest_block = self.starknet.blocks.current_block_number();
 
-        let total_l1_gas_usage = l1_gas_usage as f64 + l1_gas_by_vm_usage;
+        if let Some(estimate) = cache.lock().unwrap().get(&key, latest_block) {
+            return Ok(estimate);
+        }
 
-        Ok(FeeEstimate {
-            unit: FeeUnit::Wei,
-            overall_fee: total_l1_gas_usage.ceil() as u64
-                * self.starknet.block_context.gas_price as u64,
-            gas_usage: total_l1_gas_usage.ceil() as u64,
-            gas_price: self.starknet.block_context.gas_price as u64,
-        })
+        let estimate = self.execute_fee_estimate(account_transaction, block_id)?;
+        cache.lock().unwrap().insert(key, estimate.clone());
+        Ok(estimate)
     }
 
     fn block_hash_and_number(&self) -> Option<(BlockHash, BlockNumber)> {
@@ -183,25 +271,61 @@ impl Sequencer for KatanaSequencer {
         self.starknet.state.get_class_hash_at(contract_address)
     }
 
+    // Only Sierra classes have a compiled class hash, so legacy classes are never found
+    fn class_hash_by_compiled_class_hash(
+        &self,
+        block_id: BlockId,
+        compiled_class_hash: CompiledClassHash,
+    ) -> Option<ClassHash> {
+        self.starknet
+            .state_from_block_id(block_id)
+            .ok()?
+            .class_hash_to_compiled_class_hash
+            .into_iter()
+            .find(|(_, compiled)| *compiled == compiled_class_hash)
+            .map(|(class_hash, _)| class_hash)
+    }
+
     fn storage_at(
         &mut self,
         contract_address: ContractAddress,
         storage_key: StorageKey,
         block_id: BlockId,
-    ) -> Result<StarkFelt, blockifier::state::errors::StateError> {
-        let mut state = self.starknet.state_from_block_id(block_id).ok_or(
-            blockifier::state::errors::StateError::StateReadError(format!(
-                "block {block_id:?} not found",
-            )),
-        )?;
-
-        state.get_storage_at(contract_address, storage_key)
+    ) -> Result<StarkFelt> {
+        let mut state = self.starknet.state_from_block_id(block_id)?;
+        Ok(state.get_storage_at(contract_address, storage_key)?)
     }
 
     fn chain_id(&self) -> ChainId {
         self.starknet.block_context.chain_id.clone()
     }
 
+    fn executor_config(&self) -> ExecutorConfig {
+        self.starknet.executor_config()
+    }
+
+    fn pool_position(&self, transaction_hash: &TransactionHash) -> Option<PoolPosition> {
+        self.starknet.pool_position(transaction_hash)
+    }
+
+    fn oldest_pending_transaction(&self) -> Option<PendingTransactionAge> {
+        self.starknet.oldest_pending_transaction()
+    }
+
+    fn fund_accounts(&mut self, transfers: &[(ContractAddress, u128)]) -> Result<FundingOutcome> {
+        self.starknet.fund_accounts(transfers)
+    }
+
+    fn validate_transaction(
+        &self,
+        transaction: InvokeTransactionV1,
+        block_id: BlockId,
+    ) -> Result<ValidationResult> {
+        let block_number = self.starknet.block_number_from_block_id(block_id);
+        self.starknet
+            .validate_transaction(&transaction, block_number)
+    }
+
     fn block_number(&self) -> BlockNumber {
         self.starknet.block_context.block_number
     }
@@ -225,14 +349,49 @@ impl Sequencer for KatanaSequencer {
         self.starknet.state.get_nonce_at(contract_address)
     }
 
+    fn pending_nonce_at(
+        &mut self,
+        contract_address: ContractAddress,
+    ) -> Result<Nonce, blockifier::state::errors::StateError> {
+        self.starknet.pending_state.get_nonce_at(contract_address)
+    }
+
     fn call(
         &self,
         block_id: BlockId,
         function_call: ExternalFunctionCall,
     ) -> Result<Vec<StarkFelt>> {
         let block_number = self.starknet.block_number_from_block_id(block_id);
-        let execution_info = self.starknet.call(function_call, block_number)?;
-        Ok(execution_info.execution.retdata.0)
+        let Some(cache) = &self.call_cache else {
+            let execution_info = self.starknet.call(function_call, block_number)?;
+            return Ok(execution_info.execution.retdata.0);
+        };
+        if let Some(block_number) = block_number {
+            self.starknet.check_query_depth(block_number)?;
+        }
+
+        let key = CallCacheKey {
+            contract_address: function_call.contract_address,
+            entry_point_selector: function_call.entry_point_selector,
+            calldata: function_call.calldata.clone(),
+            state: match block_number {
+                Some(block_number) => CallState::Block(block_number),
+                None => self.pending_call_state(),
+            },
+        };
+
+        if let Some(retdata) = cache.lock().unwrap().get(&key) {
+            return Ok(retdata);
+        }
+
+        let retdata = self
+            .starknet
+            .call(function_call, block_number)?
+            .execution
+            .retdata
+            .0;
+        cache.lock().unwrap().insert(key, retdata.clone());
+        Ok(retdata)
     }
 
     fn transaction(
@@ -354,11 +513,259 @@ impl Sequencer for KatanaSequencer {
         self.starknet.generate_pending_block();
         Ok(())
     }
+
+    fn execution_resources(&self, block_id: BlockId) -> Option<HashMap<String, usize>> {
+        let block = self.block(block_id)?;
+
+        let mut resources = HashMap::new();
+        for tx in block.transactions() {
+            let Some(execution_info) = self
+                .starknet
+                .transactions
+                .transactions
+                .get(&tx.transaction_hash())
+                .and_then(|tx| tx.execution_info.as_ref())
+            else {
+                continue;
+            };
+
+            for (resource, amount) in &execution_info.actual_resources.0 {
+                *resources.entry(resource.clone()).or_insert(0) += amount;
+            }
+        }
+
+        Some(resources)
+    }
+
+    fn block_hash_and_number_by_timestamp(
+        &self,
+        timestamp: BlockTimestamp,
+    ) -> Option<(BlockHash, BlockNumber)> {
+        let block_number = self.starknet.blocks.block_number_by_timestamp(timestamp)?;
+        let block = self.starknet.blocks.by_number(block_number)?;
+        Some((block.block_hash(), block_number))
+    }
+
+    fn transaction_state_diff(&self, hash: &TransactionHash) -> Option<StateDiff> {
+        self.starknet
+            .transactions
+            .transactions
+            .get(hash)
+            .and_then(|tx| tx.state_diff.clone())
+    }
+
+    fn transaction_status(&self, hash: &TransactionHash) -> Option<TransactionStatus> {
+        self.starknet
+            .transactions
+            .transactions
+            .get(hash)
+            .map(|tx| tx.status)
+    }
+
+    fn transaction_receipt(&self, hash: &TransactionHash) -> Option<TransactionReceipt> {
+        self.starknet
+            .transactions
+            .transactions
+            .get(hash)
+            .map(|tx| tx.receipt())
+    }
+
+    // Mined transactions paid the gas price of their block, while pending ones will pay the
+    // gas price of the block being built. The price is clamped to the highest one the max fee
+    // of the transaction covers for the gas it used, so that transactions with a zero max fee,
+    // which aren't charged, pay nothing.
+    fn effective_gas_price(&self, hash: &TransactionHash) -> Option<u128> {
+        let tx = self.starknet.transactions.transactions.get(hash)?;
+        let gas_price = match tx.block_number {
+            Some(block_number) => self
+                .starknet
+                .blocks
+                .by_number(block_number)
+                .map(|block| block.header().gas_price.0)?,
+            None if tx.status == TransactionStatus::Pending => {
+                self.starknet.block_context.gas_price
+            }
+            None => return None,
+        };
+
+        let gas_usage = tx.actual_fee().0.checked_div(gas_price).unwrap_or_default();
+        let max_gas_price =
+            get_transaction_max_fee(&tx.inner).and_then(|max_fee| max_fee.0.checked_div(gas_usage));
+
+        Some(max_gas_price.map_or(gas_price, |max_gas_price| gas_price.min(max_gas_price)))
+    }
+
+    fn pending_state_diff(&self) -> StateDiff {
+        convert_state_diff_to_rpc_state_diff(self.starknet.pending_state.to_state_diff())
+    }
+
+    // Returns the hashes of the mined transactions of the sender within the block range,
+    // newest first
+    fn transactions_by_sender(
+        &self,
+        sender: ContractAddress,
+        from_block: BlockId,
+        to_block: BlockId,
+    ) -> Result<Vec<TransactionHash>, blockifier::state::errors::StateError> {
+        let from_block = self.starknet.block_number_from_block_id(from_block).ok_or(
+            blockifier::state::errors::StateError::StateReadError(
+                "invalid `from_block`; block not found".into(),
+            ),
+        )?;
+        let to_block = self.starknet.block_number_from_block_id(to_block).ok_or(
+            blockifier::state::errors::StateError::StateReadError(
+                "invalid `to_block`; block not found".into(),
+            ),
+        )?;
+
+        Ok(self
+            .starknet
+            .transactions
+            .sender_index
+            .get(&sender)
+            .map(|txs| {
+                txs.iter()
+                    .rev()
+                    .filter(|(block_number, _)| (from_block..=to_block).contains(block_number))
+                    .map(|(_, hash)| *hash)
+                    .collect()
+            })
+            .unwrap_or_default())
+    }
+
+    // Includes the contracts deployed by other contracts, as they are read from the state diff
+    fn deployed_contracts(&self, block_id: BlockId) -> Option<Vec<DeployedContractItem>> {
+        let state_diff = match block_id {
+            BlockId::Tag(BlockTag::Pending) => self.pending_state_diff(),
+            block_id => {
+                self.state_update(block_id)
+                    .ok()?
+                    .pending_state_update
+                    .state_diff
+            }
+        };
+
+        Some(state_diff.deployed_contracts)
+    }
+
+    // Returns the value of the storage slot at the end of each block of the inclusive range
+    fn storage_across_blocks(
+        &self,
+        contract_address: ContractAddress,
+        storage_key: StorageKey,
+        from_block: BlockNumber,
+        to_block: BlockNumber,
+    ) -> Result<Vec<StarkFelt>> {
+        (from_block.0..=to_block.0)
+            .map(|number| {
+                let state = self.starknet.state(BlockNumber(number))?;
+
+                Ok(state
+                    .storage_view
+                    .get(&(contract_address, storage_key))
+                    .copied()
+                    .unwrap_or_default())
+            })
+            .collect()
+    }
+
+    fn override_state_root(&mut self, state_root: StarkFelt) {
+        self.starknet.state_root_override = Some(GlobalRoot(state_root));
+    }
+
+    fn clear_pool(&mut self) -> usize {
+        self.clear_state_caches();
+        self.starknet.clear_pool()
+    }
+
+    fn reorg_to(
+        &mut self,
+        block_number: BlockNumber,
+        transactions: Vec<AccountTransaction>,
+    ) -> Result<StarknetBlock> {
+        self.clear_state_caches();
+        self.starknet.reorg_to(
+            block_number,
+            transactions
+                .into_iter()
+                .map(Transaction::AccountTransaction)
+                .collect(),
+        )
+    }
+
+    fn set_block_number(&mut self, block_number: BlockNumber) -> Result<()> {
+        self.starknet.fast_forward_to(block_number)
+    }
+
+    fn advance_time_and_mine(
+        &mut self,
+        seconds: u64,
+        blocks: u64,
+        distribute: bool,
+    ) -> Result<Vec<StarknetBlock>> {
+        self.starknet
+            .advance_time_and_mine(seconds, blocks, distribute)
+    }
+
+    // Contracts without an introspection entry point, or whose call fails, are reported as not
+    // supporting the interface. Only successful calls are cached, as the contract may be
+    // deployed or upgraded later.
+    fn supports_interface(
+        &mut self,
+        contract_address: ContractAddress,
+        interface_id: StarkFelt,
+    ) -> bool {
+        let state = self.pending_call_state();
+        if let Some((cached_state, supported)) = self
+            .interface_support
+            .get(&(contract_address, interface_id))
+        {
+            if *cached_state == state {
+                return *supported;
+            }
+        }
+
+        let supported = SUPPORTS_INTERFACE_SELECTORS.iter().find_map(|name| {
+            self.call(
+                BlockId::Tag(BlockTag::Pending),
+                ExternalFunctionCall {
+                    contract_address,
+                    entry_point_selector: selector_from_name(name),
+                    calldata: Calldata(vec![interface_id].into()),
+                },
+            )
+            .ok()
+            .map(|retdata| retdata.first() == Some(&stark_felt!(1)))
+        });
+        let Some(supported) = supported else {
+            self.interface_support
+                .remove(&(contract_address, interface_id));
+            return false;
+        };
+
+        self.interface_support
+            .insert((contract_address, interface_id), (state, supported));
+        supported
+    }
 }
 
 pub trait Sequencer {
     fn chain_id(&self) -> ChainId;
 
+    fn executor_config(&self) -> ExecutorConfig;
+
+    fn pool_position(&self, transaction_hash: &TransactionHash) -> Option<PoolPosition>;
+
+    fn oldest_pending_transaction(&self) -> Option<PendingTransactionAge>;
+
+    fn fund_accounts(&mut self, transfers: &[(ContractAddress, u128)]) -> Result<FundingOutcome>;
+
+    fn validate_transaction(
+        &self,
+        transaction: InvokeTransactionV1,
+        block_id: BlockId,
+    ) -> Result<ValidationResult>;
+
     fn generate_new_block(&mut self) -> Result<()>;
 
     fn nonce_at(
@@ -367,6 +774,11 @@ pub trait Sequencer {
         contract_address: ContractAddress,
     ) -> Result<Nonce, blockifier::state::errors::StateError>;
 
+    fn pending_nonce_at(
+        &mut self,
+        contract_address: ContractAddress,
+    ) -> Result<Nonce, blockifier::state::errors::StateError>;
+
     fn block_number(&self) -> BlockNumber;
 
     fn block(&self, block_id: BlockId) -> Option<StarknetBlock>;
@@ -380,6 +792,12 @@ pub trait Sequencer {
         contract_address: ContractAddress,
     ) -> Result<ClassHash, blockifier::state::errors::StateError>;
 
+    fn class_hash_by_compiled_class_hash(
+        &self,
+        block_id: BlockId,
+        compiled_class_hash: CompiledClassHash,
+    ) -> Option<ClassHash>;
+
     fn block_hash_and_number(&self) -> Option<(BlockHash, BlockNumber)>;
 
     fn call(
@@ -393,7 +811,7 @@ pub trait Sequencer {
         contract_address: ContractAddress,
         storage_key: StorageKey,
         block_id: BlockId,
-    ) -> Result<StarkFelt, blockifier::state::errors::StateError>;
+    ) -> Result<StarkFelt>;
 
     fn deploy_account(
         &mut self,
@@ -426,4 +844,63 @@ pub trait Sequencer {
         &self,
         block_id: BlockId,
     ) -> Result<StateUpdate, blockifier::state::errors::StateError>;
+
+    fn execution_resources(&self, block_id: BlockId) -> Option<HashMap<String, usize>>;
+
+    fn block_hash_and_number_by_timestamp(
+        &self,
+        timestamp: BlockTimestamp,
+    ) -> Option<(BlockHash, BlockNumber)>;
+
+    fn transaction_state_diff(&self, hash: &TransactionHash) -> Option<StateDiff>;
+
+    fn transaction_status(&self, hash: &TransactionHash) -> Option<TransactionStatus>;
+
+    fn transaction_receipt(&self, hash: &TransactionHash) -> Option<TransactionReceipt>;
+
+    fn effective_gas_price(&self, hash: &TransactionHash) -> Option<u128>;
+
+    fn pending_state_diff(&self) -> StateDiff;
+
+    fn transactions_by_sender(
+        &self,
+        sender: ContractAddress,
+        from_block: BlockId,
+        to_block: BlockId,
+    ) -> Result<Vec<TransactionHash>, blockifier::state::errors::StateError>;
+
+    fn deployed_contracts(&self, block_id: BlockId) -> Option<Vec<DeployedContractItem>>;
+
+    fn storage_across_blocks(
+        &self,
+        contract_address: ContractAddress,
+        storage_key: StorageKey,
+        from_block: BlockNumber,
+        to_block: BlockNumber,
+    ) -> Result<Vec<StarkFelt>>;
+
+    fn override_state_root(&mut self, state_root: StarkFelt);
+
+    fn clear_pool(&mut self) -> usize;
+
+    fn reorg_to(
+        &mut self,
+        block_number: BlockNumber,
+        transactions: Vec<AccountTransaction>,
+    ) -> Result<StarknetBlock>;
+
+    fn set_block_number(&mut self, block_number: BlockNumber) -> Result<()>;
+
+    fn advance_time_and_mine(
+        &mut self,
+        seconds: u64,
+        blocks: u64,
+        distribute: bool,
+    ) -> Result<Vec<StarknetBlock>>;
+
+    fn supports_interface(
+        &mut self,
+        contract_address: ContractAddress,
+        interface_id: StarkFelt,
+    ) -> bool;
 }
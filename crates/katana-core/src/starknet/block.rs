@@ -154,6 +154,24 @@ impl StarknetBlocks {
         self.num_to_block.get(&block_number).cloned()
     }
 
+    /// Returns the number of the latest block whose timestamp is at or before `timestamp`,
+    /// or `None` if it precedes the genesis block. Block timestamps never decrease, so the
+    /// blocks are binary searched by their number.
+    pub fn block_number_by_timestamp(&self, timestamp: BlockTimestamp) -> Option<BlockNumber> {
+        let (mut low, mut high) = (0, self.total_blocks() as u64);
+
+        while low < high {
+            let mid = low + (high - low) / 2;
+            if self.num_to_block.get(&BlockNumber(mid))?.header().timestamp <= timestamp {
+                low = mid + 1;
+            } else {
+                high = mid;
+            }
+        }
+
+        BlockNumber(low).prev()
+    }
+
     pub fn transaction_by_block_num_and_index(
         &self,
         number: BlockNumber,
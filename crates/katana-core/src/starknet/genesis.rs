@@ -0,0 +1,217 @@
+use std::{
+    fmt, fs,
+    fs::File,
+    io::{BufRead, BufReader, Read},
+    path::Path,
+};
+
+use anyhow::{anyhow, bail, Result};
+use blockifier::execution::entry_point::CallEntryPoint;
+use serde::{
+    de::{self, SeqAccess, Visitor},
+    Deserialize, Deserializer,
+};
+use starknet::core::utils::cairo_short_string_to_felt;
+use starknet_api::{
+    core::{ChainId, ContractAddress, EntryPointSelector, Nonce, PatriciaKey},
+    hash::{pedersen_hash_array, StarkFelt},
+    stark_felt,
+    state::StorageKey,
+    transaction::{Calldata, L1HandlerTransaction, TransactionHash, TransactionVersion},
+};
+
+use super::messaging::compute_l1_to_l2_message_hash;
+
+/// An L1 -> L2 message to be processed in the first block of the chain.
+#[derive(Debug, Clone, Deserialize)]
+pub struct GenesisMessage {
+    /// The L1 address of the message sender.
+    pub from_address: StarkFelt,
+    /// The L2 contract handling the message.
+    pub to_address: ContractAddress,
+    /// The selector of the `l1_handler` entry point.
+    pub selector: EntryPointSelector,
+    pub payload: Vec<StarkFelt>,
+}
+
+/// A call executed after the genesis deployments, to initialize the deployed contracts.
+#[derive(Debug, Clone, Deserialize)]
+pub struct GenesisCall {
+    pub contract_address: ContractAddress,
+    pub selector: EntryPointSelector,
+    #[serde(default)]
+    pub calldata: Vec<StarkFelt>,
+    /// The address the call is made from, zero by default.
+    #[serde(default)]
+    pub caller: ContractAddress,
+}
+
+/// A contract storage value set at genesis.
+#[derive(Debug, Clone, Deserialize)]
+pub struct GenesisStorageEntry {
+    pub contract_address: ContractAddress,
+    pub key: StorageKey,
+    pub value: StarkFelt,
+}
+
+impl GenesisCall {
+    pub fn to_call_entry_point(&self) -> CallEntryPoint {
+        CallEntryPoint {
+            storage_address: self.contract_address,
+            entry_point_selector: self.selector,
+            calldata: Calldata(self.calldata.clone().into()),
+            caller_address: self.caller,
+            ..Default::default()
+        }
+    }
+}
+
+impl GenesisMessage {
+    /// Converts the message into the L1 handler transaction consuming it. The sender address
+    /// is passed to the handler as the first calldata element.
+    pub fn to_l1_handler_transaction(
+        &self,
+        nonce: Nonce,
+        chain_id: &ChainId,
+    ) -> Result<L1HandlerTransaction> {
+        let calldata = Calldata(
+            std::iter::once(self.from_address)
+                .chain(self.payload.iter().copied())
+                .collect::<Vec<_>>()
+                .into(),
+        );
+        let version = TransactionVersion(stark_felt!(0));
+
+        let transaction_hash = TransactionHash(pedersen_hash_array(&[
+            StarkFelt::from(cairo_short_string_to_felt("l1_handler")?),
+            version.0,
+            *self.to_address.0.key(),
+            self.selector.0,
+            pedersen_hash_array(&calldata.0),
+            stark_felt!(0), // max_fee
+            StarkFelt::from(cairo_short_string_to_felt(&chain_id.0)?),
+            nonce.0,
+        ]));
+
+        Ok(L1HandlerTransaction {
+            transaction_hash,
+            version,
+            nonce,
+            contract_address: self.to_address,
+            entry_point_selector: self.selector,
+            calldata,
+        })
+    }
+
+    /// The hash of the message on L1, with the nonce the StarknetMessaging contract assigned
+    /// to it.
+    pub fn message_hash(&self, nonce: Nonce) -> [u8; 32] {
+        compute_l1_to_l2_message_hash(
+            self.from_address,
+            self.to_address,
+            self.selector,
+            &self.payload,
+            nonce,
+        )
+    }
+}
+
+/// Loads the genesis messages from a JSON file containing an array of messages.
+pub fn load_genesis_messages(path: &Path) -> Result<Vec<GenesisMessage>> {
+    let messages = fs::read_to_string(path)?;
+    Ok(serde_json::from_str(&messages)?)
+}
+
+/// Loads the genesis initialization calls from a JSON file containing an array of calls,
+/// in execution order.
+pub fn load_genesis_calls(path: &Path) -> Result<Vec<GenesisCall>> {
+    let calls = fs::read_to_string(path)?;
+    Ok(serde_json::from_str(&calls)?)
+}
+
+/// Streams the genesis storage entries of a file to `f`, one entry at a time so that large
+/// files are never fully loaded in memory. Files with a `.csv` extension contain one
+/// `contract_address,key,value` row per entry, with an optional header row; any other file
+/// must contain a JSON array of entries.
+pub fn stream_genesis_storage<F>(path: &Path, f: F) -> Result<()>
+where
+    F: FnMut(GenesisStorageEntry) -> Result<()>,
+{
+    let reader = BufReader::new(File::open(path)?);
+
+    match path.extension().and_then(|ext| ext.to_str()) {
+        Some("csv") => stream_csv_storage(reader, f),
+        _ => stream_json_storage(reader, f),
+    }
+}
+
+fn stream_csv_storage<R, F>(reader: R, mut f: F) -> Result<()>
+where
+    R: BufRead,
+    F: FnMut(GenesisStorageEntry) -> Result<()>,
+{
+    for (index, line) in reader.lines().enumerate() {
+        let line = line?;
+        let line_number = index + 1;
+
+        let fields = line.split(',').map(str::trim).collect::<Vec<_>>();
+        let [contract_address, key, value] = fields[..] else {
+            if line.trim().is_empty() {
+                continue;
+            }
+            bail!("genesis storage line {line_number}: expected 3 fields");
+        };
+
+        if index == 0 && contract_address == "contract_address" {
+            continue;
+        }
+
+        let parse = |field: &str| {
+            StarkFelt::try_from(field)
+                .map_err(|e| anyhow!("genesis storage line {line_number}: {e}"))
+        };
+
+        f(GenesisStorageEntry {
+            contract_address: ContractAddress(PatriciaKey::try_from(parse(contract_address)?)?),
+            key: StorageKey(PatriciaKey::try_from(parse(key)?)?),
+            value: parse(value)?,
+        })?;
+    }
+
+    Ok(())
+}
+
+fn stream_json_storage<R, F>(reader: R, f: F) -> Result<()>
+where
+    R: Read,
+    F: FnMut(GenesisStorageEntry) -> Result<()>,
+{
+    struct EntriesVisitor<F>(F);
+
+    impl<'de, F> Visitor<'de> for EntriesVisitor<F>
+    where
+        F: FnMut(GenesisStorageEntry) -> Result<()>,
+    {
+        type Value = ();
+
+        fn expecting(&self, formatter: &mut fmt::Formatter) -> fmt::Result {
+            formatter.write_str("an array of genesis storage entries")
+        }
+
+        fn visit_seq<A>(mut self, mut seq: A) -> Result<(), A::Error>
+        where
+            A: SeqAccess<'de>,
+        {
+            while let Some(entry) = seq.next_element()? {
+                (self.0)(entry).map_err(de::Error::custom)?;
+            }
+            Ok(())
+        }
+    }
+
+    let mut deserializer = serde_json::Deserializer::from_reader(reader);
+    deserializer.deserialize_seq(EntriesVisitor(f))?;
+    deserializer.end()?;
+
+    Ok(())
+}
@@ -0,0 +1,29 @@
+use sha3::{Digest, Keccak256};
+use starknet_api::{
+    core::{ContractAddress, EntryPointSelector, Nonce},
+    hash::StarkFelt,
+};
+
+/// Computes the hash the StarknetMessaging contract on L1 identifies an L1 -> L2 message
+/// with, from its sender, recipient, nonce, handler selector and payload. Keccak hashes may
+/// exceed the field size, so the hash is returned as raw bytes.
+pub fn compute_l1_to_l2_message_hash(
+    from_address: StarkFelt,
+    to_address: ContractAddress,
+    selector: EntryPointSelector,
+    payload: &[StarkFelt],
+    nonce: Nonce,
+) -> [u8; 32] {
+    // Each value is abi-encoded as a 32 bytes word, as in `abi.encodePacked` over uint256s
+    let mut hasher = Keccak256::new();
+    hasher.update(from_address.bytes());
+    hasher.update(to_address.0.key().bytes());
+    hasher.update(nonce.0.bytes());
+    hasher.update(selector.0.bytes());
+    hasher.update(StarkFelt::from(payload.len() as u64).bytes());
+    for value in payload {
+        hasher.update(value.bytes());
+    }
+
+    hasher.finalize().into()
+}
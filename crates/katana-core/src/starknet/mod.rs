@@ -1,52 +1,109 @@
-use std::path::PathBuf;
+use std::{
+    collections::{HashMap, HashSet, VecDeque},
+    path::PathBuf,
+    str::FromStr,
+    time::{Duration, Instant},
+};
 
-use anyhow::{anyhow, Result};
+use anyhow::{anyhow, bail, ensure, Result};
 use blockifier::{
+    abi::abi_utils::{get_storage_var_address, selector_from_name},
     block_context::BlockContext,
     execution::entry_point::{CallEntryPoint, CallInfo, ExecutionContext},
     state::{
         cached_state::{CachedState, CommitmentStateDiff, MutRefState},
-        state_api::State,
+        state_api::{State, StateReader},
     },
     transaction::{
         account_transaction::AccountTransaction,
-        errors::TransactionExecutionError,
         objects::{AccountTransactionContext, TransactionExecutionInfo},
         transaction_execution::Transaction,
-        transactions::{DeclareTransaction, ExecutableTransaction},
+        transactions::ExecutableTransaction,
     },
 };
+use serde::Serialize;
 use starknet::{
     core::types::{FieldElement, TransactionStatus},
     providers::jsonrpc::models::{BlockId, BlockTag, PendingStateUpdate, StateUpdate},
 };
 use starknet_api::{
     block::{BlockHash, BlockNumber, BlockTimestamp, GasPrice},
-    core::GlobalRoot,
+    core::{ClassHash, ContractAddress, GlobalRoot, Nonce},
     hash::StarkFelt,
     stark_felt,
+    transaction::{
+        Fee, InvokeTransaction, InvokeTransactionV1, TransactionHash, TransactionVersion,
+    },
 };
-use tracing::info;
+use tracing::{info, warn};
 
 pub mod block;
 pub mod event;
+pub mod genesis;
+pub mod messaging;
+pub mod ordering;
 pub mod transaction;
 
 use crate::{
     accounts::PredeployedAccounts,
     block_context::block_context_from_config,
-    constants::DEFAULT_PREFUNDED_ACCOUNT_BALANCE,
+    constants::{
+        DEFAULT_ACCOUNT_CONTRACT_CLASS_HASH, DEFAULT_BASE_FEE_CHANGE_DENOMINATOR,
+        DEFAULT_DEDUP_CACHE_SIZE, DEFAULT_GAS_PRICE, DEFAULT_PREFUNDED_ACCOUNT_BALANCE,
+    },
+    dedup_cache::DedupCache,
+    schedule::BlockSchedule,
     state::DictStateReader,
     util::{
         convert_blockifier_tx_to_starknet_api_tx, convert_state_diff_to_rpc_state_diff,
-        get_current_timestamp,
+        get_calldata_len, get_current_timestamp, get_max_fee, get_sender_address,
+        starkfelt_to_u128,
     },
+    webhook::{ChainReorg, RejectedTransaction, RejectionWebhook},
 };
 use block::{StarknetBlock, StarknetBlocks};
-use transaction::{StarknetTransaction, StarknetTransactions};
+use genesis::{stream_genesis_storage, GenesisCall, GenesisMessage};
+use ordering::{OrderingDecision, OrderingTrace};
+use transaction::{ordered_events, StarknetTransaction, StarknetTransactions, ValidationResult};
 
 use self::transaction::ExternalFunctionCall;
 
+/// The maximum number of transfers batched in a single funding transaction.
+const MAX_TRANSFERS_PER_FUNDING_TRANSACTION: usize = 100;
+/// The L1 gas budgeted per transfer to set the max fee of the funding transactions.
+const FUNDING_GAS_PER_TRANSFER: u128 = 100_000;
+
+/// The default number of seconds between two consecutive blocks with the step timestamp
+/// source, which deterministic mode uses.
+pub const DETERMINISTIC_BLOCK_TIME_STEP: u64 = 1;
+
+/// The maximum number of blocks a single fast forward of the block number can mine.
+pub const MAX_BLOCK_NUMBER_STEP: u64 = 1_000;
+
+/// Where the timestamps of the blocks come from.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum TimestampSource {
+    /// The system time, which may go backward when the system clock is adjusted.
+    Wall,
+    /// The system time at startup, advanced by a monotonic clock afterwards.
+    Monotonic,
+    /// A fixed number of seconds per block, starting from zero at the genesis block.
+    Step,
+}
+
+impl FromStr for TimestampSource {
+    type Err = anyhow::Error;
+
+    fn from_str(source: &str) -> Result<Self> {
+        match source {
+            "wall" => Ok(Self::Wall),
+            "monotonic" => Ok(Self::Monotonic),
+            "step" => Ok(Self::Step),
+            _ => bail!("unknown timestamp source `{source}`, expected wall, monotonic or step"),
+        }
+    }
+}
+
 #[derive(Debug)]
 pub struct StarknetConfig {
     pub seed: [u8; 32],
@@ -56,6 +113,174 @@ pub struct StarknetConfig {
     pub blocks_on_demand: bool,
     pub allow_zero_max_fee: bool,
     pub account_path: Option<PathBuf>,
+    pub genesis_salt: Option<u64>,
+    /// The salts of the first predeployed accounts, in order, overriding the genesis salt.
+    pub genesis_account_salts: Vec<u64>,
+    /// The balance of the deployer account, which is only predeployed when set.
+    pub genesis_deployer_balance: Option<u128>,
+    /// The nonce the deployer account starts with.
+    pub genesis_deployer_nonce: u64,
+    pub min_txs_per_block: Option<usize>,
+    pub block_wait_timeout: Option<Duration>,
+    pub max_fee_balance_ratio: Option<f64>,
+    /// Whether to reject the transactions whose max fee exceeds the balance of their sender
+    /// before executing them.
+    pub reject_insufficient_balance: bool,
+    pub genesis_messages: Vec<GenesisMessage>,
+    pub genesis_calls: Vec<GenesisCall>,
+    pub genesis_storage: Option<PathBuf>,
+    pub fee_estimate_cache_ttl: Option<Duration>,
+    /// Whether to cache the results of identical calls against unchanged state.
+    pub call_cache: bool,
+    pub trace_ordering: bool,
+    /// The number of transactions per block the gas price adjusts towards. The gas price is
+    /// fixed when unset.
+    pub base_fee_target: Option<usize>,
+    pub base_fee_change_denominator: u128,
+    /// The maximum number of classes declared through transactions, excluding the genesis
+    /// classes.
+    pub max_declared_classes: Option<usize>,
+    /// When to mine blocks, regardless of the mining mode.
+    pub block_schedule: Option<BlockSchedule>,
+    pub deploy_accounts_as_txs: bool,
+    pub timestamp_source: TimestampSource,
+    /// The number of seconds between two consecutive blocks with the step timestamp source.
+    pub timestamp_step: u64,
+    pub max_declares_per_block: Option<usize>,
+    /// The total calldata length at which the pending block is sealed, regardless of the
+    /// mining mode.
+    pub max_block_calldata: Option<usize>,
+    /// The number of blocks behind the latest one past which historical state queries are
+    /// refused, even though the state is still stored.
+    pub max_historical_query_depth: Option<u64>,
+    /// The sequencer address, which the fees are paid to and blocks report. Defaults to
+    /// `SEQUENCER_ADDRESS`.
+    pub fee_recipient: Option<ContractAddress>,
+    /// The number of recent transaction hashes retained to reject duplicate submissions. Zero
+    /// disables duplicate detection.
+    pub dedup_cache_size: usize,
+}
+
+impl Default for StarknetConfig {
+    fn default() -> Self {
+        Self {
+            seed: [0; 32],
+            gas_price: DEFAULT_GAS_PRICE,
+            chain_id: String::from("KATANA"),
+            total_accounts: 10,
+            blocks_on_demand: false,
+            allow_zero_max_fee: false,
+            account_path: None,
+            genesis_salt: None,
+            genesis_account_salts: Vec::new(),
+            genesis_deployer_balance: None,
+            genesis_deployer_nonce: 0,
+            min_txs_per_block: None,
+            block_wait_timeout: None,
+            max_fee_balance_ratio: None,
+            reject_insufficient_balance: false,
+            genesis_messages: Vec::new(),
+            genesis_calls: Vec::new(),
+            genesis_storage: None,
+            fee_estimate_cache_ttl: None,
+            call_cache: false,
+            trace_ordering: false,
+            base_fee_target: None,
+            base_fee_change_denominator: DEFAULT_BASE_FEE_CHANGE_DENOMINATOR,
+            max_declared_classes: None,
+            block_schedule: None,
+            deploy_accounts_as_txs: false,
+            timestamp_source: TimestampSource::Wall,
+            timestamp_step: DETERMINISTIC_BLOCK_TIME_STEP,
+            max_declares_per_block: None,
+            max_block_calldata: None,
+            max_historical_query_depth: None,
+            fee_recipient: None,
+            dedup_cache_size: DEFAULT_DEDUP_CACHE_SIZE,
+        }
+    }
+}
+
+/// The error of a transaction whose max fee exceeds the balance of its sender.
+#[derive(Debug, thiserror::Error)]
+#[error("max fee {max_fee} exceeds the sender balance {balance}")]
+pub struct InsufficientAccountBalance {
+    pub max_fee: u128,
+    pub balance: u128,
+}
+
+/// The error of a transaction whose hash was received recently, within the deduplication
+/// window.
+#[derive(Debug, thiserror::Error)]
+#[error("transaction {transaction_hash} was already received")]
+pub struct DuplicateTransaction {
+    pub transaction_hash: TransactionHash,
+}
+
+/// The error of a historical state query against a block further behind the latest block than
+/// the maximum historical query depth.
+#[derive(Debug, thiserror::Error)]
+#[error(
+    "query too deep: block {block_number} is more than {max_depth} blocks behind the latest block"
+)]
+pub struct QueryTooDeep {
+    pub block_number: BlockNumber,
+    pub max_depth: u64,
+}
+
+/// The settings transactions are executed with, including the ones changing at runtime.
+#[derive(Debug, Clone, Serialize)]
+pub struct ExecutorConfig {
+    /// The gas price of the block being built.
+    pub gas_price: u128,
+    pub allow_zero_max_fee: bool,
+    pub max_fee_balance_ratio: Option<f64>,
+    pub base_fee_target: Option<usize>,
+    pub base_fee_change_denominator: u128,
+    pub invoke_tx_max_n_steps: u64,
+    pub validate_max_n_steps: u64,
+    pub max_declares_per_block: Option<usize>,
+    pub max_declared_classes: Option<usize>,
+    pub max_block_calldata: Option<usize>,
+    pub fee_estimate_cache_ttl_ms: Option<u64>,
+    pub call_cache: bool,
+}
+
+/// Where a transaction that is not yet mined stands in the mining order.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize)]
+pub struct PoolPosition {
+    /// The number of unmined transactions that will be included before it.
+    pub position: usize,
+    /// The number of blocks to mine until it is included, counting the one it is included in.
+    pub blocks_until_inclusion: u64,
+}
+
+/// The outcome of funding accounts from the first predeployed account.
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct FundingOutcome {
+    /// The hashes of the funding transactions, including the rejected ones.
+    pub transaction_hashes: Vec<TransactionHash>,
+    pub funded: Vec<ContractAddress>,
+    /// The accounts whose funding transaction was rejected.
+    pub unfunded: Vec<ContractAddress>,
+}
+
+/// How long a transaction has been waiting to be mined.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct PendingTransactionAge {
+    pub transaction_hash: TransactionHash,
+    /// The seconds elapsed since it was received, on the clock of the block timestamps.
+    pub seconds: u64,
+    /// The number of blocks mined since it was received.
+    pub blocks: u64,
+}
+
+// A block cut from the chain by a reorg, along with its state and transactions
+struct DetachedBlock {
+    block: StarknetBlock,
+    state: Option<DictStateReader>,
+    state_update: Option<StateUpdate>,
+    transactions: Vec<(TransactionHash, StarknetTransaction)>,
 }
 
 pub struct StarknetWrapper {
@@ -66,6 +291,30 @@ pub struct StarknetWrapper {
     pub state: DictStateReader,
     pub predeployed_accounts: PredeployedAccounts,
     pub pending_state: CachedState<DictStateReader>,
+    // Notified of the rejected transactions. Spawning it requires a Tokio runtime, so it is
+    // set by the node rather than created from the config.
+    pub rejection_webhook: Option<RejectionWebhook>,
+    // When the first transaction of the pending block was received
+    pub pending_since: Option<Instant>,
+    // Declare transactions deferred to later blocks once the declare limit of the
+    // pending block is reached
+    pub declare_queue: VecDeque<Transaction>,
+    // The ordering decisions of the block producer, when tracing them is enabled
+    pub ordering_trace: Option<OrderingTrace>,
+    // State root to set on the next mined block instead of the computed one
+    pub state_root_override: Option<GlobalRoot>,
+    // Seconds added to the timestamps of the blocks, by advancing the time
+    pub time_offset: u64,
+    // The system time at startup and the matching instant, from which the monotonic
+    // timestamps are derived
+    clock_origin: (Duration, Instant),
+    // The timestamp and the number of the pending block when each transaction not yet mined
+    // was received
+    received_at: HashMap<TransactionHash, (BlockTimestamp, BlockNumber)>,
+    // The hashes of the recently received transactions, to reject resubmissions
+    recent_hashes: DedupCache,
+    // The L1 hashes of the messages consumed by the L1 handler transactions
+    message_hashes: HashMap<TransactionHash, [u8; 32]>,
 }
 
 impl StarknetWrapper {
@@ -76,14 +325,39 @@ impl StarknetWrapper {
         let mut state = DictStateReader::default();
         let pending_state = CachedState::new(state.clone());
 
-        let predeployed_accounts = PredeployedAccounts::initialize(
+        let mut predeployed_accounts = PredeployedAccounts::initialize(
             config.total_accounts,
             config.seed,
             *DEFAULT_PREFUNDED_ACCOUNT_BALANCE,
             config.account_path.clone(),
+            config.genesis_salt,
+            &config.genesis_account_salts,
         )
         .expect("should be able to generate accounts");
-        predeployed_accounts.deploy_accounts(&mut state);
+        if config.deploy_accounts_as_txs {
+            // The accounts are deployed through transactions in the first block, and must be
+            // able to pay for them.
+            predeployed_accounts.fund_accounts(&mut state);
+        } else {
+            predeployed_accounts.deploy_accounts(&mut state);
+        }
+
+        // The deployer is always part of the genesis state, to start with its preset nonce
+        if let Some(balance) = config.genesis_deployer_balance {
+            predeployed_accounts
+                .add_deployer(StarkFelt::from(balance), config.genesis_salt)
+                .expect("should be able to generate the deployer account");
+
+            let deployer = predeployed_accounts.deployer.as_ref().unwrap();
+            deployer.deploy(&mut state);
+            state.address_to_nonce.insert(
+                deployer.account_address,
+                Nonce(StarkFelt::from(config.genesis_deployer_nonce)),
+            );
+        }
+
+        let ordering_trace = config.trace_ordering.then(OrderingTrace::default);
+        let recent_hashes = DedupCache::new(config.dedup_cache_size);
 
         Self {
             state,
@@ -93,17 +367,30 @@ impl StarknetWrapper {
             block_context,
             pending_state,
             predeployed_accounts,
+            rejection_webhook: None,
+            pending_since: None,
+            declare_queue: VecDeque::new(),
+            ordering_trace,
+            state_root_override: None,
+            time_offset: 0,
+            clock_origin: (get_current_timestamp(), Instant::now()),
+            received_at: HashMap::new(),
+            recent_hashes,
+            message_hashes: HashMap::new(),
         }
     }
 
-    pub fn state_from_block_id(&self, block_id: BlockId) -> Option<DictStateReader> {
+    pub fn state_from_block_id(&self, block_id: BlockId) -> Result<DictStateReader> {
         match block_id {
-            BlockId::Tag(BlockTag::Latest) => Some(self.latest_state()),
-            BlockId::Tag(BlockTag::Pending) => Some(self.pending_state()),
+            BlockId::Tag(BlockTag::Latest) => Ok(self.latest_state()),
+            BlockId::Tag(BlockTag::Pending) => Ok(self.pending_state()),
 
-            id => self
-                .block_number_from_block_id(id)
-                .and_then(|n| self.state(n)),
+            id => {
+                let block_number = self
+                    .block_number_from_block_id(id)
+                    .ok_or(anyhow!("block {id:?} not found"))?;
+                self.state(block_number)
+            }
         }
     }
 
@@ -122,43 +409,237 @@ impl StarknetWrapper {
         }
     }
 
-    // Simulate a transaction without modifying the state
+    pub fn block_context_from_block_id(&self, block_id: BlockId) -> Option<BlockContext> {
+        match block_id {
+            BlockId::Tag(BlockTag::Latest) | BlockId::Tag(BlockTag::Pending) => {
+                Some(self.block_context.clone())
+            }
+
+            id => self
+                .block_number_from_block_id(id)
+                .and_then(|n| self.blocks.by_number(n))
+                .map(|block| BlockContext {
+                    block_number: block.block_number(),
+                    block_timestamp: block.header().timestamp,
+                    gas_price: block.header().gas_price.0,
+                    ..self.block_context.clone()
+                }),
+        }
+    }
+
+    // Simulate a transaction on top of the state of the given block without modifying the state
     pub fn simulate_transaction(
         &self,
         transaction: AccountTransaction,
-        state: Option<DictStateReader>,
-    ) -> Result<TransactionExecutionInfo, TransactionExecutionError> {
-        let mut state = CachedState::new(state.unwrap_or(self.pending_state()));
-        transaction.execute(&mut state, &self.block_context)
+        block_id: BlockId,
+    ) -> Result<TransactionExecutionInfo> {
+        let state = self.state_from_block_id(block_id)?;
+        let block_context = self
+            .block_context_from_block_id(block_id)
+            .ok_or(anyhow!("block {block_id:?} not found"))?;
+
+        let mut state = CachedState::new(state);
+        Ok(transaction.execute(&mut state, &block_context)?)
     }
 
     // execute the tx
     pub fn handle_transaction(&mut self, transaction: Transaction) -> Result<()> {
         let api_tx = convert_blockifier_tx_to_starknet_api_tx(&transaction);
+        let transaction_hash = api_tx.transaction_hash();
+        if let Err(err) = self.check_admission(&transaction, &api_tx) {
+            self.notify_rejection(&api_tx, err.to_string());
+            return Err(err);
+        }
+
+        self.received_at.insert(
+            transaction_hash,
+            (
+                self.current_block_timestamp(),
+                self.block_context.block_number,
+            ),
+        );
+
+        if Self::is_declare(&transaction)
+            && (!self.declare_queue.is_empty() || self.declare_limit_reached())
+        {
+            info!("Declare transaction queued for a later block | Transaction hash: {transaction_hash}");
+
+            let reason = if self.declare_limit_reached() {
+                "declare limit of the block reached"
+            } else {
+                "earlier declares are queued"
+            };
+            self.trace_ordering(transaction_hash, OrderingDecision::Deferred(reason.into()));
+
+            self.recent_hashes.insert(transaction_hash);
+            self.declare_queue.push_back(transaction);
+            return Ok(());
+        }
+
+        let calldata_len = get_calldata_len(&api_tx);
+        if self.block_calldata_overflows(calldata_len) {
+            // The transaction doesn't fit in the pending block, and rolls over to the next one
+            self.generate_latest_block()?;
+            self.generate_pending_block();
+        }
+
+        // Only accepted transactions count as received, so a rejected one can be fixed and
+        // submitted again
+        let included = self.execute_transaction(transaction)?;
+        if included {
+            self.recent_hashes.insert(transaction_hash);
+        }
+
+        if included && (self.should_mine_pending_block() || self.block_calldata_full()) {
+            self.generate_latest_block()?;
+            self.generate_pending_block();
+        }
+
+        Ok(())
+    }
+
+    // Deploys the predeployed accounts through transactions if configured to, seeds the
+    // genesis contract storage, runs the genesis initialization calls, then executes the
+    // genesis L1 -> L2 messages as L1 handler transactions, and mines them together in the
+    // first block regardless of the block mining mode. Returns the hashes of the genesis
+    // transactions.
+    pub fn process_genesis(&mut self) -> Result<Vec<TransactionHash>> {
+        if !self.config.deploy_accounts_as_txs
+            && self.config.genesis_calls.is_empty()
+            && self.config.genesis_storage.is_none()
+            && self.config.genesis_messages.is_empty()
+        {
+            return Ok(Vec::new());
+        }
+
+        let mut hashes = Vec::new();
+
+        if self.config.deploy_accounts_as_txs {
+            let transactions = self
+                .predeployed_accounts
+                .accounts
+                .iter()
+                .map(|account| account.deploy_account_transaction(&self.block_context.chain_id))
+                .collect::<Result<Vec<_>>>()?;
+
+            for tx in transactions {
+                hashes.push(tx.transaction_hash);
+                let name = format!(
+                    "genesis deployment of account {}",
+                    tx.contract_address.0.key()
+                );
+                self.execute_genesis_transaction(
+                    Transaction::AccountTransaction(AccountTransaction::DeployAccount(tx)),
+                    &name,
+                )?;
+            }
+        }
+
+        if let Some(path) = self.config.genesis_storage.clone() {
+            let state = &mut self.pending_state;
+            stream_genesis_storage(&path, |entry| {
+                ensure!(
+                    state.get_class_hash_at(entry.contract_address)? != ClassHash::default(),
+                    "genesis storage references undeployed contract {}",
+                    entry.contract_address.0.key()
+                );
+                state.set_storage_at(entry.contract_address, entry.key, entry.value);
+                Ok(())
+            })?;
+        }
+
+        for (index, call) in self.config.genesis_calls.iter().enumerate() {
+            call.to_call_entry_point()
+                .execute(
+                    &mut self.pending_state,
+                    &mut ExecutionContext::new(
+                        self.block_context.clone(),
+                        AccountTransactionContext::default(),
+                    ),
+                )
+                .map_err(|e| anyhow!("genesis call {index} failed: {e}"))?;
+        }
+
+        let transactions = self
+            .config
+            .genesis_messages
+            .iter()
+            .enumerate()
+            .map(|(nonce, message)| {
+                let nonce = Nonce(stark_felt!(nonce as u64));
+                Ok((
+                    message.to_l1_handler_transaction(nonce, &self.block_context.chain_id)?,
+                    message.message_hash(nonce),
+                ))
+            })
+            .collect::<Result<Vec<_>>>()?;
+
+        for (index, (tx, message_hash)) in transactions.into_iter().enumerate() {
+            hashes.push(tx.transaction_hash);
+            self.message_hashes
+                .insert(tx.transaction_hash, message_hash);
+            self.execute_genesis_transaction(
+                Transaction::L1HandlerTransaction(tx),
+                &format!("genesis message {index}"),
+            )?;
+        }
+
+        self.generate_latest_block()?;
+        self.generate_pending_block();
+
+        Ok(hashes)
+    }
+
+    // Executes a genesis transaction, failing the genesis if the transaction is rejected
+    fn execute_genesis_transaction(&mut self, transaction: Transaction, name: &str) -> Result<()> {
+        let transaction_hash =
+            convert_blockifier_tx_to_starknet_api_tx(&transaction).transaction_hash();
+        if self.execute_transaction(transaction)? {
+            return Ok(());
+        }
+
+        let reason = self
+            .transactions
+            .transactions
+            .get(&transaction_hash)
+            .and_then(|tx| tx.execution_error.as_ref())
+            .map(|err| err.to_string())
+            .unwrap_or_default();
+        bail!("{name} failed: {reason}")
+    }
+
+    // Executes the tx on top of the pending state, returning whether it was included in
+    // the pending block
+    fn execute_transaction(&mut self, transaction: Transaction) -> Result<bool> {
+        let api_tx = convert_blockifier_tx_to_starknet_api_tx(&transaction);
 
         info!(
             "Transaction received | Transaction hash: {}",
             api_tx.transaction_hash()
         );
 
+        // The tx runs in its own layer on top of the pending state, which only holds the
+        // writes of the tx and is committed to the pending state if the tx is included
+        let mut tx_state = CachedState::new(MutRefState::new(&mut self.pending_state));
+
         let res = match transaction {
-            Transaction::AccountTransaction(tx) => {
-                self.check_tx_fee(&tx);
-                tx.execute(&mut self.pending_state, &self.block_context)
-            }
-            Transaction::L1HandlerTransaction(tx) => {
-                tx.execute(&mut self.pending_state, &self.block_context)
-            }
+            Transaction::AccountTransaction(tx) => tx.execute(&mut tx_state, &self.block_context),
+            Transaction::L1HandlerTransaction(tx) => tx.execute(&mut tx_state, &self.block_context),
         };
 
         match res {
             Ok(exec_info) => {
-                let starknet_tx = StarknetTransaction::new(
+                let state_diff = tx_state.to_state_diff();
+                tx_state.commit();
+
+                let tx_hash = api_tx.transaction_hash();
+                let mut starknet_tx = StarknetTransaction::new(
                     api_tx.clone(),
                     TransactionStatus::Pending,
                     Some(exec_info),
                     None,
                 );
+                starknet_tx.state_diff = Some(convert_state_diff_to_rpc_state_diff(state_diff));
 
                 //  append successful tx to pending block
                 self.blocks
@@ -168,14 +649,18 @@ impl StarknetWrapper {
                     .insert_transaction(api_tx);
 
                 self.store_transaction(starknet_tx);
+                self.pending_since.get_or_insert_with(Instant::now);
+                self.trace_ordering(tx_hash, OrderingDecision::Selected);
 
-                if !self.config.blocks_on_demand {
-                    self.generate_latest_block()?;
-                    self.generate_pending_block();
-                }
+                Ok(true)
             }
 
             Err(exec_err) => {
+                let reason = exec_err.to_string();
+                let tx_hash = api_tx.transaction_hash();
+
+                self.notify_rejection(&api_tx, reason.clone());
+
                 let tx = StarknetTransaction::new(
                     api_tx,
                     TransactionStatus::Rejected,
@@ -184,10 +669,11 @@ impl StarknetWrapper {
                 );
 
                 self.store_transaction(tx);
+                self.trace_ordering(tx_hash, OrderingDecision::Rejected(reason));
+
+                Ok(false)
             }
         }
-
-        Ok(())
     }
 
     // Creates a new block that contains all the pending txs
@@ -201,6 +687,10 @@ impl StarknetWrapper {
             self.create_new_empty_block()
         };
 
+        if let Some(state_root) = self.state_root_override.take() {
+            new_block.inner.header.state_root = state_root;
+        }
+
         let block_hash = new_block.compute_block_hash();
         new_block.inner.header.block_hash = block_hash;
 
@@ -214,6 +704,14 @@ impl StarknetWrapper {
                 tx.status = TransactionStatus::AcceptedOnL2;
                 tx.block_number = Some(new_block.block_number());
             }
+
+            if let Some(sender) = get_sender_address(pending_tx) {
+                self.transactions
+                    .sender_index
+                    .entry(sender)
+                    .or_default()
+                    .push((new_block.block_number(), tx_hash));
+            }
         }
 
         info!(
@@ -244,6 +742,10 @@ impl StarknetWrapper {
             },
         );
 
+        if let Some(trace) = &mut self.ordering_trace {
+            trace.flush(new_block.block_number());
+        }
+
         // reset the pending block
         self.blocks.pending_block = None;
 
@@ -252,24 +754,528 @@ impl StarknetWrapper {
 
         self.apply_state_diff_to_state(pending_state_diff);
 
-        self.update_block_context();
+        self.update_block_context(new_block.transactions().len());
 
         Ok(new_block)
     }
 
+    // Mines the pending block if its transactions have been waiting for the minimum
+    // transaction count for longer than the configured timeout
+    pub fn mine_timed_out_pending_block(&mut self) -> Result<()> {
+        if self.pending_block_timed_out() {
+            self.generate_latest_block()?;
+            self.generate_pending_block();
+        }
+
+        Ok(())
+    }
+
     pub fn generate_pending_block(&mut self) {
+        self.generate_empty_pending_block();
+        self.process_queued_declares();
+
+        // Forget the transactions that were mined, rejected or dropped
+        let pending = self.pool_hashes();
+        self.received_at.retain(|hash, _| pending.contains(hash));
+    }
+
+    // Opens a new pending block on top of the latest committed state, without moving the
+    // queued declares into it
+    fn generate_empty_pending_block(&mut self) {
+        self.pending_since = None;
         self.blocks.pending_block = Some(self.create_new_empty_block());
         // Update the pending state to the latest committed state
         self.pending_state = CachedState::new(self.state.clone());
     }
 
+    /// Returns the L1 hash of the message consumed by the L1 handler transaction.
+    pub fn message_hash(&self, transaction_hash: &TransactionHash) -> Option<[u8; 32]> {
+        self.message_hashes.get(transaction_hash).copied()
+    }
+
+    // Returns the transaction that has been waiting the longest to be mined, among the ones
+    // executed in the pending block and the queued declares.
+    pub fn oldest_pending_transaction(&self) -> Option<PendingTransactionAge> {
+        let now = self.current_block_timestamp();
+
+        self.pool_hashes()
+            .into_iter()
+            .filter_map(|hash| {
+                self.received_at
+                    .get(&hash)
+                    .map(|(timestamp, number)| (hash, *timestamp, *number))
+            })
+            .min_by_key(|(_, timestamp, _)| *timestamp)
+            .map(
+                |(transaction_hash, timestamp, number)| PendingTransactionAge {
+                    transaction_hash,
+                    seconds: now.0.saturating_sub(timestamp.0),
+                    blocks: self.block_context.block_number.0.saturating_sub(number.0),
+                },
+            )
+    }
+
+    // The hashes of the transactions not yet mined, in the order they will be mined
+    fn pool_hashes(&self) -> Vec<TransactionHash> {
+        let pending = self
+            .blocks
+            .pending_block
+            .as_ref()
+            .map(|block| block.transactions())
+            .unwrap_or_default();
+
+        pending
+            .iter()
+            .map(|tx| tx.transaction_hash())
+            .chain(
+                self.declare_queue
+                    .iter()
+                    .map(|tx| convert_blockifier_tx_to_starknet_api_tx(tx).transaction_hash()),
+            )
+            .collect()
+    }
+
+    // Drops every transaction that is not yet mined, both the ones executed in the pending
+    // block and the queued declares, and returns how many were removed. The pending state is
+    // reset to the latest committed state.
+    pub fn clear_pool(&mut self) -> usize {
+        let pending_hashes = self
+            .blocks
+            .pending_block
+            .as_ref()
+            .map(|block| {
+                block
+                    .transactions()
+                    .iter()
+                    .map(|tx| tx.transaction_hash())
+                    .collect::<Vec<_>>()
+            })
+            .unwrap_or_default();
+
+        // The cleared transactions can be submitted again
+        for hash in &pending_hashes {
+            self.transactions.transactions.remove(hash);
+            self.recent_hashes.remove(hash);
+        }
+        for transaction in &self.declare_queue {
+            self.recent_hashes
+                .remove(&convert_blockifier_tx_to_starknet_api_tx(transaction).transaction_hash());
+        }
+
+        let removed = pending_hashes.len() + self.declare_queue.len();
+        self.declare_queue.clear();
+        self.generate_pending_block();
+
+        removed
+    }
+
+    pub fn executor_config(&self) -> ExecutorConfig {
+        ExecutorConfig {
+            gas_price: self.block_context.gas_price,
+            allow_zero_max_fee: self.config.allow_zero_max_fee,
+            max_fee_balance_ratio: self.config.max_fee_balance_ratio,
+            base_fee_target: self.config.base_fee_target,
+            base_fee_change_denominator: self.config.base_fee_change_denominator,
+            invoke_tx_max_n_steps: self.block_context.invoke_tx_max_n_steps as u64,
+            validate_max_n_steps: self.block_context.validate_max_n_steps as u64,
+            max_declares_per_block: self.config.max_declares_per_block,
+            max_declared_classes: self.config.max_declared_classes,
+            max_block_calldata: self.config.max_block_calldata,
+            fee_estimate_cache_ttl_ms: self
+                .config
+                .fee_estimate_cache_ttl
+                .map(|ttl| ttl.as_millis() as u64),
+            call_cache: self.config.call_cache,
+        }
+    }
+
+    // Reverts the chain to the state at the end of `block_number`, dropping the later blocks
+    // and every transaction that is not yet mined, then mines the replacement transactions in
+    // a new block on top of it. Returns the new tip of the chain. The dropped blocks are
+    // restored if the replacement block can't be mined, and the webhook is notified of the
+    // reorg otherwise.
+    pub fn reorg_to(
+        &mut self,
+        block_number: BlockNumber,
+        transactions: Vec<Transaction>,
+    ) -> Result<StarknetBlock> {
+        let latest = self
+            .blocks
+            .current_block_number()
+            .ok_or(anyhow!("no block has been mined yet"))?;
+        ensure!(
+            block_number <= latest,
+            "block {block_number} is past the latest block {latest}"
+        );
+        let state = self
+            .blocks
+            .get_state(&block_number)
+            .cloned()
+            .ok_or(anyhow!("state of block {block_number} not found"))?;
+
+        self.clear_pool();
+
+        // The dropped blocks are set aside rather than deleted, to restore the chain if the
+        // replacement block can't be mined
+        let dropped = (block_number.0 + 1..=latest.0)
+            .filter_map(|number| self.detach_block(BlockNumber(number)))
+            .collect::<Vec<_>>();
+        let sender_index = self.transactions.sender_index.clone();
+        for mined in self.transactions.sender_index.values_mut() {
+            mined.retain(|(number, _)| *number <= block_number);
+        }
+        let chain_state = std::mem::replace(&mut self.state, state);
+        let block_context = self.block_context.clone();
+
+        self.block_context.block_number = block_number.next();
+        self.block_context.block_timestamp = self.current_block_timestamp();
+        // The first dropped block was built with the gas price following the target block
+        if let Some(dropped) = dropped.first() {
+            self.block_context.gas_price = dropped.block.header().gas_price.0;
+        }
+        self.generate_pending_block();
+
+        let mut replacements = Vec::new();
+        let tip = match self.mine_replacement_block(transactions, &mut replacements) {
+            Ok(tip) => tip,
+            Err(err) => {
+                for hash in &replacements {
+                    self.transactions.transactions.remove(hash);
+                    self.recent_hashes.remove(hash);
+                }
+                self.blocks.num_to_state_update.remove(&block_number.next());
+                for dropped in dropped {
+                    self.attach_block(dropped);
+                }
+                self.transactions.sender_index = sender_index;
+                self.state = chain_state;
+                self.block_context = block_context;
+                self.generate_pending_block();
+
+                return Err(err);
+            }
+        };
+        self.generate_pending_block();
+
+        // The dropped transactions not included again can be submitted again
+        let included = tip
+            .transactions()
+            .iter()
+            .map(|tx| tx.transaction_hash())
+            .collect::<HashSet<_>>();
+        let dropped_transactions = dropped
+            .iter()
+            .flat_map(|dropped| dropped.block.transactions())
+            .map(|tx| tx.transaction_hash())
+            .filter(|hash| !included.contains(hash))
+            .collect::<Vec<_>>();
+        for hash in &dropped_transactions {
+            self.recent_hashes.remove(hash);
+            self.message_hashes.remove(hash);
+        }
+
+        warn!(
+            "Chain reorganized | Common ancestor: {} | New tip: {} ({})",
+            block_number,
+            tip.block_number(),
+            tip.block_hash()
+        );
+        if let Some(webhook) = &self.rejection_webhook {
+            webhook.notify_reorg(ChainReorg {
+                common_ancestor: block_number.0,
+                new_tip_number: tip.block_number().0,
+                new_tip_hash: tip.block_hash().0,
+                dropped_transactions: dropped_transactions.iter().map(|hash| hash.0).collect(),
+            });
+        }
+
+        Ok(tip)
+    }
+
+    // Executes the replacement transactions of a reorg in the pending block and mines it,
+    // recording the hashes of the executed transactions
+    fn mine_replacement_block(
+        &mut self,
+        transactions: Vec<Transaction>,
+        replacements: &mut Vec<TransactionHash>,
+    ) -> Result<StarknetBlock> {
+        for transaction in transactions {
+            let transaction_hash =
+                convert_blockifier_tx_to_starknet_api_tx(&transaction).transaction_hash();
+            replacements.push(transaction_hash);
+            if self.execute_transaction(transaction)? {
+                self.recent_hashes.insert(transaction_hash);
+            }
+        }
+
+        self.generate_latest_block()
+    }
+
+    // Removes the block from the chain along with its state and transactions
+    fn detach_block(&mut self, block_number: BlockNumber) -> Option<DetachedBlock> {
+        let block = self.blocks.num_to_block.remove(&block_number)?;
+        self.blocks.hash_to_num.remove(&block.block_hash());
+
+        let transactions = block
+            .transactions()
+            .iter()
+            .filter_map(|tx| {
+                self.transactions
+                    .transactions
+                    .remove_entry(&tx.transaction_hash())
+            })
+            .collect();
+
+        Some(DetachedBlock {
+            state: self.blocks.state_archive.remove(&block_number),
+            state_update: self.blocks.num_to_state_update.remove(&block_number),
+            transactions,
+            block,
+        })
+    }
+
+    // Puts back a block removed by `detach_block`
+    fn attach_block(&mut self, detached: DetachedBlock) {
+        let block_number = detached.block.block_number();
+
+        if let Some(state) = detached.state {
+            self.blocks.state_archive.insert(block_number, state);
+        }
+        if let Some(state_update) = detached.state_update {
+            self.blocks
+                .num_to_state_update
+                .insert(block_number, state_update);
+        }
+        for (hash, tx) in detached.transactions {
+            self.recent_hashes.insert(hash);
+            self.transactions.transactions.insert(hash, tx);
+        }
+        self.blocks
+            .hash_to_num
+            .insert(detached.block.block_hash(), block_number);
+        self.blocks
+            .num_to_block
+            .insert(block_number, detached.block);
+    }
+
+    // Transfers fee tokens from the first predeployed account to each account, batching the
+    // transfers in as few transactions as possible. A rejected transaction leaves all of its
+    // accounts unfunded, without affecting the other transactions.
+    pub fn fund_accounts(
+        &mut self,
+        transfers: &[(ContractAddress, u128)],
+    ) -> Result<FundingOutcome> {
+        let funder = self
+            .predeployed_accounts
+            .accounts
+            .first()
+            .cloned()
+            .ok_or(anyhow!("there is no predeployed account to fund from"))?;
+        ensure!(
+            funder.class_hash.0 == *DEFAULT_ACCOUNT_CONTRACT_CLASS_HASH,
+            "funding requires the predeployed accounts to use the default account class"
+        );
+
+        let mut outcome = FundingOutcome::default();
+        for batch in transfers.chunks(MAX_TRANSFERS_PER_FUNDING_TRANSACTION) {
+            let nonce = self.pending_state.get_nonce_at(funder.account_address)?;
+            let max_fee = self
+                .block_context
+                .gas_price
+                .saturating_mul(FUNDING_GAS_PER_TRANSFER)
+                .saturating_mul(batch.len() as u128)
+                .max(1);
+            let transaction = funder.transfer_transaction(
+                batch,
+                nonce,
+                Fee(max_fee),
+                &self.block_context.chain_id,
+            )?;
+            let transaction_hash = transaction.transaction_hash;
+
+            let accepted = self
+                .handle_transaction(Transaction::AccountTransaction(AccountTransaction::Invoke(
+                    InvokeTransaction::V1(transaction),
+                )))
+                .is_ok()
+                && self
+                    .transactions
+                    .transactions
+                    .get(&transaction_hash)
+                    .map_or(false, |tx| {
+                        !matches!(tx.status, TransactionStatus::Rejected)
+                    });
+
+            let recipients = batch.iter().map(|(address, _)| *address);
+            if accepted {
+                outcome.funded.extend(recipients);
+            } else {
+                warn!("Funding transaction rejected | Transaction hash: {transaction_hash}");
+                outcome.unfunded.extend(recipients);
+            }
+            outcome.transaction_hashes.push(transaction_hash);
+        }
+
+        Ok(outcome)
+    }
+
+    // Mines blocks until the latest one is `block_number`. Only the first mined block holds
+    // the transactions of the pending block, the others are empty, and the queued declares
+    // wait for the pending block opened on top of the last one, which is mined right away if
+    // the mining mode says so. The chain can't be moved back this way, nor forward by more
+    // than `MAX_BLOCK_NUMBER_STEP` blocks at once.
+    pub fn fast_forward_to(&mut self, block_number: BlockNumber) -> Result<()> {
+        let latest = self.blocks.current_block_number();
+        if let Some(latest) = latest {
+            ensure!(
+                block_number >= latest,
+                "block {block_number} is before the latest block {latest}"
+            );
+        }
+
+        let step = block_number.0 - latest.map_or(0, |latest| latest.0);
+        ensure!(
+            step <= MAX_BLOCK_NUMBER_STEP,
+            "block {block_number} is more than {MAX_BLOCK_NUMBER_STEP} blocks ahead of the latest block"
+        );
+
+        while self.blocks.current_block_number() < Some(block_number) {
+            self.generate_latest_block()?;
+            if self.blocks.current_block_number() < Some(block_number) {
+                self.generate_empty_pending_block();
+            } else {
+                self.generate_pending_block();
+            }
+        }
+
+        Ok(())
+    }
+
+    // Advances the time by `seconds` and mines `blocks` blocks, the first one with the
+    // transactions of the pending block. The time is either spread evenly across the blocks,
+    // or added entirely before the first one. Returns the mined blocks.
+    pub fn advance_time_and_mine(
+        &mut self,
+        seconds: u64,
+        blocks: u64,
+        distribute: bool,
+    ) -> Result<Vec<StarknetBlock>> {
+        ensure!(blocks > 0, "at least one block must be mined");
+
+        let mut mined = Vec::with_capacity(blocks as usize);
+        for i in 0..blocks {
+            let step = if distribute {
+                // The remainder goes to the first blocks, one second each
+                seconds / blocks + u64::from(i < seconds % blocks)
+            } else if i == 0 {
+                seconds
+            } else {
+                0
+            };
+
+            self.time_offset = self.time_offset.saturating_add(step);
+            self.block_context.block_timestamp = self.current_block_timestamp();
+            if let Some(pending) = self.blocks.pending_block.as_mut() {
+                pending.inner.header.timestamp = self.block_context.block_timestamp;
+            }
+
+            mined.push(self.generate_latest_block()?);
+            self.generate_pending_block();
+        }
+
+        Ok(mined)
+    }
+
+    // Transactions executed in the pending block are included in the next mined block, while
+    // queued declares fill the following blocks up to their declare limit, in order.
+    pub fn pool_position(&self, transaction_hash: &TransactionHash) -> Option<PoolPosition> {
+        let position = self
+            .pool_hashes()
+            .iter()
+            .position(|hash| hash == transaction_hash)?;
+
+        let pending = self.pending_transaction_count();
+        if position < pending {
+            return Some(PoolPosition {
+                position,
+                blocks_until_inclusion: 1,
+            });
+        }
+
+        // The declare queue is only used when there is a declare limit
+        let max_declares = self.config.max_declares_per_block.unwrap_or(1).max(1);
+        Some(PoolPosition {
+            position,
+            blocks_until_inclusion: 2 + ((position - pending) / max_declares) as u64,
+        })
+    }
+
+    // Moves as many queued declare transactions into the new pending block as its
+    // declare limit allows, and mines it right away if the mining mode says so, until the
+    // queue is drained or a pending block is left open
+    fn process_queued_declares(&mut self) {
+        loop {
+            let mut moved = false;
+            while !self.declare_limit_reached() {
+                let Some(transaction) = self.declare_queue.pop_front() else {
+                    break;
+                };
+
+                moved = true;
+                if let Err(err) = self.execute_transaction(transaction) {
+                    warn!("Failed to execute queued declare transaction: {err}");
+                }
+            }
+
+            if !moved || self.pending_transaction_count() == 0 || !self.should_mine_pending_block()
+            {
+                break;
+            }
+
+            if let Err(err) = self.generate_latest_block() {
+                warn!("Failed to mine the queued declare transactions: {err}");
+                break;
+            }
+            self.generate_empty_pending_block();
+        }
+    }
+
+    fn declare_limit_reached(&self) -> bool {
+        let Some(max_declares) = self.config.max_declares_per_block else {
+            return false;
+        };
+
+        let declares = self.blocks.pending_block.as_ref().map_or(0, |block| {
+            block
+                .transactions()
+                .iter()
+                .filter(|tx| matches!(tx, starknet_api::transaction::Transaction::Declare(_)))
+                .count()
+        });
+
+        declares >= max_declares
+    }
+
+    fn trace_ordering(&mut self, transaction_hash: TransactionHash, decision: OrderingDecision) {
+        if let Some(trace) = &mut self.ordering_trace {
+            trace.record(transaction_hash, decision);
+        }
+    }
+
+    fn is_declare(transaction: &Transaction) -> bool {
+        matches!(
+            transaction,
+            Transaction::AccountTransaction(AccountTransaction::Declare(_))
+        )
+    }
+
     pub fn call(
         &self,
         call: ExternalFunctionCall,
         block_number: Option<BlockNumber>,
     ) -> Result<CallInfo> {
         let state = match block_number {
-            Some(num) => self.state(num).ok_or(anyhow!("block not found"))?,
+            Some(num) => self.state(num)?,
             None => self.pending_state(),
         };
 
@@ -293,8 +1299,107 @@ impl StarknetWrapper {
         .map_err(|e| e.into())
     }
 
-    pub fn state(&self, block_number: BlockNumber) -> Option<DictStateReader> {
-        self.blocks.get_state(&block_number).cloned()
+    // Runs only the `__validate__` entry point of the sender for the transaction, on top of
+    // the state of the given block or of the pending state. Nothing is executed nor charged,
+    // and the nonce isn't checked.
+    pub fn validate_transaction(
+        &self,
+        transaction: &InvokeTransactionV1,
+        block_number: Option<BlockNumber>,
+    ) -> Result<ValidationResult> {
+        let state = match block_number {
+            Some(num) => self.state(num)?,
+            None => self.pending_state(),
+        };
+
+        let mut state = CachedState::new(state);
+        let mut state = CachedState::new(MutRefState::new(&mut state));
+
+        let call = CallEntryPoint {
+            calldata: transaction.calldata.clone(),
+            storage_address: transaction.sender_address,
+            entry_point_selector: selector_from_name("__validate__"),
+            ..Default::default()
+        };
+
+        let tx_context = AccountTransactionContext {
+            transaction_hash: transaction.transaction_hash,
+            max_fee: transaction.max_fee,
+            version: TransactionVersion(stark_felt!(1)),
+            signature: transaction.signature.clone(),
+            nonce: transaction.nonce,
+            sender_address: transaction.sender_address,
+        };
+
+        let result = call.execute(
+            &mut state,
+            &mut ExecutionContext::new(self.block_context.clone(), tx_context),
+        );
+
+        Ok(match result {
+            Ok(call_info) => ValidationResult {
+                error: None,
+                gas_usage: self.l1_gas_by_call_resources(&call_info),
+                events: ordered_events(&call_info),
+            },
+            Err(err) => ValidationResult {
+                error: Some(err.to_string()),
+                gas_usage: 0,
+                events: vec![],
+            },
+        })
+    }
+
+    // Converts the Cairo resources used by the call into L1 gas, like the fee of a transaction:
+    // the most expensive resource according to the fee weights of the block context
+    fn l1_gas_by_call_resources(&self, call_info: &CallInfo) -> u64 {
+        let resources = &call_info.vm_resources;
+        self.block_context
+            .vm_resource_fee_cost
+            .iter()
+            .map(|(resource, weight)| {
+                let usage = if resource == "n_steps" {
+                    resources.n_steps
+                } else {
+                    resources
+                        .builtin_instance_counter
+                        .get(&format!("{resource}_builtin"))
+                        .copied()
+                        .unwrap_or_default()
+                };
+                weight * usage as f64
+            })
+            .fold(0_f64, f64::max)
+            .ceil() as u64
+    }
+
+    // Every historical state query goes through here, so that none can reach further back
+    // than the maximum historical query depth
+    pub fn state(&self, block_number: BlockNumber) -> Result<DictStateReader> {
+        self.check_query_depth(block_number)?;
+        self.blocks
+            .get_state(&block_number)
+            .cloned()
+            .ok_or(anyhow!("block {block_number} not found"))
+    }
+
+    // Refuses queries against blocks further behind the latest block than the maximum
+    // historical query depth. Results cached for a block are checked here before being served.
+    pub fn check_query_depth(&self, block_number: BlockNumber) -> Result<()> {
+        if let (Some(max_depth), Some(latest)) = (
+            self.config.max_historical_query_depth,
+            self.blocks.current_block_number(),
+        ) {
+            if latest.0.saturating_sub(block_number.0) > max_depth {
+                return Err(QueryTooDeep {
+                    block_number,
+                    max_depth,
+                }
+                .into());
+            }
+        }
+
+        Ok(())
     }
 
     pub fn pending_state(&self) -> DictStateReader {
@@ -307,20 +1412,176 @@ impl StarknetWrapper {
         self.state.clone()
     }
 
+    // Runs the checks a transaction must pass to be executed or queued
+    fn check_admission(
+        &mut self,
+        transaction: &Transaction,
+        api_tx: &starknet_api::transaction::Transaction,
+    ) -> Result<()> {
+        let transaction_hash = api_tx.transaction_hash();
+        if self.recent_hashes.contains(&transaction_hash) {
+            return Err(DuplicateTransaction { transaction_hash }.into());
+        }
+
+        if let Transaction::AccountTransaction(tx) = transaction {
+            self.check_tx_fee(tx);
+            self.check_fee_balance_ratio(api_tx, tx)?;
+            self.check_sender_balance(api_tx, tx)?;
+        }
+
+        if Self::is_declare(transaction) {
+            self.check_declared_classes_limit()?;
+        }
+
+        Ok(())
+    }
+
+    fn notify_rejection(&self, api_tx: &starknet_api::transaction::Transaction, reason: String) {
+        if let Some(webhook) = &self.rejection_webhook {
+            webhook.notify(RejectedTransaction {
+                transaction_hash: api_tx.transaction_hash().0,
+                sender_address: get_sender_address(api_tx).map(|a| *a.0.key()),
+                reason,
+            });
+        }
+    }
+
     fn check_tx_fee(&self, transaction: &AccountTransaction) {
-        let max_fee = match transaction {
-            AccountTransaction::Invoke(tx) => tx.max_fee(),
-            AccountTransaction::DeployAccount(tx) => tx.max_fee,
-            AccountTransaction::Declare(DeclareTransaction { tx, .. }) => match tx {
-                starknet_api::transaction::DeclareTransaction::V0(tx) => tx.max_fee,
-                starknet_api::transaction::DeclareTransaction::V1(tx) => tx.max_fee,
-                starknet_api::transaction::DeclareTransaction::V2(tx) => tx.max_fee,
-            },
+        if !self.config.allow_zero_max_fee && get_max_fee(transaction).0 == 0 {
+            panic!("max fee == 0 is not supported")
+        }
+    }
+
+    // Reject transactions whose max fee is suspiciously large compared to the balance of
+    // their sender, which usually indicates a mistake in the fee settings
+    fn check_fee_balance_ratio(
+        &mut self,
+        api_tx: &starknet_api::transaction::Transaction,
+        transaction: &AccountTransaction,
+    ) -> Result<()> {
+        let (Some(ratio), Some(sender_address)) = (
+            self.config.max_fee_balance_ratio,
+            get_sender_address(api_tx),
+        ) else {
+            return Ok(());
         };
 
-        if !self.config.allow_zero_max_fee && max_fee.0 == 0 {
-            panic!("max fee == 0 is not supported")
+        let balance = self.sender_balance(sender_address)?;
+        let max_fee = get_max_fee(transaction).0;
+
+        ensure!(
+            max_fee as f64 <= balance as f64 * ratio,
+            "max fee {max_fee} exceeds {ratio} of the sender balance {balance}; the fee settings are likely mistaken"
+        );
+
+        Ok(())
+    }
+
+    // Reject transactions whose sender can't cover their max fee, when enabled
+    fn check_sender_balance(
+        &mut self,
+        api_tx: &starknet_api::transaction::Transaction,
+        transaction: &AccountTransaction,
+    ) -> Result<()> {
+        if !self.config.reject_insufficient_balance {
+            return Ok(());
         }
+
+        let max_fee = get_max_fee(transaction).0;
+        let Some(sender_address) = get_sender_address(api_tx).filter(|_| max_fee > 0) else {
+            return Ok(());
+        };
+
+        let balance = self.sender_balance(sender_address)?;
+        if max_fee > balance {
+            return Err(InsufficientAccountBalance { max_fee, balance }.into());
+        }
+
+        Ok(())
+    }
+
+    fn sender_balance(&mut self, sender_address: ContractAddress) -> Result<u128> {
+        let balance = self.pending_state.get_storage_at(
+            self.block_context.fee_token_address,
+            get_storage_var_address("ERC20_balances", &[*sender_address.0.key()])?,
+        )?;
+        starkfelt_to_u128(balance)
+    }
+
+    fn check_declared_classes_limit(&self) -> Result<()> {
+        let Some(max_classes) = self.config.max_declared_classes else {
+            return Ok(());
+        };
+
+        // Successfully executed declares, mined or pending, plus the queued ones
+        let declared = self
+            .transactions
+            .transactions
+            .values()
+            .filter(|tx| {
+                tx.status != TransactionStatus::Rejected
+                    && matches!(tx.inner, starknet_api::transaction::Transaction::Declare(_))
+            })
+            .count()
+            + self.declare_queue.len();
+
+        ensure!(
+            declared < max_classes,
+            "maximum number of declared classes ({max_classes}) reached"
+        );
+
+        Ok(())
+    }
+
+    fn should_mine_pending_block(&self) -> bool {
+        if self.config.blocks_on_demand {
+            return false;
+        }
+
+        match self.config.min_txs_per_block {
+            Some(min_txs) => {
+                self.pending_transaction_count() >= min_txs || self.pending_block_timed_out()
+            }
+            None => true,
+        }
+    }
+
+    // Whether adding a transaction with the given calldata length to the pending block would
+    // exceed the calldata limit. A transaction above the limit on its own still gets a block.
+    fn block_calldata_overflows(&self, calldata_len: usize) -> bool {
+        match self.config.max_block_calldata {
+            Some(max) => {
+                self.pending_transaction_count() > 0
+                    && self.pending_calldata_len() + calldata_len > max
+            }
+            None => false,
+        }
+    }
+
+    fn block_calldata_full(&self) -> bool {
+        self.config
+            .max_block_calldata
+            .map_or(false, |max| self.pending_calldata_len() >= max)
+    }
+
+    fn pending_calldata_len(&self) -> usize {
+        self.blocks.pending_block.as_ref().map_or(0, |block| {
+            block.transactions().iter().map(get_calldata_len).sum()
+        })
+    }
+
+    fn pending_block_timed_out(&self) -> bool {
+        match (self.pending_since, self.config.block_wait_timeout) {
+            (Some(since), Some(timeout)) => since.elapsed() >= timeout,
+            _ => false,
+        }
+    }
+
+    pub fn pending_transaction_count(&self) -> usize {
+        self.blocks
+            .pending_block
+            .as_ref()
+            .map_or(0, |block| block.transactions().len())
     }
 
     fn create_new_empty_block(&self) -> StarknetBlock {
@@ -342,7 +1603,7 @@ impl StarknetWrapper {
             GasPrice(self.block_context.gas_price),
             GlobalRoot(stark_felt!(0)),
             self.block_context.sequencer_address,
-            BlockTimestamp(get_current_timestamp().as_secs()),
+            self.current_block_timestamp(),
             vec![],
             vec![],
             None,
@@ -359,9 +1620,60 @@ impl StarknetWrapper {
             .insert(transaction.inner.transaction_hash(), transaction)
     }
 
-    fn update_block_context(&mut self) {
+    fn update_block_context(&mut self, mined_transactions: usize) {
         self.block_context.block_number = self.block_context.block_number.next();
-        self.block_context.block_timestamp = BlockTimestamp(get_current_timestamp().as_secs());
+        self.block_context.block_timestamp = self.current_block_timestamp();
+        self.block_context.gas_price = self.next_gas_price(mined_transactions);
+    }
+
+    // Adjusts the gas price based on how full the mined block was relative to the target,
+    // emulating the EIP-1559 base fee: above the target the price rises, below it the price
+    // falls, by at most `1 / base_fee_change_denominator` of its value per block. A zero
+    // target or denominator leaves the price unchanged.
+    fn next_gas_price(&self, mined_transactions: usize) -> u128 {
+        let gas_price = self.block_context.gas_price;
+        let Some(target) = self.config.base_fee_target else {
+            return gas_price;
+        };
+
+        let target = target as u128;
+        let used = mined_transactions as u128;
+        let denominator = self.config.base_fee_change_denominator;
+        let delta = |difference: u128| {
+            gas_price
+                .saturating_mul(difference)
+                .checked_div(target)?
+                .checked_div(denominator)
+        };
+
+        if used > target {
+            let Some(delta) = delta(used - target) else {
+                return gas_price;
+            };
+            // Always rise by at least 1 wei, so that a price of zero can recover.
+            gas_price.saturating_add(delta.max(1))
+        } else {
+            let Some(delta) = delta(target - used) else {
+                return gas_price;
+            };
+            gas_price - delta
+        }
+    }
+
+    // The timestamp of the block being built, from the configured source, plus the time
+    // advanced through `advance_time_and_mine`
+    fn current_block_timestamp(&self) -> BlockTimestamp {
+        let block_number = self.block_context.block_number.0;
+        let timestamp = match self.config.timestamp_source {
+            TimestampSource::Wall => get_current_timestamp().as_secs(),
+            TimestampSource::Monotonic => {
+                let (system_time, instant) = self.clock_origin;
+                (system_time + instant.elapsed()).as_secs()
+            }
+            TimestampSource::Step => block_number.saturating_mul(self.config.timestamp_step),
+        };
+
+        BlockTimestamp(timestamp.saturating_add(self.time_offset))
     }
 
     // apply the pending state diff to the state
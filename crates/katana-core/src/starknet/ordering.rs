@@ -0,0 +1,65 @@
+use std::fmt;
+
+use starknet_api::{block::BlockNumber, transaction::TransactionHash};
+use tracing::info;
+
+/// What the block producer decided to do with a transaction it considered.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub enum OrderingDecision {
+    /// Included in the block being built, after the previously selected transactions.
+    Selected,
+    /// Postponed to a later block.
+    Deferred(String),
+    /// Left out of the block because its execution failed.
+    Rejected(String),
+}
+
+impl fmt::Display for OrderingDecision {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            Self::Selected => write!(f, "selected"),
+            Self::Deferred(reason) => write!(f, "deferred ({reason})"),
+            Self::Rejected(reason) => write!(f, "rejected ({reason})"),
+        }
+    }
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct OrderingEvent {
+    pub transaction_hash: TransactionHash,
+    pub decision: OrderingDecision,
+}
+
+/// Records the ordering decisions made while building each block, to debug unexpected
+/// inclusion behavior.
+#[derive(Debug, Default)]
+pub struct OrderingTrace {
+    /// The decisions made for the pending block so far, in the order they were made.
+    pub pending: Vec<OrderingEvent>,
+    /// The decisions made for the last mined block.
+    pub last_block: Vec<OrderingEvent>,
+}
+
+impl OrderingTrace {
+    pub fn record(&mut self, transaction_hash: TransactionHash, decision: OrderingDecision) {
+        self.pending.push(OrderingEvent {
+            transaction_hash,
+            decision,
+        });
+    }
+
+    /// Logs the decisions made for the mined block and starts tracing the next one.
+    pub fn flush(&mut self, block_number: BlockNumber) {
+        for (index, event) in self.pending.iter().enumerate() {
+            info!(
+                target: "katana::ordering",
+                "Block {} | #{index} | Transaction hash: {} | {}",
+                block_number.0,
+                event.transaction_hash,
+                event.decision
+            );
+        }
+
+        self.last_block = std::mem::take(&mut self.pending);
+    }
+}
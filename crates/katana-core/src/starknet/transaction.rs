@@ -1,9 +1,10 @@
 use std::{collections::HashMap, vec};
 
-use blockifier::transaction::{
-    errors::TransactionExecutionError, objects::TransactionExecutionInfo,
+use blockifier::{
+    execution::entry_point::CallInfo,
+    transaction::{errors::TransactionExecutionError, objects::TransactionExecutionInfo},
 };
-use starknet::core::types::TransactionStatus;
+use starknet::{core::types::TransactionStatus, providers::jsonrpc::models::StateDiff};
 use starknet_api::{
     block::{BlockHash, BlockNumber},
     core::{ContractAddress, EntryPointSelector},
@@ -16,6 +17,16 @@ use starknet_api::{
     },
 };
 
+/// The outcome of running the validation of a transaction on its own.
+#[derive(Debug, Clone)]
+pub struct ValidationResult {
+    /// Why the validation failed, if it did.
+    pub error: Option<String>,
+    /// The L1 gas equivalent of the resources used by the validation.
+    pub gas_usage: u64,
+    pub events: Vec<Event>,
+}
+
 pub struct ExternalFunctionCall {
     pub calldata: Calldata,
     pub contract_address: ContractAddress,
@@ -30,6 +41,8 @@ pub struct StarknetTransaction {
     pub block_number: Option<BlockNumber>,
     pub execution_info: Option<TransactionExecutionInfo>,
     pub execution_error: Option<TransactionExecutionError>,
+    // The state changes caused by this transaction alone
+    pub state_diff: Option<StateDiff>,
 }
 
 impl StarknetTransaction {
@@ -48,6 +61,7 @@ impl StarknetTransaction {
             status,
             execution_info,
             execution_error,
+            state_diff: None,
             block_hash: None,
             block_number: None,
         }
@@ -69,34 +83,19 @@ impl StarknetTransaction {
     }
 
     pub fn emitted_events(&self) -> Vec<Event> {
-        let mut events: Vec<Event> = vec![];
-
         let Some(ref execution_info) = self.execution_info else {
-            return events;
+            return vec![];
         };
 
-        if let Some(ref info) = execution_info.validate_call_info {
-            events.extend(info.execution.events.iter().map(|e| Event {
-                content: e.event.clone(),
-                from_address: info.call.caller_address,
-            }))
-        }
-
-        if let Some(ref info) = execution_info.execute_call_info {
-            events.extend(info.execution.events.iter().map(|e| Event {
-                content: e.event.clone(),
-                from_address: info.call.caller_address,
-            }))
-        }
-
-        if let Some(ref info) = execution_info.fee_transfer_call_info {
-            events.extend(info.execution.events.iter().map(|e| Event {
-                content: e.event.clone(),
-                from_address: info.call.caller_address,
-            }))
-        }
-
-        events
+        [
+            &execution_info.validate_call_info,
+            &execution_info.execute_call_info,
+            &execution_info.fee_transfer_call_info,
+        ]
+        .into_iter()
+        .flatten()
+        .flat_map(ordered_events)
+        .collect()
     }
 
     pub fn l2_to_l1_messages(&self) -> Vec<MessageToL1> {
@@ -185,9 +184,36 @@ impl StarknetTransaction {
     }
 }
 
+/// Returns the events emitted by the call and all of its nested calls, in the order in which
+/// they were emitted. Each event is attributed to the contract that emitted it.
+pub fn ordered_events(call_info: &CallInfo) -> Vec<Event> {
+    fn collect(call_info: &CallInfo, events: &mut Vec<(usize, Event)>) {
+        events.extend(call_info.execution.events.iter().map(|e| {
+            (
+                e.order,
+                Event {
+                    content: e.event.clone(),
+                    from_address: call_info.call.storage_address,
+                },
+            )
+        }));
+
+        for inner_call in &call_info.inner_calls {
+            collect(inner_call, events);
+        }
+    }
+
+    let mut events = vec![];
+    collect(call_info, &mut events);
+    events.sort_by_key(|(order, _)| *order);
+    events.into_iter().map(|(_, event)| event).collect()
+}
+
 #[derive(Debug, Default)]
 pub struct StarknetTransactions {
     pub transactions: HashMap<TransactionHash, StarknetTransaction>,
+    // The mined transactions of each sender, in the order they were mined
+    pub sender_index: HashMap<ContractAddress, Vec<(BlockNumber, TransactionHash)>>,
 }
 
 impl StarknetTransactions {
@@ -22,11 +22,11 @@ use starknet::{
     },
 };
 use starknet_api::{
-    core::ClassHash,
+    core::{ClassHash, ContractAddress},
     hash::StarkFelt,
     transaction::{
-        DeployAccountTransaction, InvokeTransaction, InvokeTransactionV1, L1HandlerTransaction,
-        Transaction,
+        DeployAccountTransaction, Fee, InvokeTransaction, InvokeTransactionV1,
+        L1HandlerTransaction, Transaction, TransactionHash, TransactionSignature,
     },
     StarknetApiError,
 };
@@ -130,6 +130,82 @@ pub fn convert_blockifier_tx_to_starknet_api_tx(
     }
 }
 
+pub fn get_max_fee(transaction: &AccountTransaction) -> Fee {
+    match transaction {
+        AccountTransaction::Invoke(tx) => tx.max_fee(),
+        AccountTransaction::DeployAccount(tx) => tx.max_fee,
+        AccountTransaction::Declare(DeclareTransaction { tx, .. }) => match tx {
+            starknet_api::transaction::DeclareTransaction::V0(tx) => tx.max_fee,
+            starknet_api::transaction::DeclareTransaction::V1(tx) => tx.max_fee,
+            starknet_api::transaction::DeclareTransaction::V2(tx) => tx.max_fee,
+        },
+    }
+}
+
+pub fn get_transaction_hash(transaction: &AccountTransaction) -> TransactionHash {
+    match transaction {
+        AccountTransaction::Invoke(tx) => tx.transaction_hash(),
+        AccountTransaction::DeployAccount(tx) => tx.transaction_hash,
+        AccountTransaction::Declare(DeclareTransaction { tx, .. }) => match tx {
+            starknet_api::transaction::DeclareTransaction::V0(tx) => tx.transaction_hash,
+            starknet_api::transaction::DeclareTransaction::V1(tx) => tx.transaction_hash,
+            starknet_api::transaction::DeclareTransaction::V2(tx) => tx.transaction_hash,
+        },
+    }
+}
+
+pub fn get_signature(transaction: &AccountTransaction) -> TransactionSignature {
+    match transaction {
+        AccountTransaction::Invoke(tx) => tx.signature(),
+        AccountTransaction::DeployAccount(tx) => tx.signature.clone(),
+        AccountTransaction::Declare(DeclareTransaction { tx, .. }) => match tx {
+            starknet_api::transaction::DeclareTransaction::V0(tx) => tx.signature.clone(),
+            starknet_api::transaction::DeclareTransaction::V1(tx) => tx.signature.clone(),
+            starknet_api::transaction::DeclareTransaction::V2(tx) => tx.signature.clone(),
+        },
+    }
+}
+
+/// Returns the number of calldata elements of the transaction, which make up most of the data
+/// it publishes.
+pub fn get_calldata_len(transaction: &Transaction) -> usize {
+    match transaction {
+        Transaction::Invoke(tx) => tx.calldata().0.len(),
+        Transaction::DeployAccount(tx) => tx.constructor_calldata.0.len(),
+        Transaction::Deploy(tx) => tx.constructor_calldata.0.len(),
+        Transaction::L1Handler(tx) => tx.calldata.0.len(),
+        Transaction::Declare(_) => 0,
+    }
+}
+
+/// Returns the address of the account that sent the transaction, if it was sent by one.
+pub fn get_sender_address(transaction: &Transaction) -> Option<ContractAddress> {
+    match transaction {
+        Transaction::Invoke(tx) => Some(tx.sender_address()),
+        Transaction::Declare(tx) => Some(match tx {
+            starknet_api::transaction::DeclareTransaction::V0(tx)
+            | starknet_api::transaction::DeclareTransaction::V1(tx) => tx.sender_address,
+            starknet_api::transaction::DeclareTransaction::V2(tx) => tx.sender_address,
+        }),
+        Transaction::DeployAccount(tx) => Some(tx.contract_address),
+        Transaction::L1Handler(_) | Transaction::Deploy(_) => None,
+    }
+}
+
+/// Returns the max fee of the transaction, if it pays a fee.
+pub fn get_transaction_max_fee(transaction: &Transaction) -> Option<Fee> {
+    match transaction {
+        Transaction::Invoke(tx) => Some(tx.max_fee()),
+        Transaction::Declare(tx) => Some(match tx {
+            starknet_api::transaction::DeclareTransaction::V0(tx)
+            | starknet_api::transaction::DeclareTransaction::V1(tx) => tx.max_fee,
+            starknet_api::transaction::DeclareTransaction::V2(tx) => tx.max_fee,
+        }),
+        Transaction::DeployAccount(tx) => Some(tx.max_fee),
+        Transaction::L1Handler(_) | Transaction::Deploy(_) => None,
+    }
+}
+
 pub fn compute_legacy_class_hash(contract_class_str: &str) -> Result<ClassHash> {
     let contract_class: LegacyContractClass = ::serde_json::from_str(contract_class_str)?;
     let seirra_class_hash = contract_class.class_hash()?;
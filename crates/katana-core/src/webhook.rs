@@ -0,0 +1,80 @@
+use serde::Serialize;
+use starknet_api::hash::StarkFelt;
+use tokio::sync::mpsc;
+use tracing::warn;
+
+/// Maximum number of notifications waiting to be delivered. Once the queue is full new
+/// notifications are dropped, so that a slow webhook never holds up the node.
+const REJECTION_WEBHOOK_QUEUE_SIZE: usize = 1024;
+
+#[derive(Debug, Clone, Serialize)]
+pub struct RejectedTransaction {
+    pub transaction_hash: StarkFelt,
+    pub sender_address: Option<StarkFelt>,
+    pub reason: String,
+}
+
+#[derive(Debug, Clone, Serialize)]
+pub struct ChainReorg {
+    pub common_ancestor: u64,
+    pub new_tip_number: u64,
+    pub new_tip_hash: StarkFelt,
+    pub dropped_transactions: Vec<StarkFelt>,
+}
+
+/// A notification POSTed to the webhook, tagged with its `type`.
+#[derive(Debug, Clone, Serialize)]
+#[serde(tag = "type", rename_all = "snake_case")]
+pub enum WebhookNotification {
+    RejectedTransaction(RejectedTransaction),
+    ChainReorg(ChainReorg),
+}
+
+/// Notifies an external HTTP endpoint of every rejected transaction and chain reorg.
+pub struct RejectionWebhook {
+    sender: mpsc::Sender<WebhookNotification>,
+}
+
+impl RejectionWebhook {
+    /// Spawns the task delivering the notifications, so it must be called from within a
+    /// Tokio runtime.
+    pub fn spawn(url: String) -> Self {
+        let (sender, mut receiver) =
+            mpsc::channel::<WebhookNotification>(REJECTION_WEBHOOK_QUEUE_SIZE);
+
+        tokio::spawn(async move {
+            let client = reqwest::Client::new();
+
+            while let Some(notification) = receiver.recv().await {
+                if let Err(err) = client.post(&url).json(&notification).send().await {
+                    match notification {
+                        WebhookNotification::RejectedTransaction(rejected) => warn!(
+                            "Failed to deliver rejection of transaction {} to webhook: {err}",
+                            rejected.transaction_hash
+                        ),
+                        WebhookNotification::ChainReorg(reorg) => warn!(
+                            "Failed to deliver reorg to block {} to webhook: {err}",
+                            reorg.common_ancestor
+                        ),
+                    }
+                }
+            }
+        });
+
+        Self { sender }
+    }
+
+    pub fn notify(&self, rejected: RejectedTransaction) {
+        self.send(WebhookNotification::RejectedTransaction(rejected));
+    }
+
+    pub fn notify_reorg(&self, reorg: ChainReorg) {
+        self.send(WebhookNotification::ChainReorg(reorg));
+    }
+
+    fn send(&self, notification: WebhookNotification) {
+        if let Err(err) = self.sender.try_send(notification) {
+            warn!("Dropping webhook notification: {err}");
+        }
+    }
+}
@@ -1,24 +1,101 @@
+use std::collections::HashMap;
+use std::path::PathBuf;
+use std::sync::Arc;
+use std::time::Duration;
+
 use blockifier::abi::abi_utils::{get_storage_var_address, selector_from_name};
+use blockifier::execution::contract_class::{ContractClass, ContractClassV0};
+use blockifier::execution::entry_point::{CallEntryPoint, CallExecution, CallInfo, OrderedEvent};
+use blockifier::state::cached_state::CachedState;
+use blockifier::state::state_api::{State, StateReader};
 use blockifier::transaction::{
     account_transaction::AccountTransaction, transaction_execution::Transaction,
+    transactions::DeclareTransaction,
+};
+use chrono::{DateTime, Utc};
+use katana_core::accounts::PredeployedAccounts;
+use katana_core::constants::{
+    DEFAULT_BASE_FEE_CHANGE_DENOMINATOR, DEFAULT_GAS_PRICE, DEFAULT_PREFUNDED_ACCOUNT_BALANCE,
+    FEE_TOKEN_ADDRESS, TEST_ACCOUNT_CONTRACT_PATH, UDC_ADDRESS,
 };
-use katana_core::constants::{DEFAULT_GAS_PRICE, FEE_TOKEN_ADDRESS, TEST_ACCOUNT_CONTRACT_PATH};
-use katana_core::starknet::{StarknetConfig, StarknetWrapper};
-use starknet::core::types::TransactionStatus;
+use katana_core::schedule::{produce_scheduled_blocks, BlockSchedule};
+use katana_core::sequencer::{KatanaSequencer, Sequencer};
+use katana_core::starknet::block::{StarknetBlock, StarknetBlocks};
+use katana_core::starknet::genesis::{GenesisCall, GenesisMessage};
+use katana_core::starknet::messaging::compute_l1_to_l2_message_hash;
+use katana_core::starknet::ordering::OrderingDecision;
+use katana_core::starknet::transaction::{ordered_events, ExternalFunctionCall};
+use katana_core::starknet::{
+    DuplicateTransaction, InsufficientAccountBalance, QueryTooDeep, StarknetConfig,
+    StarknetWrapper, TimestampSource, DETERMINISTIC_BLOCK_TIME_STEP, MAX_BLOCK_NUMBER_STEP,
+};
+use katana_core::util::starkfelt_to_u128;
+use katana_core::webhook::RejectionWebhook;
+use starknet::core::types::{FieldElement, TransactionStatus};
+use starknet::providers::jsonrpc::models::{BlockId, BlockTag, DeployedContractItem};
+use starknet::signers::SigningKey;
 use starknet_api::calldata;
-use starknet_api::transaction::InvokeTransaction;
+use starknet_api::core::{
+    calculate_contract_address, ClassHash, CompiledClassHash, ContractAddress, Nonce, PatriciaKey,
+};
+use starknet_api::hash::StarkHash;
+use starknet_api::patricia_key;
+use starknet_api::state::StorageKey;
+use starknet_api::transaction::{
+    ContractAddressSalt, EventContent, EventData, EventKey, Fee, InvokeTransaction,
+};
 use starknet_api::{
-    block::BlockNumber,
+    block::{BlockHash, BlockNumber, BlockTimestamp},
     hash::StarkFelt,
     stark_felt,
-    transaction::{Calldata, InvokeTransactionV1, TransactionHash},
+    transaction::{
+        Calldata, DeclareTransactionV0V1, InvokeTransactionV1, TransactionHash,
+        TransactionSignature, TransactionVersion,
+    },
 };
+use tokio::io::{AsyncReadExt, AsyncWriteExt};
+use tokio::net::TcpListener;
+use tokio::sync::RwLock;
 
-fn create_test_starknet() -> StarknetWrapper {
-    let test_account_path = [env!("CARGO_MANIFEST_DIR"), TEST_ACCOUNT_CONTRACT_PATH]
+fn test_account_path() -> PathBuf {
+    [env!("CARGO_MANIFEST_DIR"), TEST_ACCOUNT_CONTRACT_PATH]
         .iter()
-        .collect();
+        .collect()
+}
+
+fn test_contract_path() -> PathBuf {
+    [
+        env!("CARGO_MANIFEST_DIR"),
+        "contracts/compiled/test_contract.json",
+    ]
+    .iter()
+    .collect()
+}
+
+fn test_contract_class() -> ContractClass {
+    let contract_class = std::fs::read_to_string(test_contract_path()).unwrap();
+    ContractClass::V0(serde_json::from_str::<ContractClassV0>(&contract_class).unwrap())
+}
+
+// Places the contract directly in the genesis state, bypassing any transaction
+fn deploy_contract(
+    starknet: &mut StarknetWrapper,
+    address: ContractAddress,
+    contract_class: ContractClass,
+) {
+    let class_hash = ClassHash(*address.0.key());
+    starknet
+        .state
+        .class_hash_to_class
+        .insert(class_hash, contract_class);
+    starknet
+        .state
+        .address_to_class_hash
+        .insert(address, class_hash);
+    starknet.pending_state = CachedState::new(starknet.state.clone());
+}
 
+fn create_test_starknet() -> StarknetWrapper {
     StarknetWrapper::new(StarknetConfig {
         seed: [0u8; 32],
         total_accounts: 2,
@@ -26,10 +103,35 @@ fn create_test_starknet() -> StarknetWrapper {
         allow_zero_max_fee: true,
         gas_price: DEFAULT_GAS_PRICE,
         chain_id: String::from("KATANA"),
-        account_path: Some(test_account_path),
+        account_path: Some(test_account_path()),
+        ..Default::default()
     })
 }
 
+fn create_transfer_transaction(
+    sender: ContractAddress,
+    recipient: ContractAddress,
+    nonce: u64,
+    transaction_hash: TransactionHash,
+) -> AccountTransaction {
+    let execute_calldata = calldata![
+        *FEE_TOKEN_ADDRESS,               // Contract address.
+        selector_from_name("transfer").0, // EP selector.
+        stark_felt!(3),                   // Calldata length.
+        *recipient.0.key(),               // Calldata: recipient.
+        stark_felt!("0x99"),              // Calldata: amount (low).
+        stark_felt!(0x0)                  // Calldata: amount (high).
+    ];
+
+    AccountTransaction::Invoke(InvokeTransaction::V1(InvokeTransactionV1 {
+        sender_address: sender,
+        calldata: execute_calldata,
+        nonce: Nonce(stark_felt!(nonce)),
+        transaction_hash,
+        ..Default::default()
+    }))
+}
+
 #[test]
 fn test_creating_blocks() {
     let mut starknet = create_test_starknet();
@@ -182,6 +284,3175 @@ fn test_add_reverted_transaction() {
     assert_eq!(starknet.blocks.num_to_block.len(), 0, "no blocks added");
 }
 
+#[test]
+fn test_simulate_transaction_at_historical_block() {
+    let mut starknet = create_test_starknet();
+    starknet.generate_pending_block();
+    starknet.generate_latest_block().unwrap();
+    starknet.generate_pending_block();
+
+    let a = starknet.predeployed_accounts.accounts[0].clone();
+    let b = starknet.predeployed_accounts.accounts[1].clone();
+
+    // Mined in block 1, bumping the nonce of `a` to 1.
+    starknet
+        .handle_transaction(Transaction::AccountTransaction(
+            create_transfer_transaction(
+                a.account_address,
+                b.account_address,
+                0,
+                TransactionHash(stark_felt!("0x6969")),
+            ),
+        ))
+        .unwrap();
+
+    assert_eq!(starknet.blocks.total_blocks(), 2);
+
+    let simulated_transaction = || {
+        create_transfer_transaction(
+            a.account_address,
+            b.account_address,
+            0,
+            TransactionHash(stark_felt!("0x7070")),
+        )
+    };
+
+    assert!(
+        starknet
+            .simulate_transaction(simulated_transaction(), BlockId::Number(0))
+            .is_ok(),
+        "nonce 0 must be valid against the state of block 0"
+    );
+    assert!(
+        starknet
+            .simulate_transaction(simulated_transaction(), BlockId::Number(1))
+            .is_err(),
+        "nonce 0 must be invalid against the state of block 1"
+    );
+    assert!(
+        starknet
+            .simulate_transaction(simulated_transaction(), BlockId::Number(2))
+            .is_err(),
+        "simulating against a non-existent block must fail"
+    );
+
+    let block_context = starknet
+        .block_context_from_block_id(BlockId::Number(0))
+        .unwrap();
+    let block = starknet.blocks.by_number(BlockNumber(0)).unwrap();
+
+    assert_eq!(block_context.block_number, BlockNumber(0));
+    assert_eq!(block_context.block_timestamp, block.header().timestamp);
+}
+
+#[test]
+fn test_genesis_salt() {
+    let create_starknet = |genesis_salt| {
+        StarknetWrapper::new(StarknetConfig {
+            total_accounts: 3,
+            genesis_salt,
+            ..Default::default()
+        })
+    };
+
+    let addresses = |starknet: &StarknetWrapper| {
+        starknet
+            .predeployed_accounts
+            .accounts
+            .iter()
+            .map(|account| account.account_address)
+            .collect::<Vec<_>>()
+    };
+
+    let salted = addresses(&create_starknet(Some(1337)));
+
+    assert_eq!(salted, addresses(&create_starknet(Some(1337))));
+    assert_ne!(salted, addresses(&create_starknet(None)));
+    assert_ne!(salted, addresses(&create_starknet(Some(42))));
+}
+
+#[test]
+fn test_genesis_deployer() {
+    let config = || StarknetConfig {
+        total_accounts: 3,
+        genesis_deployer_balance: Some(10 * u128::pow(10, 24)),
+        genesis_deployer_nonce: 5,
+        ..Default::default()
+    };
+    let mut starknet = StarknetWrapper::new(config());
+
+    let deployer = starknet.predeployed_accounts.deployer.clone().unwrap();
+    assert!(starknet
+        .predeployed_accounts
+        .accounts
+        .iter()
+        .all(|account| account.account_address != deployer.account_address));
+
+    let balance = starknet
+        .state
+        .get_storage_at(
+            ContractAddress(patricia_key!(*FEE_TOKEN_ADDRESS)),
+            get_storage_var_address("ERC20_balances", &[*deployer.account_address.0.key()])
+                .unwrap(),
+        )
+        .unwrap();
+    assert_eq!(balance, StarkFelt::from(10 * u128::pow(10, 24)));
+    assert_ne!(balance, *DEFAULT_PREFUNDED_ACCOUNT_BALANCE);
+    assert_eq!(
+        starknet
+            .state
+            .get_nonce_at(deployer.account_address)
+            .unwrap(),
+        Nonce(stark_felt!(5_u64))
+    );
+    assert_eq!(
+        starknet
+            .state
+            .get_class_hash_at(deployer.account_address)
+            .unwrap(),
+        deployer.class_hash
+    );
+
+    // Derived from the seed, so the same across runs
+    let again = StarknetWrapper::new(config());
+    assert_eq!(
+        again.predeployed_accounts.deployer.unwrap().account_address,
+        deployer.account_address
+    );
+
+    // Salted after the other accounts
+    let salted = StarknetWrapper::new(StarknetConfig {
+        genesis_salt: Some(1337),
+        ..config()
+    });
+    let salted_deployer = salted.predeployed_accounts.deployer.unwrap();
+    assert_eq!(
+        salted_deployer.salt,
+        ContractAddressSalt(stark_felt!(1340_u64))
+    );
+    assert_ne!(salted_deployer.account_address, deployer.account_address);
+}
+
+#[test]
+fn test_genesis_account_salts() {
+    let starknet = StarknetWrapper::new(StarknetConfig {
+        total_accounts: 3,
+        genesis_salt: Some(1337),
+        genesis_account_salts: vec![7, 8],
+        ..Default::default()
+    });
+
+    let accounts = &starknet.predeployed_accounts.accounts;
+    for (account, salt) in accounts.iter().zip([7_u64, 8, 1337 + 2]) {
+        let expected = calculate_contract_address(
+            ContractAddressSalt(stark_felt!(salt)),
+            account.class_hash,
+            &calldata![account.public_key],
+            ContractAddress::default(),
+        )
+        .unwrap();
+
+        assert_eq!(account.salt, ContractAddressSalt(stark_felt!(salt)));
+        assert_eq!(account.account_address, expected);
+        assert_eq!(
+            starknet.state.address_to_class_hash.get(&expected),
+            Some(&account.class_hash)
+        );
+    }
+
+    // More salts than accounts
+    assert!(PredeployedAccounts::initialize(
+        1,
+        [0; 32],
+        *DEFAULT_PREFUNDED_ACCOUNT_BALANCE,
+        None,
+        None,
+        &[1, 2],
+    )
+    .is_err());
+}
+
+#[test]
+fn test_pool_position() {
+    let mut sequencer = KatanaSequencer::new(StarknetConfig {
+        total_accounts: 2,
+        blocks_on_demand: true,
+        allow_zero_max_fee: true,
+        max_declares_per_block: Some(1),
+        account_path: Some(test_account_path()),
+        ..Default::default()
+    });
+    sequencer.start();
+
+    let a = sequencer.starknet.predeployed_accounts.accounts[0].clone();
+    let b = sequencer.starknet.predeployed_accounts.accounts[1].clone();
+
+    let contract_class = test_contract_class();
+    let declare = |nonce: u64, class_hash, transaction_hash| {
+        AccountTransaction::Declare(DeclareTransaction {
+            tx: starknet_api::transaction::DeclareTransaction::V1(DeclareTransactionV0V1 {
+                sender_address: a.account_address,
+                class_hash: ClassHash(class_hash),
+                nonce: Nonce(stark_felt!(nonce)),
+                transaction_hash: TransactionHash(transaction_hash),
+                ..Default::default()
+            }),
+            contract_class: contract_class.clone(),
+        })
+    };
+
+    for (nonce, class_hash) in ["0x1111", "0x2222", "0x3333"].into_iter().enumerate() {
+        sequencer
+            .add_account_transaction(declare(
+                nonce as u64,
+                stark_felt!(class_hash),
+                stark_felt!(nonce as u64 + 1),
+            ))
+            .unwrap();
+    }
+    sequencer
+        .add_account_transaction(create_transfer_transaction(
+            b.account_address,
+            a.account_address,
+            0,
+            TransactionHash(stark_felt!("0x4")),
+        ))
+        .unwrap();
+
+    let position = |sequencer: &KatanaSequencer, hash: &str| {
+        sequencer
+            .pool_position(&TransactionHash(stark_felt!(hash)))
+            .map(|position| (position.position, position.blocks_until_inclusion))
+    };
+
+    // The invoke joins the first declare in the pending block, ahead of the queued declares
+    assert_eq!(position(&sequencer, "0x1"), Some((0, 1)));
+    assert_eq!(position(&sequencer, "0x4"), Some((1, 1)));
+    assert_eq!(position(&sequencer, "0x2"), Some((2, 2)));
+    assert_eq!(position(&sequencer, "0x3"), Some((3, 3)));
+
+    sequencer.generate_new_block().unwrap();
+
+    assert_eq!(position(&sequencer, "0x1"), None);
+    assert_eq!(position(&sequencer, "0x4"), None);
+    assert_eq!(position(&sequencer, "0x2"), Some((0, 1)));
+    assert_eq!(position(&sequencer, "0x3"), Some((1, 2)));
+    assert_eq!(position(&sequencer, "0x5"), None);
+}
+
+#[test]
+fn test_step_timestamp_source() {
+    let mut starknet = StarknetWrapper::new(StarknetConfig {
+        timestamp_source: TimestampSource::Step,
+        timestamp_step: 12,
+        ..Default::default()
+    });
+    starknet.generate_pending_block();
+
+    for _ in 0..4 {
+        starknet.generate_latest_block().unwrap();
+        starknet.generate_pending_block();
+    }
+
+    let timestamps = (0..4)
+        .map(|number| {
+            starknet
+                .blocks
+                .by_number(BlockNumber(number))
+                .unwrap()
+                .header()
+                .timestamp
+                .0
+        })
+        .collect::<Vec<_>>();
+    assert_eq!(timestamps, vec![0, 12, 24, 36]);
+    assert_eq!(
+        starknet
+            .blocks
+            .pending_block
+            .as_ref()
+            .unwrap()
+            .header()
+            .timestamp,
+        BlockTimestamp(48)
+    );
+}
+
+#[test]
+fn test_oldest_pending_transaction() {
+    let mut sequencer = KatanaSequencer::new(StarknetConfig {
+        total_accounts: 2,
+        blocks_on_demand: true,
+        allow_zero_max_fee: true,
+        timestamp_source: TimestampSource::Step,
+        max_declares_per_block: Some(1),
+        account_path: Some(test_account_path()),
+        ..Default::default()
+    });
+    sequencer.start();
+
+    assert_eq!(sequencer.oldest_pending_transaction(), None);
+
+    let a = sequencer.starknet.predeployed_accounts.accounts[0].clone();
+    let contract_class = test_contract_class();
+    let declare = |nonce: u64, class_hash, transaction_hash| {
+        AccountTransaction::Declare(DeclareTransaction {
+            tx: starknet_api::transaction::DeclareTransaction::V1(DeclareTransactionV0V1 {
+                sender_address: a.account_address,
+                class_hash: ClassHash(class_hash),
+                nonce: Nonce(stark_felt!(nonce)),
+                transaction_hash: TransactionHash(transaction_hash),
+                ..Default::default()
+            }),
+            contract_class: contract_class.clone(),
+        })
+    };
+
+    // The second declare is queued and stays in the pool after the first one is mined
+    sequencer
+        .add_account_transaction(declare(0, stark_felt!("0x1111"), stark_felt!("0x1")))
+        .unwrap();
+    sequencer
+        .add_account_transaction(declare(1, stark_felt!("0x2222"), stark_felt!("0x2")))
+        .unwrap();
+
+    let oldest = sequencer.oldest_pending_transaction().unwrap();
+    assert_eq!(oldest.transaction_hash, TransactionHash(stark_felt!("0x1")));
+    assert_eq!((oldest.seconds, oldest.blocks), (0, 0));
+
+    sequencer.advance_time_and_mine(3600, 1, false).unwrap();
+
+    let oldest = sequencer.oldest_pending_transaction().unwrap();
+    assert_eq!(oldest.transaction_hash, TransactionHash(stark_felt!("0x2")));
+    assert_eq!(oldest.seconds, 3600 + DETERMINISTIC_BLOCK_TIME_STEP);
+    assert_eq!(oldest.blocks, 1);
+
+    sequencer.generate_new_block().unwrap();
+    assert_eq!(sequencer.oldest_pending_transaction(), None);
+}
+
+#[test]
+fn test_set_block_number() {
+    let mut sequencer = KatanaSequencer::new(StarknetConfig {
+        total_accounts: 2,
+        blocks_on_demand: true,
+        allow_zero_max_fee: true,
+        account_path: Some(test_account_path()),
+        ..Default::default()
+    });
+    sequencer.start();
+    sequencer.generate_new_block().unwrap();
+
+    let a = sequencer.starknet.predeployed_accounts.accounts[0].clone();
+    let b = sequencer.starknet.predeployed_accounts.accounts[1].clone();
+    sequencer
+        .add_account_transaction(create_transfer_transaction(
+            a.account_address,
+            b.account_address,
+            0,
+            TransactionHash(stark_felt!("0x1")),
+        ))
+        .unwrap();
+
+    let start = sequencer.block_hash_and_number().unwrap().1;
+    sequencer
+        .set_block_number(BlockNumber(start.0 + 5))
+        .unwrap();
+    assert_eq!(
+        sequencer.block_hash_and_number().unwrap().1,
+        BlockNumber(start.0 + 5)
+    );
+
+    for number in start.0 + 1..=start.0 + 5 {
+        let block = sequencer
+            .starknet
+            .blocks
+            .by_number(BlockNumber(number))
+            .unwrap();
+        let parent = sequencer
+            .starknet
+            .blocks
+            .by_number(BlockNumber(number - 1))
+            .unwrap();
+        assert_eq!(block.parent_hash(), parent.block_hash());
+
+        // The pending transaction lands in the first block, the others are empty
+        let expected_transactions = usize::from(number == start.0 + 1);
+        assert_eq!(block.transactions().len(), expected_transactions);
+    }
+
+    // Setting the current block number is a no-op, going back is rejected
+    sequencer
+        .set_block_number(BlockNumber(start.0 + 5))
+        .unwrap();
+    assert!(sequencer.set_block_number(BlockNumber(start.0)).is_err());
+    assert_eq!(
+        sequencer.block_hash_and_number().unwrap().1,
+        BlockNumber(start.0 + 5)
+    );
+
+    // Jumping further than the maximum step is rejected without mining anything
+    assert!(sequencer
+        .set_block_number(BlockNumber(start.0 + 5 + MAX_BLOCK_NUMBER_STEP + 1))
+        .is_err());
+    assert_eq!(
+        sequencer.block_hash_and_number().unwrap().1,
+        BlockNumber(start.0 + 5)
+    );
+    sequencer
+        .set_block_number(BlockNumber(start.0 + 5 + MAX_BLOCK_NUMBER_STEP))
+        .unwrap();
+    assert_eq!(
+        sequencer.block_hash_and_number().unwrap().1,
+        BlockNumber(start.0 + 5 + MAX_BLOCK_NUMBER_STEP)
+    );
+}
+
+#[test]
+fn test_set_block_number_with_queued_declares() {
+    let mut sequencer = KatanaSequencer::new(StarknetConfig {
+        total_accounts: 1,
+        blocks_on_demand: true,
+        allow_zero_max_fee: true,
+        max_declares_per_block: Some(1),
+        account_path: Some(test_account_path()),
+        ..Default::default()
+    });
+    sequencer.start();
+
+    let a = sequencer.starknet.predeployed_accounts.accounts[0].clone();
+    let contract_class = test_contract_class();
+    for (nonce, class_hash) in [(0_u64, "0x1111"), (1, "0x2222"), (2, "0x3333")] {
+        sequencer
+            .add_account_transaction(AccountTransaction::Declare(DeclareTransaction {
+                tx: starknet_api::transaction::DeclareTransaction::V1(DeclareTransactionV0V1 {
+                    sender_address: a.account_address,
+                    class_hash: ClassHash(stark_felt!(class_hash)),
+                    nonce: Nonce(stark_felt!(nonce)),
+                    transaction_hash: TransactionHash(stark_felt!(nonce + 1)),
+                    ..Default::default()
+                }),
+                contract_class: contract_class.clone(),
+            }))
+            .unwrap();
+    }
+    assert_eq!(sequencer.starknet.declare_queue.len(), 2);
+
+    sequencer.set_block_number(BlockNumber(3)).unwrap();
+
+    let block_hashes = |number| {
+        sequencer
+            .starknet
+            .blocks
+            .by_number(BlockNumber(number))
+            .unwrap()
+            .transactions()
+            .iter()
+            .map(|tx| tx.transaction_hash())
+            .collect::<Vec<_>>()
+    };
+
+    // Only the first block has the pending declare, the queued ones wait for the new pending
+    // block instead of filling the empty blocks
+    assert_eq!(block_hashes(0), vec![TransactionHash(stark_felt!("0x1"))]);
+    for number in 1..=3 {
+        assert!(block_hashes(number).is_empty());
+    }
+    assert_eq!(sequencer.starknet.declare_queue.len(), 1);
+    assert_eq!(
+        sequencer
+            .starknet
+            .blocks
+            .pending_block
+            .as_ref()
+            .unwrap()
+            .transactions()
+            .iter()
+            .map(|tx| tx.transaction_hash())
+            .collect::<Vec<_>>(),
+        vec![TransactionHash(stark_felt!("0x2"))]
+    );
+}
+
+#[test]
+fn test_advance_time_and_mine() {
+    let mut starknet = StarknetWrapper::new(StarknetConfig {
+        timestamp_source: TimestampSource::Step,
+        ..Default::default()
+    });
+    starknet.generate_pending_block();
+    starknet.generate_latest_block().unwrap();
+    starknet.generate_pending_block();
+
+    let genesis_timestamp = starknet.blocks.latest().unwrap().header().timestamp.0;
+
+    let mined = starknet.advance_time_and_mine(3600, 6, true).unwrap();
+    assert_eq!(mined.len(), 6);
+    assert_eq!(starknet.blocks.total_blocks(), 7);
+
+    let mut timestamp = genesis_timestamp;
+    for block in &mined {
+        assert_eq!(
+            block.header().timestamp.0,
+            timestamp + DETERMINISTIC_BLOCK_TIME_STEP + 600
+        );
+        timestamp = block.header().timestamp.0;
+    }
+    assert_eq!(
+        timestamp,
+        genesis_timestamp + 6 * DETERMINISTIC_BLOCK_TIME_STEP + 3600
+    );
+
+    // Without distributing it, the whole offset lands on the first block
+    let mined = starknet.advance_time_and_mine(3600, 2, false).unwrap();
+    assert_eq!(
+        mined[0].header().timestamp.0,
+        timestamp + DETERMINISTIC_BLOCK_TIME_STEP + 3600
+    );
+    assert_eq!(
+        mined[1].header().timestamp.0,
+        mined[0].header().timestamp.0 + DETERMINISTIC_BLOCK_TIME_STEP
+    );
+
+    assert!(starknet.advance_time_and_mine(3600, 0, true).is_err());
+}
+
+#[test]
+fn test_insufficient_account_balance() {
+    let mut starknet = StarknetWrapper::new(StarknetConfig {
+        total_accounts: 2,
+        account_path: Some(test_account_path()),
+        reject_insufficient_balance: true,
+        ..Default::default()
+    });
+    starknet.generate_pending_block();
+
+    let a = starknet.predeployed_accounts.accounts[0].clone();
+    let b = starknet.predeployed_accounts.accounts[1].clone();
+    let balance = starkfelt_to_u128(*DEFAULT_PREFUNDED_ACCOUNT_BALANCE).unwrap();
+
+    let AccountTransaction::Invoke(InvokeTransaction::V1(tx)) = create_transfer_transaction(
+        a.account_address,
+        b.account_address,
+        0,
+        TransactionHash(stark_felt!("0x1234")),
+    ) else {
+        unreachable!("transfer must be an invoke v1 transaction")
+    };
+
+    let err = starknet
+        .handle_transaction(Transaction::AccountTransaction(AccountTransaction::Invoke(
+            InvokeTransaction::V1(InvokeTransactionV1 {
+                max_fee: Fee(balance + 1),
+                ..tx
+            }),
+        )))
+        .unwrap_err();
+
+    let err = err.downcast_ref::<InsufficientAccountBalance>().unwrap();
+    assert_eq!(err.max_fee, balance + 1);
+    assert_eq!(err.balance, balance);
+    assert!(starknet
+        .transactions
+        .transactions
+        .get(&TransactionHash(stark_felt!("0x1234")))
+        .is_none());
+}
+
+#[test]
+fn test_block_execution_resources() {
+    let mut sequencer = KatanaSequencer::new(StarknetConfig {
+        total_accounts: 2,
+        blocks_on_demand: true,
+        allow_zero_max_fee: true,
+        account_path: Some(test_account_path()),
+        ..Default::default()
+    });
+    sequencer.start();
+
+    let a = sequencer.starknet.predeployed_accounts.accounts[0].clone();
+    let b = sequencer.starknet.predeployed_accounts.accounts[1].clone();
+
+    for nonce in 0..3 {
+        sequencer
+            .add_account_transaction(create_transfer_transaction(
+                a.account_address,
+                b.account_address,
+                nonce,
+                TransactionHash(stark_felt!(nonce + 1)),
+            ))
+            .unwrap();
+    }
+
+    let mut expected = HashMap::new();
+    for nonce in 0..3u64 {
+        let tx = sequencer
+            .starknet
+            .transactions
+            .transactions
+            .get(&TransactionHash(stark_felt!(nonce + 1)))
+            .unwrap();
+
+        for (resource, amount) in &tx.execution_info.as_ref().unwrap().actual_resources.0 {
+            *expected.entry(resource.clone()).or_insert(0) += amount;
+        }
+    }
+
+    assert!(!expected.is_empty());
+    assert_eq!(
+        sequencer.execution_resources(BlockId::Tag(BlockTag::Pending)),
+        Some(expected.clone())
+    );
+
+    sequencer.generate_new_block().unwrap();
+
+    assert_eq!(
+        sequencer.execution_resources(BlockId::Number(0)),
+        Some(expected)
+    );
+    assert_eq!(sequencer.execution_resources(BlockId::Number(1)), None);
+}
+
+// Accepts the next call of the webhook and returns its JSON payload
+async fn receive_webhook_payload(listener: &TcpListener) -> serde_json::Value {
+    let (mut stream, _) = tokio::time::timeout(Duration::from_secs(5), listener.accept())
+        .await
+        .expect("webhook must be called")
+        .unwrap();
+
+    // The JSON payload is the last part of the request.
+    let mut request = Vec::new();
+    let mut buf = [0u8; 1024];
+    while !request.ends_with(b"}") {
+        let n = stream.read(&mut buf).await.unwrap();
+        assert_ne!(n, 0, "connection closed before the payload was received");
+        request.extend_from_slice(&buf[..n]);
+    }
+
+    // Closing the connection makes the next notification open a new one
+    stream
+        .write_all(b"HTTP/1.1 200 OK\r\nconnection: close\r\ncontent-length: 0\r\n\r\n")
+        .await
+        .unwrap();
+
+    let request = String::from_utf8(request).unwrap();
+    let (_, body) = request.split_once("\r\n\r\n").unwrap();
+    serde_json::from_str(body).unwrap()
+}
+
+#[tokio::test]
+async fn test_rejection_webhook() {
+    let listener = TcpListener::bind("127.0.0.1:0").await.unwrap();
+
+    let mut starknet = StarknetWrapper::new(StarknetConfig {
+        allow_zero_max_fee: true,
+        account_path: Some(test_account_path()),
+        reject_insufficient_balance: true,
+        ..Default::default()
+    });
+    starknet.rejection_webhook = Some(RejectionWebhook::spawn(format!(
+        "http://{}",
+        listener.local_addr().unwrap()
+    )));
+    starknet.generate_pending_block();
+
+    // Rejected when executed
+    let transaction_hash = TransactionHash(stark_felt!("0x1234"));
+    starknet
+        .handle_transaction(Transaction::AccountTransaction(AccountTransaction::Invoke(
+            InvokeTransaction::V1(InvokeTransactionV1 {
+                transaction_hash,
+                ..Default::default()
+            }),
+        )))
+        .unwrap();
+
+    let payload = receive_webhook_payload(&listener).await;
+    assert_eq!(payload["type"], "rejected_transaction");
+    assert_eq!(
+        serde_json::from_value::<StarkFelt>(payload["transaction_hash"].clone()).unwrap(),
+        transaction_hash.0
+    );
+    assert!(!payload["reason"].as_str().unwrap().is_empty());
+
+    // Rejected before being executed, as its sender can't cover the max fee
+    let sender = starknet.predeployed_accounts.accounts[0].account_address;
+    let transaction_hash = TransactionHash(stark_felt!("0x5678"));
+    let res = starknet.handle_transaction(Transaction::AccountTransaction(
+        AccountTransaction::Invoke(InvokeTransaction::V1(InvokeTransactionV1 {
+            transaction_hash,
+            sender_address: sender,
+            max_fee: Fee(u128::MAX),
+            ..Default::default()
+        })),
+    ));
+    assert!(res.is_err());
+
+    let payload = receive_webhook_payload(&listener).await;
+    assert_eq!(
+        serde_json::from_value::<StarkFelt>(payload["transaction_hash"].clone()).unwrap(),
+        transaction_hash.0
+    );
+    assert_eq!(
+        serde_json::from_value::<StarkFelt>(payload["sender_address"].clone()).unwrap(),
+        *sender.0.key()
+    );
+}
+
+#[tokio::test]
+async fn test_reorg_webhook() {
+    let listener = TcpListener::bind("127.0.0.1:0").await.unwrap();
+
+    let mut starknet = StarknetWrapper::new(StarknetConfig {
+        total_accounts: 2,
+        blocks_on_demand: true,
+        allow_zero_max_fee: true,
+        account_path: Some(test_account_path()),
+        ..Default::default()
+    });
+    starknet.rejection_webhook = Some(RejectionWebhook::spawn(format!(
+        "http://{}",
+        listener.local_addr().unwrap()
+    )));
+    starknet.generate_pending_block();
+    starknet.generate_latest_block().unwrap();
+    starknet.generate_pending_block();
+
+    let a = starknet.predeployed_accounts.accounts[0].clone();
+    let b = starknet.predeployed_accounts.accounts[1].clone();
+    for nonce in 0..2 {
+        starknet
+            .handle_transaction(Transaction::AccountTransaction(
+                create_transfer_transaction(
+                    a.account_address,
+                    b.account_address,
+                    nonce,
+                    TransactionHash(stark_felt!(nonce + 1)),
+                ),
+            ))
+            .unwrap();
+        starknet.generate_latest_block().unwrap();
+        starknet.generate_pending_block();
+    }
+
+    let tip = starknet.reorg_to(BlockNumber(0), vec![]).unwrap();
+
+    let payload = receive_webhook_payload(&listener).await;
+    assert_eq!(payload["type"], "chain_reorg");
+    assert_eq!(payload["common_ancestor"], 0);
+    assert_eq!(payload["new_tip_number"], 1);
+    assert_eq!(
+        serde_json::from_value::<StarkFelt>(payload["new_tip_hash"].clone()).unwrap(),
+        tip.block_hash().0
+    );
+    assert_eq!(
+        serde_json::from_value::<Vec<StarkFelt>>(payload["dropped_transactions"].clone()).unwrap(),
+        vec![stark_felt!(1_u64), stark_felt!(2_u64)]
+    );
+}
+
+#[test]
+fn test_min_txs_per_block() {
+    let mut starknet = StarknetWrapper::new(StarknetConfig {
+        total_accounts: 2,
+        allow_zero_max_fee: true,
+        min_txs_per_block: Some(3),
+        account_path: Some(test_account_path()),
+        ..Default::default()
+    });
+    starknet.generate_pending_block();
+
+    let a = starknet.predeployed_accounts.accounts[0].clone();
+    let b = starknet.predeployed_accounts.accounts[1].clone();
+
+    for nonce in 0..2 {
+        starknet
+            .handle_transaction(Transaction::AccountTransaction(
+                create_transfer_transaction(
+                    a.account_address,
+                    b.account_address,
+                    nonce,
+                    TransactionHash(stark_felt!(nonce + 1)),
+                ),
+            ))
+            .unwrap();
+    }
+
+    assert_eq!(
+        starknet.blocks.total_blocks(),
+        0,
+        "no block must be mined yet"
+    );
+
+    starknet
+        .handle_transaction(Transaction::AccountTransaction(
+            create_transfer_transaction(
+                a.account_address,
+                b.account_address,
+                2,
+                TransactionHash(stark_felt!(3_u64)),
+            ),
+        ))
+        .unwrap();
+
+    assert_eq!(starknet.blocks.total_blocks(), 1);
+    assert_eq!(
+        starknet
+            .blocks
+            .by_number(BlockNumber(0))
+            .unwrap()
+            .transactions()
+            .len(),
+        3
+    );
+}
+
+#[test]
+fn test_block_wait_timeout() {
+    let mut starknet = StarknetWrapper::new(StarknetConfig {
+        total_accounts: 2,
+        allow_zero_max_fee: true,
+        min_txs_per_block: Some(3),
+        block_wait_timeout: Some(Duration::from_millis(100)),
+        account_path: Some(test_account_path()),
+        ..Default::default()
+    });
+    starknet.generate_pending_block();
+
+    let a = starknet.predeployed_accounts.accounts[0].clone();
+    let b = starknet.predeployed_accounts.accounts[1].clone();
+
+    starknet
+        .handle_transaction(Transaction::AccountTransaction(
+            create_transfer_transaction(
+                a.account_address,
+                b.account_address,
+                0,
+                TransactionHash(stark_felt!("0x6969")),
+            ),
+        ))
+        .unwrap();
+
+    starknet.mine_timed_out_pending_block().unwrap();
+    assert_eq!(
+        starknet.blocks.total_blocks(),
+        0,
+        "timeout must not be reached yet"
+    );
+
+    std::thread::sleep(Duration::from_millis(150));
+    starknet.mine_timed_out_pending_block().unwrap();
+
+    assert_eq!(starknet.blocks.total_blocks(), 1);
+    assert_eq!(
+        starknet
+            .blocks
+            .by_number(BlockNumber(0))
+            .unwrap()
+            .transactions()
+            .len(),
+        1
+    );
+}
+
+#[test]
+fn test_pending_nonce() {
+    let mut sequencer = KatanaSequencer::new(StarknetConfig {
+        total_accounts: 2,
+        blocks_on_demand: true,
+        allow_zero_max_fee: true,
+        account_path: Some(test_account_path()),
+        ..Default::default()
+    });
+    sequencer.start();
+
+    let a = sequencer.starknet.predeployed_accounts.accounts[0].clone();
+    let b = sequencer.starknet.predeployed_accounts.accounts[1].clone();
+
+    for nonce in 0..2 {
+        sequencer
+            .add_account_transaction(create_transfer_transaction(
+                a.account_address,
+                b.account_address,
+                nonce,
+                TransactionHash(stark_felt!(nonce + 1)),
+            ))
+            .unwrap();
+    }
+
+    assert_eq!(
+        sequencer
+            .nonce_at(BlockId::Tag(BlockTag::Latest), a.account_address)
+            .unwrap(),
+        Nonce(stark_felt!(0_u64))
+    );
+    assert_eq!(
+        sequencer.pending_nonce_at(a.account_address).unwrap(),
+        Nonce(stark_felt!(2_u64))
+    );
+
+    sequencer.generate_new_block().unwrap();
+
+    assert_eq!(
+        sequencer
+            .nonce_at(BlockId::Tag(BlockTag::Latest), a.account_address)
+            .unwrap(),
+        Nonce(stark_felt!(2_u64))
+    );
+}
+
+#[test]
+fn test_max_fee_balance_ratio() {
+    let mut starknet = StarknetWrapper::new(StarknetConfig {
+        total_accounts: 2,
+        max_fee_balance_ratio: Some(0.5),
+        account_path: Some(test_account_path()),
+        ..Default::default()
+    });
+    starknet.generate_pending_block();
+
+    let a = starknet.predeployed_accounts.accounts[0].clone();
+    let b = starknet.predeployed_accounts.accounts[1].clone();
+
+    let transaction = |nonce, transaction_hash, max_fee| {
+        let AccountTransaction::Invoke(InvokeTransaction::V1(tx)) = create_transfer_transaction(
+            a.account_address,
+            b.account_address,
+            nonce,
+            transaction_hash,
+        ) else {
+            unreachable!("transfer must be an invoke v1 transaction")
+        };
+
+        Transaction::AccountTransaction(AccountTransaction::Invoke(InvokeTransaction::V1(
+            InvokeTransactionV1 { max_fee, ..tx },
+        )))
+    };
+
+    // The predeployed accounts are funded with 10^21 wei.
+    let rejected_hash = TransactionHash(stark_felt!("0x1234"));
+    let res = starknet.handle_transaction(transaction(
+        0,
+        rejected_hash,
+        Fee(999_999_999_999_999_999_999),
+    ));
+
+    assert!(
+        res.is_err(),
+        "max fee close to the full balance must be rejected"
+    );
+    assert!(starknet
+        .transactions
+        .transactions
+        .get(&rejected_hash)
+        .is_none());
+
+    let accepted_hash = TransactionHash(stark_felt!("0x6969"));
+    starknet
+        .handle_transaction(transaction(0, accepted_hash, Fee(1_000_000_000_000_000)))
+        .unwrap();
+
+    assert_eq!(
+        starknet
+            .transactions
+            .transactions
+            .get(&accepted_hash)
+            .unwrap()
+            .status,
+        TransactionStatus::AcceptedOnL2
+    );
+}
+
+#[test]
+fn test_block_number_by_timestamp() {
+    let mut blocks = StarknetBlocks::default();
+    assert_eq!(blocks.block_number_by_timestamp(BlockTimestamp(100)), None);
+
+    for (number, timestamp) in [100, 200, 200, 300].into_iter().enumerate() {
+        let mut block = StarknetBlock::default();
+        block.inner.header.block_number = BlockNumber(number as u64);
+        block.inner.header.timestamp = BlockTimestamp(timestamp);
+        block.inner.header.block_hash = BlockHash(stark_felt!(number as u64 + 1));
+        blocks.append_block(block).unwrap();
+    }
+
+    // before genesis
+    assert_eq!(blocks.block_number_by_timestamp(BlockTimestamp(99)), None);
+    // exact match
+    assert_eq!(
+        blocks.block_number_by_timestamp(BlockTimestamp(100)),
+        Some(BlockNumber(0))
+    );
+    // in between two blocks
+    assert_eq!(
+        blocks.block_number_by_timestamp(BlockTimestamp(150)),
+        Some(BlockNumber(0))
+    );
+    // several blocks sharing the same timestamp
+    assert_eq!(
+        blocks.block_number_by_timestamp(BlockTimestamp(250)),
+        Some(BlockNumber(2))
+    );
+    // after the latest block
+    assert_eq!(
+        blocks.block_number_by_timestamp(BlockTimestamp(1000)),
+        Some(BlockNumber(3))
+    );
+}
+
+#[test]
+fn test_genesis_messages() {
+    let from_address = stark_felt!("0x1234");
+    let selector = selector_from_name("test_storage_read_write");
+
+    let mut sequencer = KatanaSequencer::new(StarknetConfig {
+        genesis_messages: vec![GenesisMessage {
+            from_address,
+            to_address: ContractAddress(patricia_key!("0x100")),
+            selector,
+            payload: vec![stark_felt!("0x42")],
+        }],
+        ..Default::default()
+    });
+
+    // Expose `test_storage_read_write(address, value)` as an l1_handler, which then
+    // writes the payload to the storage slot keyed by the sender address.
+    let mut class: serde_json::Value =
+        serde_json::from_str(&std::fs::read_to_string(test_contract_path()).unwrap()).unwrap();
+    let entry_point = class["entry_points_by_type"]["EXTERNAL"]
+        .as_array()
+        .unwrap()
+        .iter()
+        .find(|entry| {
+            StarkFelt::try_from(entry["selector"].as_str().unwrap()).unwrap() == selector.0
+        })
+        .cloned()
+        .unwrap();
+    class["entry_points_by_type"]["L1_HANDLER"] = serde_json::json!([entry_point]);
+
+    deploy_contract(
+        &mut sequencer.starknet,
+        ContractAddress(patricia_key!("0x100")),
+        ContractClass::V0(serde_json::from_str::<ContractClassV0>(&class.to_string()).unwrap()),
+    );
+
+    sequencer.start();
+
+    let block = sequencer.starknet.blocks.by_number(BlockNumber(0)).unwrap();
+    assert_eq!(block.transactions().len(), 1);
+
+    let tx = sequencer
+        .starknet
+        .transactions
+        .transactions
+        .get(&block.transactions()[0].transaction_hash())
+        .unwrap();
+    assert_eq!(tx.status, TransactionStatus::AcceptedOnL2);
+
+    let value = sequencer
+        .storage_at(
+            ContractAddress(patricia_key!("0x100")),
+            StorageKey(patricia_key!("0x1234")),
+            BlockId::Number(0),
+        )
+        .unwrap();
+    assert_eq!(value, stark_felt!("0x42"));
+
+    // The keccak of the abi-encoded words (0x1234, 0x100, 0, selector, 1, 0x42), computed
+    // independently of the node
+    let message_hash = sequencer
+        .starknet
+        .message_hash(&block.transactions()[0].transaction_hash())
+        .unwrap();
+    assert_eq!(
+        message_hash,
+        stark_felt!("0x022352966734b2cb669bb8513f2ce24775abe4ee25d0d0603bb8ac25ef3b0410").bytes()
+    );
+    assert_ne!(
+        message_hash,
+        compute_l1_to_l2_message_hash(
+            from_address,
+            ContractAddress(patricia_key!("0x100")),
+            selector,
+            &[stark_felt!("0x42")],
+            Nonce(stark_felt!(1_u64)),
+        )
+    );
+}
+
+#[test]
+fn test_transaction_state_diff() {
+    let mut sequencer = KatanaSequencer::new(StarknetConfig {
+        total_accounts: 2,
+        blocks_on_demand: true,
+        allow_zero_max_fee: true,
+        account_path: Some(test_account_path()),
+        ..Default::default()
+    });
+    sequencer.start();
+
+    let a = sequencer.starknet.predeployed_accounts.accounts[0].clone();
+    let b = sequencer.starknet.predeployed_accounts.accounts[1].clone();
+
+    // Both transfers write to the balance slot of the recipient in the same block.
+    let hashes = [
+        TransactionHash(stark_felt!("0x1")),
+        TransactionHash(stark_felt!("0x2")),
+    ];
+    for (nonce, hash) in hashes.iter().enumerate() {
+        sequencer
+            .add_account_transaction(create_transfer_transaction(
+                a.account_address,
+                b.account_address,
+                nonce as u64,
+                *hash,
+            ))
+            .unwrap();
+    }
+    sequencer.generate_new_block().unwrap();
+
+    let balance_key: FieldElement =
+        (*get_storage_var_address("ERC20_balances", &[*b.account_address.0.key()])
+            .unwrap()
+            .0
+            .key())
+        .into();
+    let initial_balance = starkfelt_to_u128(*DEFAULT_PREFUNDED_ACCOUNT_BALANCE).unwrap();
+
+    for (i, hash) in hashes.iter().enumerate() {
+        let state_diff = sequencer.transaction_state_diff(hash).unwrap();
+
+        let balance = state_diff
+            .storage_diffs
+            .iter()
+            .find(|diff| diff.address == FieldElement::from(*FEE_TOKEN_ADDRESS))
+            .and_then(|diff| diff.storage_entries.iter().find(|e| e.key == balance_key))
+            .map(|entry| entry.value)
+            .unwrap();
+        assert_eq!(
+            balance,
+            FieldElement::from(initial_balance + 0x99 * (i as u128 + 1))
+        );
+
+        let nonces = state_diff
+            .nonces
+            .iter()
+            .map(|update| (update.contract_address, update.nonce))
+            .collect::<Vec<_>>();
+        assert_eq!(
+            nonces,
+            vec![(
+                FieldElement::from(*a.account_address.0.key()),
+                FieldElement::from(i as u64 + 1)
+            )]
+        );
+    }
+}
+
+#[test]
+fn test_transaction_state_diff_same_value() {
+    let contract_address = ContractAddress(patricia_key!("0x100"));
+    let key = stark_felt!("0x7");
+
+    let mut sequencer = KatanaSequencer::new(StarknetConfig {
+        total_accounts: 1,
+        blocks_on_demand: true,
+        allow_zero_max_fee: true,
+        account_path: Some(test_account_path()),
+        ..Default::default()
+    });
+    deploy_contract(
+        &mut sequencer.starknet,
+        contract_address,
+        test_contract_class(),
+    );
+    sequencer.start();
+
+    // Both transactions store the same value to the same slot in the same block
+    let account = sequencer.starknet.predeployed_accounts.accounts[0].account_address;
+    let hashes = [
+        TransactionHash(stark_felt!("0x1")),
+        TransactionHash(stark_felt!("0x2")),
+    ];
+    for (nonce, hash) in hashes.iter().enumerate() {
+        sequencer
+            .add_account_transaction(AccountTransaction::Invoke(InvokeTransaction::V1(
+                InvokeTransactionV1 {
+                    sender_address: account,
+                    calldata: calldata![
+                        *contract_address.0.key(),
+                        selector_from_name("test_storage_read_write").0,
+                        stark_felt!(2_u64),
+                        key,
+                        stark_felt!(5_u64)
+                    ],
+                    nonce: Nonce(stark_felt!(nonce as u64)),
+                    transaction_hash: *hash,
+                    ..Default::default()
+                },
+            )))
+            .unwrap();
+    }
+    sequencer.generate_new_block().unwrap();
+
+    for hash in &hashes {
+        let state_diff = sequencer.transaction_state_diff(hash).unwrap();
+        let entries = state_diff
+            .storage_diffs
+            .iter()
+            .find(|diff| diff.address == FieldElement::from(*contract_address.0.key()))
+            .map(|diff| {
+                diff.storage_entries
+                    .iter()
+                    .map(|entry| (entry.key, entry.value))
+                    .collect::<Vec<_>>()
+            });
+        assert_eq!(
+            entries,
+            Some(vec![(FieldElement::from(key), FieldElement::from(5_u64))])
+        );
+    }
+}
+
+#[test]
+fn test_pending_block_receipts() {
+    let mut sequencer = KatanaSequencer::new(StarknetConfig {
+        total_accounts: 2,
+        blocks_on_demand: true,
+        allow_zero_max_fee: true,
+        account_path: Some(test_account_path()),
+        ..Default::default()
+    });
+    sequencer.start();
+
+    let a = sequencer.starknet.predeployed_accounts.accounts[0].clone();
+    let b = sequencer.starknet.predeployed_accounts.accounts[1].clone();
+
+    let hashes = [
+        TransactionHash(stark_felt!("0x1")),
+        TransactionHash(stark_felt!("0x2")),
+    ];
+    for (nonce, hash) in hashes.iter().enumerate() {
+        sequencer
+            .add_account_transaction(create_transfer_transaction(
+                a.account_address,
+                b.account_address,
+                nonce as u64,
+                *hash,
+            ))
+            .unwrap();
+    }
+
+    let block = sequencer.block(BlockId::Tag(BlockTag::Pending)).unwrap();
+    assert_eq!(
+        block
+            .transactions()
+            .iter()
+            .map(|tx| tx.transaction_hash())
+            .collect::<Vec<_>>(),
+        hashes
+    );
+
+    for hash in &hashes {
+        let receipt = sequencer.transaction_receipt(hash).unwrap();
+        assert_eq!(receipt.transaction_hash, *hash);
+    }
+
+    let state_diff = sequencer.pending_state_diff();
+    assert!(state_diff.nonces.iter().any(|update| {
+        update.contract_address == FieldElement::from(*a.account_address.0.key())
+            && update.nonce == FieldElement::TWO
+    }));
+    assert_eq!(sequencer.starknet.blocks.total_blocks(), 0);
+}
+
+#[test]
+fn test_max_declares_per_block() {
+    let mut sequencer = KatanaSequencer::new(StarknetConfig {
+        total_accounts: 2,
+        blocks_on_demand: true,
+        allow_zero_max_fee: true,
+        max_declares_per_block: Some(1),
+        account_path: Some(test_account_path()),
+        ..Default::default()
+    });
+    sequencer.start();
+
+    let a = sequencer.starknet.predeployed_accounts.accounts[0].clone();
+    let b = sequencer.starknet.predeployed_accounts.accounts[1].clone();
+
+    let contract_class = test_contract_class();
+    let declare = |nonce: u64, class_hash, transaction_hash| {
+        AccountTransaction::Declare(DeclareTransaction {
+            tx: starknet_api::transaction::DeclareTransaction::V1(DeclareTransactionV0V1 {
+                sender_address: a.account_address,
+                class_hash: ClassHash(class_hash),
+                nonce: Nonce(stark_felt!(nonce)),
+                transaction_hash: TransactionHash(transaction_hash),
+                ..Default::default()
+            }),
+            contract_class: contract_class.clone(),
+        })
+    };
+
+    sequencer
+        .add_account_transaction(declare(0, stark_felt!("0x1111"), stark_felt!("0x1")))
+        .unwrap();
+    sequencer
+        .add_account_transaction(declare(1, stark_felt!("0x2222"), stark_felt!("0x2")))
+        .unwrap();
+    sequencer
+        .add_account_transaction(create_transfer_transaction(
+            b.account_address,
+            a.account_address,
+            0,
+            TransactionHash(stark_felt!("0x3")),
+        ))
+        .unwrap();
+
+    assert_eq!(sequencer.starknet.declare_queue.len(), 1);
+
+    sequencer.generate_new_block().unwrap();
+    sequencer.generate_new_block().unwrap();
+
+    let block_hashes = |number| {
+        sequencer
+            .starknet
+            .blocks
+            .by_number(BlockNumber(number))
+            .unwrap()
+            .transactions()
+            .iter()
+            .map(|tx| tx.transaction_hash())
+            .collect::<Vec<_>>()
+    };
+
+    // The second declare is deferred to the next block while the invoke isn't affected.
+    assert_eq!(
+        block_hashes(0),
+        vec![
+            TransactionHash(stark_felt!("0x1")),
+            TransactionHash(stark_felt!("0x3"))
+        ]
+    );
+    assert_eq!(block_hashes(1), vec![TransactionHash(stark_felt!("0x2"))]);
+    assert!(sequencer.starknet.declare_queue.is_empty());
+}
+
+#[test]
+fn test_queued_declares_are_mined_when_due() {
+    let mut sequencer = KatanaSequencer::new(StarknetConfig {
+        total_accounts: 1,
+        blocks_on_demand: true,
+        allow_zero_max_fee: true,
+        max_declares_per_block: Some(1),
+        account_path: Some(test_account_path()),
+        ..Default::default()
+    });
+    sequencer.start();
+
+    let a = sequencer.starknet.predeployed_accounts.accounts[0].clone();
+    let contract_class = test_contract_class();
+    for (nonce, class_hash) in [(0_u64, "0x1111"), (1, "0x2222"), (2, "0x3333")] {
+        sequencer
+            .add_account_transaction(AccountTransaction::Declare(DeclareTransaction {
+                tx: starknet_api::transaction::DeclareTransaction::V1(DeclareTransactionV0V1 {
+                    sender_address: a.account_address,
+                    class_hash: ClassHash(stark_felt!(class_hash)),
+                    nonce: Nonce(stark_felt!(nonce)),
+                    transaction_hash: TransactionHash(stark_felt!(nonce + 1)),
+                    ..Default::default()
+                }),
+                contract_class: contract_class.clone(),
+            }))
+            .unwrap();
+    }
+    assert_eq!(sequencer.starknet.declare_queue.len(), 2);
+
+    // Once every transaction is due to be mined, the declares moved out of the queue don't
+    // linger in the pending block
+    sequencer.starknet.config.blocks_on_demand = false;
+    sequencer.starknet.config.min_txs_per_block = Some(1);
+    sequencer.generate_new_block().unwrap();
+
+    for number in 0..=2 {
+        let block = sequencer
+            .starknet
+            .blocks
+            .by_number(BlockNumber(number))
+            .unwrap();
+        assert_eq!(
+            block
+                .transactions()
+                .iter()
+                .map(|tx| tx.transaction_hash())
+                .collect::<Vec<_>>(),
+            vec![TransactionHash(stark_felt!(number + 1))]
+        );
+    }
+    assert!(sequencer.starknet.declare_queue.is_empty());
+    assert_eq!(sequencer.starknet.pending_transaction_count(), 0);
+}
+
+#[test]
+fn test_genesis_calls() {
+    let contract_address = ContractAddress(patricia_key!("0x100"));
+    let owner_key = stark_felt!("0x7");
+    let owner = stark_felt!("0x1234");
+
+    let mut sequencer = KatanaSequencer::new(StarknetConfig {
+        genesis_calls: vec![GenesisCall {
+            contract_address,
+            selector: selector_from_name("test_storage_read_write"),
+            calldata: vec![owner_key, owner],
+            caller: ContractAddress::default(),
+        }],
+        ..Default::default()
+    });
+    deploy_contract(
+        &mut sequencer.starknet,
+        contract_address,
+        test_contract_class(),
+    );
+
+    sequencer.start();
+
+    assert_eq!(sequencer.starknet.blocks.total_blocks(), 1);
+    let value = sequencer
+        .storage_at(
+            contract_address,
+            StorageKey(patricia_key!(owner_key)),
+            BlockId::Number(0),
+        )
+        .unwrap();
+    assert_eq!(value, owner);
+}
+
+#[test]
+fn test_failing_genesis_call() {
+    let contract_address = ContractAddress(patricia_key!("0x100"));
+    let call = |num| GenesisCall {
+        contract_address,
+        selector: selector_from_name("with_arg"),
+        calldata: vec![stark_felt!(num)],
+        caller: ContractAddress::default(),
+    };
+
+    // `with_arg` asserts its argument is 25.
+    let mut starknet = StarknetWrapper::new(StarknetConfig {
+        genesis_calls: vec![call(25_u64), call(24_u64)],
+        ..Default::default()
+    });
+    deploy_contract(&mut starknet, contract_address, test_contract_class());
+    starknet.generate_pending_block();
+
+    let err = starknet.process_genesis().unwrap_err();
+    assert!(err.to_string().starts_with("genesis call 1 failed"));
+}
+
+#[test]
+fn test_failing_genesis_message() {
+    let message = GenesisMessage {
+        from_address: stark_felt!("0x1234"),
+        to_address: ContractAddress(patricia_key!("0x100")),
+        selector: selector_from_name("test_storage_read_write"),
+        payload: vec![stark_felt!("0x42")],
+    };
+
+    // The message targets an undeployed contract.
+    let mut starknet = StarknetWrapper::new(StarknetConfig {
+        genesis_messages: vec![message.clone()],
+        ..Default::default()
+    });
+    starknet.generate_pending_block();
+
+    let err = starknet.process_genesis().unwrap_err();
+    assert!(err.to_string().starts_with("genesis message 0 failed"));
+
+    // The chain id doesn't fit in a short string.
+    let mut starknet = StarknetWrapper::new(StarknetConfig {
+        chain_id: "a".repeat(32),
+        genesis_messages: vec![message],
+        ..Default::default()
+    });
+    starknet.generate_pending_block();
+
+    assert!(starknet.process_genesis().is_err());
+}
+
+#[test]
+fn test_ordered_events_across_nested_calls() {
+    let event = |order: usize, key: u64| OrderedEvent {
+        order,
+        event: EventContent {
+            keys: vec![EventKey(stark_felt!(key))],
+            data: EventData(vec![]),
+        },
+    };
+    let call = |address: &str, events, inner_calls| CallInfo {
+        call: CallEntryPoint {
+            storage_address: ContractAddress(patricia_key!(address)),
+            ..Default::default()
+        },
+        execution: CallExecution {
+            events,
+            ..Default::default()
+        },
+        inner_calls,
+        ..Default::default()
+    };
+
+    // The outer contract emits an event before and after calling the inner contract.
+    let call_info = call(
+        "0x1",
+        vec![event(0, 1), event(2, 3)],
+        vec![call("0x2", vec![event(1, 2)], vec![])],
+    );
+
+    let events = ordered_events(&call_info)
+        .into_iter()
+        .map(|e| (e.from_address, e.content.keys[0].0))
+        .collect::<Vec<_>>();
+
+    assert_eq!(
+        events,
+        vec![
+            (ContractAddress(patricia_key!("0x1")), stark_felt!(1_u64)),
+            (ContractAddress(patricia_key!("0x2")), stark_felt!(2_u64)),
+            (ContractAddress(patricia_key!("0x1")), stark_felt!(3_u64)),
+        ]
+    );
+}
+
+#[test]
+fn test_receipt_includes_nested_call_events() {
+    let mut starknet = create_test_starknet();
+    starknet.generate_pending_block();
+
+    let a = starknet.predeployed_accounts.accounts[0].clone();
+    let b = starknet.predeployed_accounts.accounts[1].clone();
+
+    let hash = TransactionHash(stark_felt!("0x6969"));
+    starknet
+        .handle_transaction(Transaction::AccountTransaction(
+            create_transfer_transaction(a.account_address, b.account_address, 0, hash),
+        ))
+        .unwrap();
+
+    // The `Transfer` event is emitted by the fee token, called from the account's `__execute__`.
+    let events = starknet.transactions.transactions[&hash].emitted_events();
+    assert_eq!(events.len(), 1);
+    assert_eq!(*events[0].from_address.0.key(), *FEE_TOKEN_ADDRESS);
+    assert_eq!(
+        events[0].content.keys,
+        vec![EventKey(selector_from_name("Transfer").0)]
+    );
+}
+
+#[test]
+fn test_deploy_accounts_as_txs() {
+    let mut sequencer = KatanaSequencer::new(StarknetConfig {
+        total_accounts: 2,
+        deploy_accounts_as_txs: true,
+        ..Default::default()
+    });
+    sequencer.start();
+
+    let block = sequencer.starknet.blocks.by_number(BlockNumber(0)).unwrap();
+    assert_eq!(block.transactions().len(), 2);
+
+    let accounts = sequencer.starknet.predeployed_accounts.accounts.clone();
+    for (account, tx) in accounts.iter().zip(block.transactions()) {
+        let starknet_api::transaction::Transaction::DeployAccount(deploy) = tx else {
+            panic!("expected a deploy account transaction");
+        };
+        assert_eq!(deploy.contract_address, account.account_address);
+
+        let receipt = sequencer
+            .transaction_receipt(&deploy.transaction_hash)
+            .unwrap();
+        assert_eq!(receipt.block_number, BlockNumber(0));
+
+        assert_eq!(
+            sequencer
+                .class_hash_at(BlockId::Tag(BlockTag::Latest), account.account_address)
+                .unwrap(),
+            account.class_hash
+        );
+        assert_eq!(
+            sequencer
+                .nonce_at(BlockId::Tag(BlockTag::Latest), account.account_address)
+                .unwrap(),
+            Nonce(stark_felt!(1_u64))
+        );
+    }
+}
+
+#[test]
+fn test_deterministic_block_hashes() {
+    let run_script = || {
+        let mut starknet = StarknetWrapper::new(StarknetConfig {
+            total_accounts: 2,
+            allow_zero_max_fee: true,
+            timestamp_source: TimestampSource::Step,
+            account_path: Some(test_account_path()),
+            ..Default::default()
+        });
+        starknet.generate_pending_block();
+
+        let a = starknet.predeployed_accounts.accounts[0].clone();
+        let b = starknet.predeployed_accounts.accounts[1].clone();
+
+        for nonce in 0..3u64 {
+            starknet
+                .handle_transaction(Transaction::AccountTransaction(
+                    create_transfer_transaction(
+                        a.account_address,
+                        b.account_address,
+                        nonce,
+                        TransactionHash(stark_felt!(nonce + 1)),
+                    ),
+                ))
+                .unwrap();
+        }
+
+        (0..starknet.blocks.total_blocks() as u64)
+            .map(|number| {
+                let block = starknet.blocks.by_number(BlockNumber(number)).unwrap();
+                (block.block_hash(), block.header().timestamp)
+            })
+            .collect::<Vec<_>>()
+    };
+
+    let blocks = run_script();
+    assert_eq!(blocks.len(), 3);
+    assert_eq!(blocks, run_script());
+
+    for (number, (_, timestamp)) in blocks.iter().enumerate() {
+        assert_eq!(
+            *timestamp,
+            BlockTimestamp(number as u64 * DETERMINISTIC_BLOCK_TIME_STEP)
+        );
+    }
+}
+
+#[test]
+fn test_transactions_by_sender() {
+    let mut sequencer = KatanaSequencer::new(StarknetConfig {
+        total_accounts: 2,
+        allow_zero_max_fee: true,
+        account_path: Some(test_account_path()),
+        ..Default::default()
+    });
+    sequencer.start();
+
+    let a = sequencer.starknet.predeployed_accounts.accounts[0].clone();
+    let b = sequencer.starknet.predeployed_accounts.accounts[1].clone();
+
+    // Each transaction is mined in its own block, alternating between the two senders.
+    let mut hashes_by_a = vec![];
+    for nonce in 0..3u64 {
+        let hash_a = TransactionHash(stark_felt!(nonce * 2 + 1));
+        let hash_b = TransactionHash(stark_felt!(nonce * 2 + 2));
+        sequencer
+            .add_account_transaction(create_transfer_transaction(
+                a.account_address,
+                b.account_address,
+                nonce,
+                hash_a,
+            ))
+            .unwrap();
+        sequencer
+            .add_account_transaction(create_transfer_transaction(
+                b.account_address,
+                a.account_address,
+                nonce,
+                hash_b,
+            ))
+            .unwrap();
+        hashes_by_a.push(hash_a);
+    }
+
+    let hashes = sequencer
+        .transactions_by_sender(
+            a.account_address,
+            BlockId::Number(0),
+            BlockId::Tag(BlockTag::Latest),
+        )
+        .unwrap();
+    assert_eq!(
+        hashes,
+        hashes_by_a.iter().rev().copied().collect::<Vec<_>>()
+    );
+
+    // Of blocks 1 to 3, only block 2 contains a transaction sent by `a`.
+    let hashes = sequencer
+        .transactions_by_sender(a.account_address, BlockId::Number(1), BlockId::Number(3))
+        .unwrap();
+    assert_eq!(hashes, vec![hashes_by_a[1]]);
+}
+
+#[test]
+fn test_supports_interface() {
+    // The default account implements the ERC165-style introspection
+    let mut sequencer = KatanaSequencer::new(StarknetConfig {
+        total_accounts: 1,
+        ..Default::default()
+    });
+    sequencer.start();
+
+    let account = sequencer.starknet.predeployed_accounts.accounts[0].account_address;
+    let erc165_id = stark_felt!("0x01ffc9a7");
+    let account_id = stark_felt!("0xa66bd575");
+    let invalid_id = stark_felt!("0xffffffff");
+
+    assert!(sequencer.supports_interface(account, erc165_id));
+    assert!(sequencer.supports_interface(account, account_id));
+    assert!(!sequencer.supports_interface(account, invalid_id));
+
+    // The fee token doesn't implement introspection at all, which isn't cached
+    let fee_token = ContractAddress(patricia_key!(*FEE_TOKEN_ADDRESS));
+    assert!(!sequencer.supports_interface(fee_token, erc165_id));
+
+    assert_eq!(sequencer.interface_support.len(), 3);
+    assert!(matches!(
+        sequencer.interface_support.get(&(account, account_id)),
+        Some((_, true))
+    ));
+
+    // Rewriting the pending state drops the cached results
+    sequencer.clear_pool();
+    assert!(sequencer.interface_support.is_empty());
+    assert!(sequencer.supports_interface(account, account_id));
+}
+
+#[test]
+fn test_clear_pool() {
+    let mut sequencer = KatanaSequencer::new(StarknetConfig {
+        total_accounts: 2,
+        blocks_on_demand: true,
+        allow_zero_max_fee: true,
+        account_path: Some(test_account_path()),
+        ..Default::default()
+    });
+    sequencer.start();
+
+    let a = sequencer.starknet.predeployed_accounts.accounts[0].clone();
+    let b = sequencer.starknet.predeployed_accounts.accounts[1].clone();
+
+    for nonce in 0..3 {
+        sequencer
+            .add_account_transaction(create_transfer_transaction(
+                a.account_address,
+                b.account_address,
+                nonce,
+                TransactionHash(stark_felt!(nonce + 1)),
+            ))
+            .unwrap();
+    }
+
+    assert_eq!(
+        sequencer.pending_nonce_at(a.account_address).unwrap(),
+        Nonce(stark_felt!(3_u64))
+    );
+
+    assert_eq!(sequencer.clear_pool(), 3);
+
+    let pending = sequencer.block(BlockId::Tag(BlockTag::Pending)).unwrap();
+    assert!(pending.transactions().is_empty());
+    assert!(sequencer
+        .transaction(&TransactionHash(stark_felt!(1_u64)))
+        .is_none());
+    assert_eq!(
+        sequencer.pending_nonce_at(a.account_address).unwrap(),
+        Nonce(stark_felt!(0_u64))
+    );
+
+    sequencer.generate_new_block().unwrap();
+
+    let latest = sequencer.block(BlockId::Tag(BlockTag::Latest)).unwrap();
+    assert!(latest.transactions().is_empty());
+    assert_eq!(sequencer.clear_pool(), 0);
+}
+
+#[test]
+fn test_genesis_storage() {
+    let contract_address = ContractAddress(patricia_key!("0x100"));
+    let dir = std::env::temp_dir();
+
+    let csv_path = dir.join(format!("katana-genesis-storage-{}.csv", std::process::id()));
+    std::fs::write(
+        &csv_path,
+        "contract_address,key,value\n0x100,0x1,0x11\n0x100,0x2,0x22\n",
+    )
+    .unwrap();
+
+    let json_path = dir.join(format!(
+        "katana-genesis-storage-{}.json",
+        std::process::id()
+    ));
+    std::fs::write(
+        &json_path,
+        r#"[{"contract_address":"0x100","key":"0x3","value":"0x33"}]"#,
+    )
+    .unwrap();
+
+    for (path, expected) in [
+        (&csv_path, vec![("0x1", "0x11"), ("0x2", "0x22")]),
+        (&json_path, vec![("0x3", "0x33")]),
+    ] {
+        let mut sequencer = KatanaSequencer::new(StarknetConfig {
+            genesis_storage: Some(path.clone()),
+            ..Default::default()
+        });
+        deploy_contract(
+            &mut sequencer.starknet,
+            contract_address,
+            test_contract_class(),
+        );
+
+        sequencer.start();
+
+        for (key, value) in expected {
+            assert_eq!(
+                sequencer
+                    .storage_at(
+                        contract_address,
+                        StorageKey(patricia_key!(key)),
+                        BlockId::Tag(BlockTag::Latest),
+                    )
+                    .unwrap(),
+                stark_felt!(value)
+            );
+        }
+    }
+
+    // Storage of a contract that isn't deployed is rejected
+    let mut starknet = StarknetWrapper::new(StarknetConfig {
+        genesis_storage: Some(csv_path.clone()),
+        ..Default::default()
+    });
+    starknet.generate_pending_block();
+    assert!(starknet.process_genesis().is_err());
+
+    std::fs::remove_file(csv_path).unwrap();
+    std::fs::remove_file(json_path).unwrap();
+}
+
+#[test]
+fn test_fee_estimate_cache() {
+    let mut sequencer = KatanaSequencer::new(StarknetConfig {
+        total_accounts: 2,
+        blocks_on_demand: true,
+        allow_zero_max_fee: true,
+        account_path: Some(test_account_path()),
+        fee_estimate_cache_ttl: Some(Duration::from_secs(60)),
+        ..Default::default()
+    });
+    sequencer.start();
+
+    let a = sequencer.starknet.predeployed_accounts.accounts[0].clone();
+    let b = sequencer.starknet.predeployed_accounts.accounts[1].clone();
+    let transfer = || {
+        create_transfer_transaction(
+            a.account_address,
+            b.account_address,
+            0,
+            TransactionHash(stark_felt!(1_u64)),
+        )
+    };
+    let cache_hits = |sequencer: &KatanaSequencer| {
+        sequencer
+            .fee_estimate_cache
+            .as_ref()
+            .unwrap()
+            .lock()
+            .unwrap()
+            .hits()
+    };
+
+    let estimate = sequencer
+        .estimate_fee(transfer(), BlockId::Tag(BlockTag::Pending))
+        .unwrap();
+    assert_eq!(cache_hits(&sequencer), 0);
+
+    let cached = sequencer
+        .estimate_fee(transfer(), BlockId::Tag(BlockTag::Pending))
+        .unwrap();
+    assert_eq!(cache_hits(&sequencer), 1);
+    assert_eq!(cached.overall_fee, estimate.overall_fee);
+
+    // Mining a block invalidates the cached estimates
+    sequencer.generate_new_block().unwrap();
+    sequencer
+        .estimate_fee(transfer(), BlockId::Tag(BlockTag::Pending))
+        .unwrap();
+    assert_eq!(cache_hits(&sequencer), 1);
+}
+
+#[test]
+fn test_call_cache() {
+    let mut sequencer = KatanaSequencer::new(StarknetConfig {
+        total_accounts: 2,
+        blocks_on_demand: true,
+        allow_zero_max_fee: true,
+        account_path: Some(test_account_path()),
+        call_cache: true,
+        ..Default::default()
+    });
+    sequencer.start();
+
+    let a = sequencer.starknet.predeployed_accounts.accounts[0].clone();
+    let b = sequencer.starknet.predeployed_accounts.accounts[1].clone();
+    let balance_of_b = |sequencer: &KatanaSequencer, block_id: BlockId| {
+        sequencer
+            .call(
+                block_id,
+                ExternalFunctionCall {
+                    contract_address: ContractAddress(patricia_key!(*FEE_TOKEN_ADDRESS)),
+                    entry_point_selector: selector_from_name("balanceOf"),
+                    calldata: calldata![*b.account_address.0.key()],
+                },
+            )
+            .unwrap()[0]
+    };
+    let cache_hits = |sequencer: &KatanaSequencer| {
+        sequencer
+            .call_cache
+            .as_ref()
+            .unwrap()
+            .lock()
+            .unwrap()
+            .hits()
+    };
+
+    let balance = balance_of_b(&sequencer, BlockId::Tag(BlockTag::Pending));
+    assert_eq!(cache_hits(&sequencer), 0);
+    assert_eq!(
+        balance_of_b(&sequencer, BlockId::Tag(BlockTag::Pending)),
+        balance
+    );
+    assert_eq!(cache_hits(&sequencer), 1);
+
+    // The transfer changes the pending state, so the balance is recomputed
+    sequencer
+        .add_account_transaction(create_transfer_transaction(
+            a.account_address,
+            b.account_address,
+            0,
+            TransactionHash(stark_felt!(1_u64)),
+        ))
+        .unwrap();
+
+    let new_balance = balance_of_b(&sequencer, BlockId::Tag(BlockTag::Pending));
+    assert_eq!(cache_hits(&sequencer), 1);
+    assert_eq!(
+        starkfelt_to_u128(new_balance).unwrap(),
+        starkfelt_to_u128(balance).unwrap() + 0x99
+    );
+
+    // Mined blocks are served from the cache too
+    sequencer.generate_new_block().unwrap();
+    assert_eq!(
+        balance_of_b(&sequencer, BlockId::Tag(BlockTag::Latest)),
+        new_balance
+    );
+    assert_eq!(
+        balance_of_b(&sequencer, BlockId::Tag(BlockTag::Latest)),
+        new_balance
+    );
+    assert_eq!(cache_hits(&sequencer), 2);
+
+    // Dripping writes to the pending state without adding a transaction, and still
+    // invalidates the cached results. The drip is written whether or not the deployment
+    // succeeds.
+    let salt = ContractAddressSalt(stark_felt!("0x42"));
+    let constructor_calldata = calldata![stark_felt!("0x1")];
+    let dripped = calculate_contract_address(
+        salt,
+        a.class_hash,
+        &constructor_calldata,
+        ContractAddress::default(),
+    )
+    .unwrap();
+    let balance_of_dripped = |sequencer: &KatanaSequencer| {
+        sequencer
+            .call(
+                BlockId::Tag(BlockTag::Pending),
+                ExternalFunctionCall {
+                    contract_address: ContractAddress(patricia_key!(*FEE_TOKEN_ADDRESS)),
+                    entry_point_selector: selector_from_name("balanceOf"),
+                    calldata: calldata![*dripped.0.key()],
+                },
+            )
+            .unwrap()[0]
+    };
+
+    assert_eq!(balance_of_dripped(&sequencer), stark_felt!(0_u64));
+    let _ = sequencer.drip_and_deploy_account(
+        a.class_hash,
+        TransactionVersion(stark_felt!(1_u64)),
+        salt,
+        constructor_calldata,
+        TransactionSignature::default(),
+        1000,
+    );
+    assert_eq!(balance_of_dripped(&sequencer), stark_felt!(1000_u64));
+    assert_eq!(cache_hits(&sequencer), 2);
+}
+
+#[test]
+fn test_trace_ordering() {
+    let mut sequencer = KatanaSequencer::new(StarknetConfig {
+        total_accounts: 2,
+        blocks_on_demand: true,
+        allow_zero_max_fee: true,
+        max_declares_per_block: Some(1),
+        trace_ordering: true,
+        account_path: Some(test_account_path()),
+        ..Default::default()
+    });
+    sequencer.start();
+
+    let a = sequencer.starknet.predeployed_accounts.accounts[0].clone();
+    let b = sequencer.starknet.predeployed_accounts.accounts[1].clone();
+
+    let contract_class = test_contract_class();
+    let declare = |nonce: u64, class_hash, transaction_hash| {
+        AccountTransaction::Declare(DeclareTransaction {
+            tx: starknet_api::transaction::DeclareTransaction::V1(DeclareTransactionV0V1 {
+                sender_address: a.account_address,
+                class_hash: ClassHash(class_hash),
+                nonce: Nonce(stark_felt!(nonce)),
+                transaction_hash: TransactionHash(transaction_hash),
+                ..Default::default()
+            }),
+            contract_class: contract_class.clone(),
+        })
+    };
+
+    sequencer
+        .add_account_transaction(declare(0, stark_felt!("0x1111"), stark_felt!("0x1")))
+        .unwrap();
+    sequencer
+        .add_account_transaction(declare(1, stark_felt!("0x2222"), stark_felt!("0x2")))
+        .unwrap();
+    sequencer
+        .add_account_transaction(create_transfer_transaction(
+            b.account_address,
+            a.account_address,
+            0,
+            TransactionHash(stark_felt!("0x3")),
+        ))
+        .unwrap();
+    // Invalid nonce
+    sequencer
+        .add_account_transaction(create_transfer_transaction(
+            b.account_address,
+            a.account_address,
+            5,
+            TransactionHash(stark_felt!("0x4")),
+        ))
+        .unwrap();
+
+    sequencer.generate_new_block().unwrap();
+
+    let decisions = |sequencer: &KatanaSequencer| {
+        sequencer
+            .starknet
+            .ordering_trace
+            .as_ref()
+            .unwrap()
+            .last_block
+            .iter()
+            .map(|event| (event.transaction_hash, event.decision.clone()))
+            .collect::<Vec<_>>()
+    };
+
+    let block_0 = decisions(&sequencer);
+    assert_eq!(block_0.len(), 4);
+    assert_eq!(
+        block_0[..3],
+        [
+            (
+                TransactionHash(stark_felt!("0x1")),
+                OrderingDecision::Selected
+            ),
+            (
+                TransactionHash(stark_felt!("0x2")),
+                OrderingDecision::Deferred("declare limit of the block reached".into())
+            ),
+            (
+                TransactionHash(stark_felt!("0x3")),
+                OrderingDecision::Selected
+            ),
+        ]
+    );
+    assert_eq!(block_0[3].0, TransactionHash(stark_felt!("0x4")));
+    assert!(matches!(block_0[3].1, OrderingDecision::Rejected(_)));
+
+    // The deferred declare is selected first in the next block
+    sequencer.generate_new_block().unwrap();
+    assert_eq!(
+        decisions(&sequencer),
+        vec![(
+            TransactionHash(stark_felt!("0x2")),
+            OrderingDecision::Selected
+        )]
+    );
+}
+
+#[test]
+fn test_base_fee_adjustment() {
+    let mut sequencer = KatanaSequencer::new(StarknetConfig {
+        total_accounts: 2,
+        blocks_on_demand: true,
+        allow_zero_max_fee: true,
+        base_fee_target: Some(1),
+        account_path: Some(test_account_path()),
+        ..Default::default()
+    });
+    sequencer.start();
+
+    let a = sequencer.starknet.predeployed_accounts.accounts[0].clone();
+    let b = sequencer.starknet.predeployed_accounts.accounts[1].clone();
+
+    let mut nonce = 0;
+    let mut mine_block = |sequencer: &mut KatanaSequencer, transactions: u64| {
+        for _ in 0..transactions {
+            sequencer
+                .add_account_transaction(create_transfer_transaction(
+                    a.account_address,
+                    b.account_address,
+                    nonce,
+                    TransactionHash(stark_felt!(nonce + 1)),
+                ))
+                .unwrap();
+            nonce += 1;
+        }
+        sequencer.generate_new_block().unwrap();
+        sequencer
+            .block(BlockId::Tag(BlockTag::Latest))
+            .unwrap()
+            .header()
+            .gas_price
+            .0
+    };
+
+    // Blocks above the target raise the gas price of the following blocks
+    let full_prices = (0..3)
+        .map(|_| mine_block(&mut sequencer, 3))
+        .collect::<Vec<_>>();
+    assert_eq!(full_prices[0], DEFAULT_GAS_PRICE);
+    assert!(full_prices[1] > full_prices[0]);
+    assert!(full_prices[2] > full_prices[1]);
+    assert_eq!(
+        full_prices[1],
+        DEFAULT_GAS_PRICE + DEFAULT_GAS_PRICE * 2 / DEFAULT_BASE_FEE_CHANGE_DENOMINATOR
+    );
+
+    // Empty blocks lower it
+    let empty_prices = (0..3)
+        .map(|_| mine_block(&mut sequencer, 0))
+        .collect::<Vec<_>>();
+    assert!(empty_prices[1] < empty_prices[0]);
+    assert!(empty_prices[2] < empty_prices[1]);
+}
+
+#[test]
+fn test_base_fee_adjustment_zero_divisors() {
+    for (target, denominator) in [(0, DEFAULT_BASE_FEE_CHANGE_DENOMINATOR), (1, 0)] {
+        let mut sequencer = KatanaSequencer::new(StarknetConfig {
+            base_fee_target: Some(target),
+            base_fee_change_denominator: denominator,
+            ..Default::default()
+        });
+        sequencer.start();
+
+        // The price can't be scaled, and is left unchanged
+        for _ in 0..2 {
+            sequencer.generate_new_block().unwrap();
+        }
+        assert_eq!(
+            sequencer
+                .block(BlockId::Tag(BlockTag::Latest))
+                .unwrap()
+                .header()
+                .gas_price
+                .0,
+            DEFAULT_GAS_PRICE
+        );
+    }
+}
+
+#[test]
+fn test_effective_gas_price() {
+    let mut sequencer = KatanaSequencer::new(StarknetConfig {
+        total_accounts: 2,
+        blocks_on_demand: true,
+        allow_zero_max_fee: true,
+        base_fee_target: Some(1),
+        account_path: Some(test_account_path()),
+        ..Default::default()
+    });
+    sequencer.start();
+
+    let a = sequencer.starknet.predeployed_accounts.accounts[0].clone();
+    let b = sequencer.starknet.predeployed_accounts.accounts[1].clone();
+    let transfer = |nonce: u64, hash: TransactionHash, max_fee: u128| {
+        let mut transaction =
+            create_transfer_transaction(a.account_address, b.account_address, nonce, hash);
+        if let AccountTransaction::Invoke(InvokeTransaction::V1(tx)) = &mut transaction {
+            tx.max_fee = Fee(max_fee);
+        }
+        transaction
+    };
+    let max_fee = u128::pow(10, 18);
+
+    for nonce in 0..3 {
+        sequencer
+            .add_account_transaction(transfer(
+                nonce,
+                TransactionHash(stark_felt!(nonce + 1)),
+                max_fee,
+            ))
+            .unwrap();
+    }
+
+    let mined_hash = TransactionHash(stark_felt!(1_u64));
+    assert_eq!(
+        sequencer.effective_gas_price(&mined_hash),
+        Some(DEFAULT_GAS_PRICE)
+    );
+
+    sequencer.generate_new_block().unwrap();
+
+    // The full block raised the gas price of the next one
+    let pending_hash = TransactionHash(stark_felt!(4_u64));
+    sequencer
+        .add_account_transaction(transfer(3, pending_hash, max_fee))
+        .unwrap();
+
+    let pending_price = sequencer.effective_gas_price(&pending_hash).unwrap();
+    assert!(pending_price > DEFAULT_GAS_PRICE);
+    assert_eq!(pending_price, sequencer.starknet.block_context.gas_price);
+    assert_eq!(
+        sequencer.effective_gas_price(&mined_hash),
+        Some(
+            sequencer
+                .block(BlockId::Tag(BlockTag::Latest))
+                .unwrap()
+                .header()
+                .gas_price
+                .0
+        )
+    );
+
+    // A zero max fee isn't charged, so it clamps the price down to zero
+    let free_hash = TransactionHash(stark_felt!(5_u64));
+    sequencer
+        .add_account_transaction(transfer(4, free_hash, 0))
+        .unwrap();
+    assert_eq!(sequencer.effective_gas_price(&free_hash), Some(0));
+
+    assert_eq!(
+        sequencer.effective_gas_price(&TransactionHash(stark_felt!(0x1234_u64))),
+        None
+    );
+}
+
+#[test]
+fn test_executor_config() {
+    let mut sequencer = KatanaSequencer::new(StarknetConfig {
+        total_accounts: 2,
+        blocks_on_demand: true,
+        allow_zero_max_fee: true,
+        base_fee_target: Some(1),
+        max_declares_per_block: Some(2),
+        max_block_calldata: Some(1000),
+        fee_estimate_cache_ttl: Some(Duration::from_millis(500)),
+        call_cache: true,
+        account_path: Some(test_account_path()),
+        ..Default::default()
+    });
+    sequencer.start();
+
+    let config = sequencer.executor_config();
+    assert_eq!(config.gas_price, DEFAULT_GAS_PRICE);
+    assert!(config.allow_zero_max_fee);
+    assert_eq!(config.base_fee_target, Some(1));
+    assert_eq!(
+        config.base_fee_change_denominator,
+        DEFAULT_BASE_FEE_CHANGE_DENOMINATOR
+    );
+    assert_eq!(config.max_declares_per_block, Some(2));
+    assert_eq!(config.max_declared_classes, None);
+    assert_eq!(config.max_block_calldata, Some(1000));
+    assert_eq!(config.fee_estimate_cache_ttl_ms, Some(500));
+    assert!(config.call_cache);
+    assert_eq!(
+        config.invoke_tx_max_n_steps,
+        sequencer.starknet.block_context.invoke_tx_max_n_steps as u64
+    );
+
+    // The gas price reflects its adjustment after a full block
+    let a = sequencer.starknet.predeployed_accounts.accounts[0].account_address;
+    let b = sequencer.starknet.predeployed_accounts.accounts[1].account_address;
+    for nonce in 0..3 {
+        sequencer
+            .add_account_transaction(create_transfer_transaction(
+                a,
+                b,
+                nonce,
+                TransactionHash(stark_felt!(nonce + 1)),
+            ))
+            .unwrap();
+    }
+    sequencer.generate_new_block().unwrap();
+
+    let config = sequencer.executor_config();
+    assert!(config.gas_price > DEFAULT_GAS_PRICE);
+    assert_eq!(config.gas_price, sequencer.starknet.block_context.gas_price);
+}
+
+#[test]
+fn test_deployed_contracts() {
+    let class_address = ContractAddress(patricia_key!("0x100"));
+    let class_hash = ClassHash(*class_address.0.key());
+
+    let mut sequencer = KatanaSequencer::new(StarknetConfig {
+        total_accounts: 1,
+        blocks_on_demand: true,
+        allow_zero_max_fee: true,
+        account_path: Some(test_account_path()),
+        ..Default::default()
+    });
+    deploy_contract(
+        &mut sequencer.starknet,
+        class_address,
+        test_contract_class(),
+    );
+    sequencer.start();
+
+    let account = sequencer.starknet.predeployed_accounts.accounts[0].account_address;
+    let invoke = |nonce: u64, calldata: Calldata| {
+        AccountTransaction::Invoke(InvokeTransaction::V1(InvokeTransactionV1 {
+            sender_address: account,
+            calldata,
+            nonce: Nonce(stark_felt!(nonce)),
+            transaction_hash: TransactionHash(stark_felt!(nonce + 1)),
+            ..Default::default()
+        }))
+    };
+
+    // Deployed through the UDC
+    sequencer
+        .add_account_transaction(invoke(
+            0,
+            calldata![
+                *UDC_ADDRESS,
+                selector_from_name("deployContract").0,
+                stark_felt!(6_u64),
+                class_hash.0,
+                stark_felt!(1_u64), // salt
+                stark_felt!(0_u64), // unique
+                stark_felt!(2_u64),
+                stark_felt!(1_u64),
+                stark_felt!(2_u64)
+            ],
+        ))
+        .unwrap();
+    // Deployed by the account itself
+    sequencer
+        .add_account_transaction(invoke(
+            1,
+            calldata![
+                *account.0.key(),
+                selector_from_name("deploy_contract").0,
+                stark_felt!(5_u64),
+                class_hash.0,
+                stark_felt!(2_u64), // salt
+                stark_felt!(2_u64),
+                stark_felt!(1_u64),
+                stark_felt!(2_u64)
+            ],
+        ))
+        .unwrap();
+
+    let udc_deployed = calculate_contract_address(
+        ContractAddressSalt(stark_felt!(1_u64)),
+        class_hash,
+        &calldata![stark_felt!(1_u64), stark_felt!(2_u64)],
+        ContractAddress::default(),
+    )
+    .unwrap();
+
+    let assert_deployed = |deployed: Vec<DeployedContractItem>| {
+        assert_eq!(deployed.len(), 2);
+        assert!(deployed
+            .iter()
+            .all(|item| item.class_hash == FieldElement::from(class_hash.0)));
+        assert!(deployed
+            .iter()
+            .any(|item| item.address == FieldElement::from(*udc_deployed.0.key())));
+    };
+
+    assert_deployed(
+        sequencer
+            .deployed_contracts(BlockId::Tag(BlockTag::Pending))
+            .unwrap(),
+    );
+
+    sequencer.generate_new_block().unwrap();
+
+    assert_deployed(sequencer.deployed_contracts(BlockId::Number(0)).unwrap());
+    assert!(sequencer
+        .deployed_contracts(BlockId::Tag(BlockTag::Pending))
+        .unwrap()
+        .is_empty());
+    assert!(sequencer.deployed_contracts(BlockId::Number(1)).is_none());
+}
+
+#[test]
+fn test_max_declared_classes() {
+    let mut sequencer = KatanaSequencer::new(StarknetConfig {
+        total_accounts: 2,
+        allow_zero_max_fee: true,
+        max_declared_classes: Some(2),
+        account_path: Some(test_account_path()),
+        ..Default::default()
+    });
+    sequencer.start();
+
+    let a = sequencer.starknet.predeployed_accounts.accounts[0].clone();
+    let b = sequencer.starknet.predeployed_accounts.accounts[1].clone();
+
+    let contract_class = test_contract_class();
+    let declare = |nonce: u64, class_hash, transaction_hash| {
+        AccountTransaction::Declare(DeclareTransaction {
+            tx: starknet_api::transaction::DeclareTransaction::V1(DeclareTransactionV0V1 {
+                sender_address: a.account_address,
+                class_hash: ClassHash(class_hash),
+                nonce: Nonce(stark_felt!(nonce)),
+                transaction_hash: TransactionHash(transaction_hash),
+                ..Default::default()
+            }),
+            contract_class: contract_class.clone(),
+        })
+    };
+
+    sequencer
+        .add_account_transaction(declare(0, stark_felt!("0x1111"), stark_felt!("0x1")))
+        .unwrap();
+    sequencer
+        .add_account_transaction(declare(1, stark_felt!("0x2222"), stark_felt!("0x2")))
+        .unwrap();
+
+    let err = sequencer
+        .add_account_transaction(declare(2, stark_felt!("0x3333"), stark_felt!("0x3")))
+        .unwrap_err();
+    assert_eq!(
+        err.to_string(),
+        "maximum number of declared classes (2) reached"
+    );
+    assert!(sequencer
+        .transaction(&TransactionHash(stark_felt!("0x3")))
+        .is_none());
+
+    // Transactions other than declares aren't affected
+    sequencer
+        .add_account_transaction(create_transfer_transaction(
+            b.account_address,
+            a.account_address,
+            0,
+            TransactionHash(stark_felt!("0x4")),
+        ))
+        .unwrap();
+    assert_eq!(sequencer.starknet.blocks.total_blocks(), 3);
+}
+
+#[test]
+fn test_block_schedule() {
+    let time = |s: &str| s.parse::<DateTime<Utc>>().unwrap();
+
+    let every_minute = "0 * * * * *".parse::<BlockSchedule>().unwrap();
+    assert_eq!(
+        every_minute.next_after(time("2023-05-10T12:34:56Z")),
+        Some(time("2023-05-10T12:35:00Z"))
+    );
+    assert_eq!(
+        every_minute.next_after(time("2023-05-10T12:35:00Z")),
+        Some(time("2023-05-10T12:36:00Z"))
+    );
+
+    let quarter_hours = "*/15 9-17 * * 1-5".parse::<BlockSchedule>().unwrap();
+    // Friday evening to Monday morning
+    assert_eq!(
+        quarter_hours.next_after(time("2023-05-12T17:50:00Z")),
+        Some(time("2023-05-15T09:00:00Z"))
+    );
+
+    let leap_days = "0 0 29 2 *".parse::<BlockSchedule>().unwrap();
+    assert_eq!(
+        leap_days.next_after(time("2023-03-01T00:00:00Z")),
+        Some(time("2024-02-29T00:00:00Z"))
+    );
+    let never = "0 0 30 2 *".parse::<BlockSchedule>().unwrap();
+    assert_eq!(never.next_after(time("2023-03-01T00:00:00Z")), None);
+
+    for invalid in [
+        "* * *",
+        "60 * * * *",
+        "*/0 * * * *",
+        "5-1 * * * *",
+        "a * * * *",
+    ] {
+        assert!(invalid.parse::<BlockSchedule>().is_err(), "{invalid}");
+    }
+}
+
+#[tokio::test]
+async fn test_scheduled_block_production() {
+    let sequencer = Arc::new(RwLock::new(KatanaSequencer::new(StarknetConfig {
+        total_accounts: 1,
+        blocks_on_demand: true,
+        ..Default::default()
+    })));
+    sequencer.write().await.start();
+
+    let every_second = "* * * * * *".parse::<BlockSchedule>().unwrap();
+    let producer = tokio::spawn(produce_scheduled_blocks(sequencer.clone(), every_second));
+    tokio::time::sleep(Duration::from_millis(3500)).await;
+    producer.abort();
+
+    let sequencer = sequencer.read().await;
+    let timestamps = (0..sequencer.starknet.blocks.total_blocks())
+        .map(|number| {
+            sequencer
+                .starknet
+                .blocks
+                .by_number(BlockNumber(number as u64))
+                .unwrap()
+                .header()
+                .timestamp
+                .0
+        })
+        .collect::<Vec<_>>();
+
+    // The first block was opened at startup, the following ones on the second boundaries
+    // when the previous block was mined.
+    assert!(timestamps.len() >= 3);
+    assert!(timestamps[1..]
+        .windows(2)
+        .all(|pair| pair[1] == pair[0] + 1));
+}
+
+#[test]
+fn test_override_state_root() {
+    let mut sequencer = KatanaSequencer::new(StarknetConfig {
+        total_accounts: 2,
+        blocks_on_demand: true,
+        allow_zero_max_fee: true,
+        account_path: Some(test_account_path()),
+        ..Default::default()
+    });
+    sequencer.start();
+
+    let a = sequencer.starknet.predeployed_accounts.accounts[0].clone();
+    let b = sequencer.starknet.predeployed_accounts.accounts[1].clone();
+
+    sequencer.override_state_root(stark_felt!("0xdead"));
+    sequencer
+        .add_account_transaction(create_transfer_transaction(
+            a.account_address,
+            b.account_address,
+            0,
+            TransactionHash(stark_felt!(1_u64)),
+        ))
+        .unwrap();
+    sequencer.generate_new_block().unwrap();
+    sequencer.generate_new_block().unwrap();
+
+    let block = |number| sequencer.block(BlockId::Number(number)).unwrap();
+    assert_eq!(block(0).header().state_root.0, stark_felt!("0xdead"));
+    assert_eq!(
+        block(0).block_hash(),
+        block(0).compute_block_hash(),
+        "block hash should commit to the overridden root"
+    );
+    // The override only applies to one block
+    assert_eq!(block(1).header().state_root.0, stark_felt!(0_u64));
+
+    let state_update = sequencer.state_update(BlockId::Number(0)).unwrap();
+    assert_eq!(
+        state_update.new_root,
+        FieldElement::from(stark_felt!("0xdead"))
+    );
+    assert_eq!(
+        sequencer
+            .nonce_at(BlockId::Number(0), a.account_address)
+            .unwrap(),
+        Nonce(stark_felt!(1_u64))
+    );
+}
+
+#[test]
+fn test_storage_across_blocks() {
+    let contract_address = ContractAddress(patricia_key!("0x100"));
+    let key = stark_felt!("0x7");
+
+    let mut sequencer = KatanaSequencer::new(StarknetConfig {
+        total_accounts: 1,
+        blocks_on_demand: true,
+        allow_zero_max_fee: true,
+        account_path: Some(test_account_path()),
+        ..Default::default()
+    });
+    deploy_contract(
+        &mut sequencer.starknet,
+        contract_address,
+        test_contract_class(),
+    );
+    sequencer.start();
+
+    let account = sequencer.starknet.predeployed_accounts.accounts[0].account_address;
+    let mut nonce = 0_u64;
+    let mut write_and_mine = |sequencer: &mut KatanaSequencer, value: Option<u64>| {
+        if let Some(value) = value {
+            sequencer
+                .add_account_transaction(AccountTransaction::Invoke(InvokeTransaction::V1(
+                    InvokeTransactionV1 {
+                        sender_address: account,
+                        calldata: calldata![
+                            *contract_address.0.key(),
+                            selector_from_name("test_storage_read_write").0,
+                            stark_felt!(2_u64),
+                            key,
+                            stark_felt!(value)
+                        ],
+                        nonce: Nonce(stark_felt!(nonce)),
+                        transaction_hash: TransactionHash(stark_felt!(nonce + 1)),
+                        ..Default::default()
+                    },
+                )))
+                .unwrap();
+            nonce += 1;
+        }
+        sequencer.generate_new_block().unwrap();
+    };
+
+    for value in [None, Some(1), None, Some(3), None] {
+        write_and_mine(&mut sequencer, value);
+    }
+
+    let series = sequencer
+        .storage_across_blocks(
+            contract_address,
+            StorageKey(patricia_key!(key)),
+            BlockNumber(0),
+            BlockNumber(4),
+        )
+        .unwrap();
+    let expected = [0_u64, 1, 1, 3, 3].map(|value| stark_felt!(value));
+    assert_eq!(series, expected);
+
+    // Each value matches the per-block storage
+    for (number, value) in series.iter().enumerate() {
+        assert_eq!(
+            sequencer
+                .storage_at(
+                    contract_address,
+                    StorageKey(patricia_key!(key)),
+                    BlockId::Number(number as u64),
+                )
+                .unwrap(),
+            *value
+        );
+    }
+
+    assert!(sequencer
+        .storage_across_blocks(
+            contract_address,
+            StorageKey(patricia_key!(key)),
+            BlockNumber(3),
+            BlockNumber(5),
+        )
+        .is_err());
+}
+
+#[test]
+fn test_reorg_to() {
+    let mut sequencer = KatanaSequencer::new(StarknetConfig {
+        total_accounts: 2,
+        blocks_on_demand: true,
+        allow_zero_max_fee: true,
+        account_path: Some(test_account_path()),
+        ..Default::default()
+    });
+    sequencer.start();
+
+    let a = sequencer.starknet.predeployed_accounts.accounts[0].clone();
+    let b = sequencer.starknet.predeployed_accounts.accounts[1].clone();
+
+    let blocks = (0..3)
+        .map(|nonce| {
+            sequencer
+                .add_account_transaction(create_transfer_transaction(
+                    a.account_address,
+                    b.account_address,
+                    nonce,
+                    TransactionHash(stark_felt!(nonce + 1)),
+                ))
+                .unwrap();
+            sequencer.generate_new_block().unwrap();
+            sequencer.block(BlockId::Tag(BlockTag::Latest)).unwrap()
+        })
+        .collect::<Vec<_>>();
+
+    // Left in the pool, and dropped by the reorg
+    sequencer
+        .add_account_transaction(create_transfer_transaction(
+            a.account_address,
+            b.account_address,
+            3,
+            TransactionHash(stark_felt!(4_u64)),
+        ))
+        .unwrap();
+
+    let replacement_hash = TransactionHash(stark_felt!(0x10_u64));
+    let tip = sequencer
+        .reorg_to(
+            blocks[0].block_number(),
+            vec![create_transfer_transaction(
+                a.account_address,
+                a.account_address,
+                1,
+                replacement_hash,
+            )],
+        )
+        .unwrap();
+
+    assert_eq!(tip.block_number(), blocks[1].block_number());
+    assert_ne!(tip.block_hash(), blocks[1].block_hash());
+    assert_eq!(tip.parent_hash(), blocks[0].block_hash());
+    assert_eq!(
+        tip.transactions()
+            .iter()
+            .map(|tx| tx.transaction_hash())
+            .collect::<Vec<_>>(),
+        vec![replacement_hash]
+    );
+    assert_eq!(
+        sequencer
+            .block(BlockId::Tag(BlockTag::Latest))
+            .unwrap()
+            .block_hash(),
+        tip.block_hash()
+    );
+    assert!(sequencer
+        .block(BlockId::Number(blocks[2].block_number().0))
+        .is_none());
+
+    // The diverged transactions are gone, the replacement one is mined
+    for hash in 2..=4_u64 {
+        assert!(sequencer
+            .transaction(&TransactionHash(stark_felt!(hash)))
+            .is_none());
+    }
+    assert!(sequencer
+        .transaction(&TransactionHash(stark_felt!(1_u64)))
+        .is_some());
+    assert!(sequencer.transaction(&replacement_hash).is_some());
+    assert_eq!(sequencer.starknet.pending_transaction_count(), 0);
+    assert_eq!(
+        sequencer
+            .nonce_at(BlockId::Tag(BlockTag::Latest), a.account_address)
+            .unwrap(),
+        Nonce(stark_felt!(2_u64))
+    );
+
+    // The diverged transactions can be submitted again
+    sequencer
+        .add_account_transaction(create_transfer_transaction(
+            a.account_address,
+            b.account_address,
+            2,
+            TransactionHash(stark_felt!(3_u64)),
+        ))
+        .unwrap();
+    assert!(sequencer
+        .transaction(&TransactionHash(stark_felt!(3_u64)))
+        .is_some());
+
+    // Reorging past the tip fails
+    assert!(sequencer
+        .reorg_to(tip.block_number().next(), vec![])
+        .is_err());
+}
+
+#[test]
+fn test_max_block_calldata() {
+    let create_sequencer = |max_block_calldata| {
+        let mut sequencer = KatanaSequencer::new(StarknetConfig {
+            total_accounts: 2,
+            blocks_on_demand: true,
+            allow_zero_max_fee: true,
+            account_path: Some(test_account_path()),
+            max_block_calldata: Some(max_block_calldata),
+            ..Default::default()
+        });
+        sequencer.start();
+        sequencer
+    };
+    // Every transfer has 6 calldata elements
+    let add_transfers = |sequencer: &mut KatanaSequencer, nonces: std::ops::Range<u64>| {
+        let a = sequencer.starknet.predeployed_accounts.accounts[0].account_address;
+        let b = sequencer.starknet.predeployed_accounts.accounts[1].account_address;
+        for nonce in nonces {
+            sequencer
+                .add_account_transaction(create_transfer_transaction(
+                    a,
+                    b,
+                    nonce,
+                    TransactionHash(stark_felt!(nonce + 1)),
+                ))
+                .unwrap();
+        }
+    };
+    let latest_hashes = |sequencer: &KatanaSequencer| {
+        sequencer
+            .block(BlockId::Tag(BlockTag::Latest))
+            .unwrap()
+            .transactions()
+            .iter()
+            .map(|tx| tx.transaction_hash())
+            .collect::<Vec<_>>()
+    };
+
+    // The third transfer would exceed the limit, and rolls over to the next block
+    let mut sequencer = create_sequencer(15);
+    add_transfers(&mut sequencer, 0..2);
+    assert_eq!(sequencer.starknet.pending_transaction_count(), 2);
+
+    add_transfers(&mut sequencer, 2..3);
+    assert_eq!(
+        latest_hashes(&sequencer),
+        vec![
+            TransactionHash(stark_felt!(1_u64)),
+            TransactionHash(stark_felt!(2_u64))
+        ]
+    );
+    assert_eq!(sequencer.starknet.pending_transaction_count(), 1);
+
+    // Reaching the limit exactly seals the block right away
+    let mut sequencer = create_sequencer(12);
+    add_transfers(&mut sequencer, 0..2);
+    assert_eq!(sequencer.starknet.pending_transaction_count(), 0);
+    assert_eq!(latest_hashes(&sequencer).len(), 2);
+
+    // A transaction larger than the limit still gets its own block
+    let mut sequencer = create_sequencer(4);
+    add_transfers(&mut sequencer, 0..1);
+    assert_eq!(sequencer.starknet.pending_transaction_count(), 0);
+    assert_eq!(
+        latest_hashes(&sequencer),
+        vec![TransactionHash(stark_felt!(1_u64))]
+    );
+}
+
+#[test]
+fn test_validate_transaction() {
+    // The default account checks the transaction signature in `__validate__`
+    let mut sequencer = KatanaSequencer::new(StarknetConfig {
+        total_accounts: 2,
+        blocks_on_demand: true,
+        allow_zero_max_fee: true,
+        ..Default::default()
+    });
+    sequencer.start();
+
+    let a = sequencer.starknet.predeployed_accounts.accounts[0].clone();
+    let b = sequencer.starknet.predeployed_accounts.accounts[1].clone();
+    let AccountTransaction::Invoke(InvokeTransaction::V1(mut transaction)) =
+        create_transfer_transaction(
+            a.account_address,
+            b.account_address,
+            0,
+            TransactionHash(stark_felt!(0x1234_u64)),
+        )
+    else {
+        unreachable!()
+    };
+
+    // The transfer itself is fine, but the missing signature fails the validation
+    let result = sequencer
+        .validate_transaction(transaction.clone(), BlockId::Tag(BlockTag::Pending))
+        .unwrap();
+    assert!(result.error.is_some());
+    assert_eq!(result.gas_usage, 0);
+
+    let signature = SigningKey::from_secret_scalar(FieldElement::from(a.private_key))
+        .sign(&FieldElement::from(transaction.transaction_hash.0))
+        .unwrap();
+    transaction.signature = TransactionSignature(vec![signature.r.into(), signature.s.into()]);
+
+    let result = sequencer
+        .validate_transaction(transaction, BlockId::Tag(BlockTag::Pending))
+        .unwrap();
+    assert_eq!(result.error, None);
+    assert!(result.gas_usage > 0);
+    assert!(result.events.is_empty());
+
+    // Validation alone doesn't execute nor charge the transaction
+    assert_eq!(sequencer.starknet.pending_transaction_count(), 0);
+    assert_eq!(
+        sequencer
+            .nonce_at(BlockId::Tag(BlockTag::Pending), a.account_address)
+            .unwrap(),
+        Nonce(stark_felt!(0_u64))
+    );
+}
+
+#[test]
+fn test_class_hash_by_compiled_class_hash() {
+    let mut sequencer = KatanaSequencer::new(StarknetConfig {
+        blocks_on_demand: true,
+        ..Default::default()
+    });
+    sequencer.start();
+
+    // Declared as a Sierra class would be
+    let class_hash = ClassHash(stark_felt!("0x1234"));
+    let compiled_class_hash = CompiledClassHash(stark_felt!("0x5678"));
+    sequencer
+        .starknet
+        .pending_state
+        .set_contract_class(&class_hash, test_contract_class())
+        .unwrap();
+    sequencer
+        .starknet
+        .pending_state
+        .set_compiled_class_hash(class_hash, compiled_class_hash)
+        .unwrap();
+
+    let pending = BlockId::Tag(BlockTag::Pending);
+    let latest = BlockId::Tag(BlockTag::Latest);
+    assert_eq!(
+        sequencer.class_hash_by_compiled_class_hash(pending, compiled_class_hash),
+        Some(class_hash)
+    );
+    assert_eq!(
+        sequencer.class_hash_by_compiled_class_hash(latest, compiled_class_hash),
+        None
+    );
+
+    sequencer.generate_new_block().unwrap();
+
+    let resolved = sequencer
+        .class_hash_by_compiled_class_hash(latest, compiled_class_hash)
+        .unwrap();
+    let mut state = sequencer.starknet.latest_state();
+    assert_eq!(
+        state.get_compiled_contract_class(&resolved).unwrap(),
+        state.get_compiled_contract_class(&class_hash).unwrap()
+    );
+    assert_eq!(
+        sequencer
+            .class_hash_by_compiled_class_hash(latest, CompiledClassHash(stark_felt!("0x9999"))),
+        None
+    );
+}
+
+#[test]
+fn test_fund_accounts() {
+    // Funding batches the transfers in a multicall, which the default account supports
+    let mut sequencer = KatanaSequencer::new(StarknetConfig {
+        total_accounts: 1,
+        blocks_on_demand: true,
+        ..Default::default()
+    });
+    sequencer.start();
+
+    let transfers = (1..=10_u64)
+        .map(|i| {
+            (
+                ContractAddress(patricia_key!(stark_felt!(0x1000 + i))),
+                u128::from(i) * 1000,
+            )
+        })
+        .collect::<Vec<_>>();
+
+    let outcome = sequencer.fund_accounts(&transfers).unwrap();
+    assert_eq!(outcome.transaction_hashes.len(), 1);
+    assert!(outcome.unfunded.is_empty());
+    assert_eq!(
+        outcome.funded,
+        transfers
+            .iter()
+            .map(|(address, _)| *address)
+            .collect::<Vec<_>>()
+    );
+
+    for (address, amount) in &transfers {
+        let balance = sequencer
+            .storage_at(
+                ContractAddress(patricia_key!(*FEE_TOKEN_ADDRESS)),
+                get_storage_var_address("ERC20_balances", &[*address.0.key()]).unwrap(),
+                BlockId::Tag(BlockTag::Pending),
+            )
+            .unwrap();
+        assert_eq!(balance, stark_felt!(*amount));
+    }
+}
+
+#[test]
+fn test_max_historical_query_depth() {
+    let mut sequencer = KatanaSequencer::new(StarknetConfig {
+        total_accounts: 1,
+        blocks_on_demand: true,
+        max_historical_query_depth: Some(2),
+        ..Default::default()
+    });
+    sequencer.start();
+
+    for _ in 0..4 {
+        sequencer.generate_new_block().unwrap();
+    }
+
+    let latest = sequencer.block_number().0;
+    let storage_at = |sequencer: &mut KatanaSequencer, block_number| {
+        sequencer.storage_at(
+            ContractAddress(patricia_key!(*FEE_TOKEN_ADDRESS)),
+            get_storage_var_address("ERC20_balances", &[stark_felt!("0x100")]).unwrap(),
+            BlockId::Number(block_number),
+        )
+    };
+
+    assert!(storage_at(&mut sequencer, latest).is_ok());
+    assert!(storage_at(&mut sequencer, latest - 2).is_ok());
+
+    let err = storage_at(&mut sequencer, latest - 3).unwrap_err();
+    assert!(err.is::<QueryTooDeep>());
+
+    // Every historical state query is limited, not only storage reads
+    let account = sequencer.starknet.predeployed_accounts.accounts[0].account_address;
+    let balance_of = || ExternalFunctionCall {
+        contract_address: ContractAddress(patricia_key!(*FEE_TOKEN_ADDRESS)),
+        entry_point_selector: selector_from_name("balanceOf"),
+        calldata: calldata![*account.0.key()],
+    };
+    assert!(sequencer
+        .call(BlockId::Number(latest - 2), balance_of())
+        .is_ok());
+    assert!(sequencer
+        .call(BlockId::Number(latest - 3), balance_of())
+        .unwrap_err()
+        .is::<QueryTooDeep>());
+
+    assert!(sequencer
+        .storage_across_blocks(
+            ContractAddress(patricia_key!(*FEE_TOKEN_ADDRESS)),
+            get_storage_var_address("ERC20_balances", &[stark_felt!("0x100")]).unwrap(),
+            BlockNumber(latest - 3),
+            BlockNumber(latest),
+        )
+        .unwrap_err()
+        .is::<QueryTooDeep>());
+}
+
+#[test]
+fn test_fee_recipient() {
+    let recipient = ContractAddress(patricia_key!("0x1234"));
+    let mut sequencer = KatanaSequencer::new(StarknetConfig {
+        total_accounts: 1,
+        blocks_on_demand: true,
+        fee_recipient: Some(recipient),
+        ..Default::default()
+    });
+    sequencer.start();
+
+    let recipient_balance = |sequencer: &mut KatanaSequencer| {
+        let balance = sequencer
+            .storage_at(
+                ContractAddress(patricia_key!(*FEE_TOKEN_ADDRESS)),
+                get_storage_var_address("ERC20_balances", &[*recipient.0.key()]).unwrap(),
+                BlockId::Tag(BlockTag::Pending),
+            )
+            .unwrap();
+        starkfelt_to_u128(balance).unwrap()
+    };
+    assert_eq!(recipient_balance(&mut sequencer), 0);
+
+    // Funding an account is a fee-paying transfer from the first predeployed account
+    let outcome = sequencer
+        .fund_accounts(&[(ContractAddress(patricia_key!("0x100")), 1000)])
+        .unwrap();
+    let fee = sequencer.starknet.transactions.transactions[&outcome.transaction_hashes[0]]
+        .actual_fee()
+        .0;
+    assert!(fee > 0);
+    assert_eq!(recipient_balance(&mut sequencer), fee);
+
+    sequencer.generate_new_block().unwrap();
+    let block = sequencer.block(BlockId::Tag(BlockTag::Latest)).unwrap();
+    assert_eq!(block.header().sequencer, recipient);
+}
+
+#[test]
+fn test_dedup_cache_size() {
+    let mut sequencer = KatanaSequencer::new(StarknetConfig {
+        total_accounts: 2,
+        blocks_on_demand: true,
+        allow_zero_max_fee: true,
+        account_path: Some(test_account_path()),
+        reject_insufficient_balance: true,
+        dedup_cache_size: 2,
+        ..Default::default()
+    });
+    sequencer.start();
+
+    let a = sequencer.starknet.predeployed_accounts.accounts[0].clone();
+    let b = sequencer.starknet.predeployed_accounts.accounts[1].clone();
+    let transfer = |nonce: u64| {
+        create_transfer_transaction(
+            a.account_address,
+            b.account_address,
+            nonce,
+            TransactionHash(stark_felt!(nonce + 1)),
+        )
+    };
+
+    for nonce in 0..3 {
+        sequencer.add_account_transaction(transfer(nonce)).unwrap();
+    }
+
+    let is_duplicate = |result: anyhow::Result<()>| {
+        result.map_or_else(|err| err.is::<DuplicateTransaction>(), |_| false)
+    };
+
+    // The last two hashes are still within the window
+    assert!(is_duplicate(sequencer.add_account_transaction(transfer(2))));
+    assert!(is_duplicate(sequencer.add_account_transaction(transfer(1))));
+
+    // The oldest one was evicted, and is admitted again. Its nonce is stale, so it is
+    // rejected, and its hash isn't recorded.
+    assert!(!is_duplicate(
+        sequencer.add_account_transaction(transfer(0))
+    ));
+    assert!(!is_duplicate(
+        sequencer.add_account_transaction(transfer(0))
+    ));
+    assert!(is_duplicate(sequencer.add_account_transaction(transfer(2))));
+
+    // A transaction rejected before execution isn't recorded either
+    let mut overpriced = transfer(3);
+    if let AccountTransaction::Invoke(InvokeTransaction::V1(tx)) = &mut overpriced {
+        tx.max_fee = Fee(u128::MAX);
+    }
+    assert!(sequencer.add_account_transaction(overpriced).is_err());
+    sequencer.add_account_transaction(transfer(3)).unwrap();
+}
+
 // #[test]
 // fn test_function_call() {
 //     let starknet = create_test_starknet();
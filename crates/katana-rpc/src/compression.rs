@@ -0,0 +1,126 @@
+use std::{
+    error::Error as StdError,
+    future::Future,
+    io::Write,
+    pin::Pin,
+    task::{Context, Poll},
+};
+
+use flate2::{write::GzEncoder, Compression};
+use hyper::{
+    header::{HeaderValue, ACCEPT_ENCODING, CONTENT_ENCODING, CONTENT_LENGTH, VARY},
+    Body, Request, Response, StatusCode,
+};
+use tower::{Layer, Service};
+
+type BoxError = Box<dyn StdError + Send + Sync>;
+
+/// Gzip-compresses the HTTP responses of at least `threshold` bytes for the clients accepting
+/// it through `Accept-Encoding`. Smaller responses are sent as is, as compressing them costs
+/// more than it saves. Compression is disabled when there is no threshold.
+#[derive(Debug, Clone, Copy)]
+pub struct CompressionLayer {
+    threshold: Option<usize>,
+}
+
+impl CompressionLayer {
+    pub fn new(threshold: Option<usize>) -> Self {
+        Self { threshold }
+    }
+}
+
+impl<S> Layer<S> for CompressionLayer {
+    type Service = CompressionService<S>;
+
+    fn layer(&self, inner: S) -> Self::Service {
+        CompressionService {
+            inner,
+            threshold: self.threshold,
+        }
+    }
+}
+
+#[derive(Debug, Clone)]
+pub struct CompressionService<S> {
+    inner: S,
+    threshold: Option<usize>,
+}
+
+impl<S> Service<Request<Body>> for CompressionService<S>
+where
+    S: Service<Request<Body>, Response = Response<Body>>,
+    S::Error: Into<BoxError>,
+    S::Future: Send + 'static,
+{
+    type Response = Response<Body>;
+    type Error = BoxError;
+    type Future = Pin<Box<dyn Future<Output = Result<Self::Response, Self::Error>> + Send>>;
+
+    fn poll_ready(&mut self, cx: &mut Context<'_>) -> Poll<Result<(), Self::Error>> {
+        self.inner.poll_ready(cx).map_err(Into::into)
+    }
+
+    fn call(&mut self, request: Request<Body>) -> Self::Future {
+        let threshold = self.threshold.filter(|_| accepts_gzip(&request));
+        let response = self.inner.call(request);
+
+        Box::pin(async move {
+            let response = response.await.map_err(Into::into)?;
+            match threshold {
+                // Other statuses include the websocket upgrades, whose body must not be touched
+                Some(threshold) if response.status() == StatusCode::OK => {
+                    compress(response, threshold).await
+                }
+                _ => Ok(response),
+            }
+        })
+    }
+}
+
+// Whether the request accepts gzip encoded responses, explicitly or through a wildcard,
+// without disabling it with a zero quality value
+fn accepts_gzip(request: &Request<Body>) -> bool {
+    request
+        .headers()
+        .get_all(ACCEPT_ENCODING)
+        .iter()
+        .filter_map(|value| value.to_str().ok())
+        .flat_map(|value| value.split(','))
+        .any(|coding| {
+            let mut params = coding.split(';').map(str::trim);
+            let name = params.next().unwrap_or_default();
+            let disabled = params.any(|param| {
+                param
+                    .strip_prefix("q=")
+                    .and_then(|q| q.parse::<f32>().ok())
+                    .map_or(false, |q| q == 0.0)
+            });
+
+            (name.eq_ignore_ascii_case("gzip") || name == "*") && !disabled
+        })
+}
+
+async fn compress(response: Response<Body>, threshold: usize) -> Result<Response<Body>, BoxError> {
+    let (mut parts, body) = response.into_parts();
+    let body = hyper::body::to_bytes(body).await?;
+
+    if body.len() < threshold || parts.headers.contains_key(CONTENT_ENCODING) {
+        return Ok(Response::from_parts(parts, Body::from(body)));
+    }
+
+    let mut encoder = GzEncoder::new(Vec::new(), Compression::default());
+    encoder.write_all(&body)?;
+    let compressed = encoder.finish()?;
+
+    parts
+        .headers
+        .insert(CONTENT_ENCODING, HeaderValue::from_static("gzip"));
+    parts
+        .headers
+        .insert(CONTENT_LENGTH, HeaderValue::from(compressed.len()));
+    parts
+        .headers
+        .append(VARY, HeaderValue::from_static("accept-encoding"));
+
+    Ok(Response::from_parts(parts, Body::from(compressed)))
+}
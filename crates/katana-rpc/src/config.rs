@@ -1,4 +1,18 @@
+use std::time::Duration;
+
+use crate::error_codes::ErrorCodes;
+
 #[derive(Debug, Clone)]
 pub struct RpcConfig {
     pub port: u16,
+    /// Whether to log the names of the unknown methods called, for auditing.
+    pub log_unknown_methods: bool,
+    /// The minimum size in bytes of the responses to gzip for the clients accepting it.
+    /// Responses are never compressed when unset.
+    pub compression_threshold: Option<usize>,
+    /// Custom codes and messages returned instead of the default ones of some errors.
+    pub error_codes: ErrorCodes,
+    /// How long the add-transaction methods wait for the submitted transaction to be mined
+    /// before returning. They return as soon as the transaction is admitted when unset.
+    pub instant_confirm_timeout: Option<Duration>,
 }
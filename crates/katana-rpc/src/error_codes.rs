@@ -0,0 +1,49 @@
+use std::{collections::HashMap, fmt, fs, path::Path};
+
+use anyhow::Result;
+use jsonrpsee::{
+    core::Error,
+    types::{error::CallError, ErrorObject},
+};
+use serde::Deserialize;
+
+/// The code and message returned instead of the default ones of an error.
+#[derive(Debug, Clone, Deserialize)]
+pub struct CustomError {
+    pub code: i32,
+    /// The default message is kept when unset.
+    #[serde(default)]
+    pub message: Option<String>,
+}
+
+/// Custom errors keyed by the name of the error they replace, such as `BlockNotFound` or
+/// `InsufficientAccountBalance`.
+pub type ErrorCodes = HashMap<String, CustomError>;
+
+/// Loads the custom errors from a JSON object mapping error names to their custom error.
+pub fn load_error_codes(path: &Path) -> Result<ErrorCodes> {
+    let codes = fs::read_to_string(path)?;
+    Ok(serde_json::from_str(&codes)?)
+}
+
+/// An error returned by the RPC methods, named after its variant in the custom errors.
+pub trait ApiError: fmt::Debug + fmt::Display + Copy {
+    /// The spec-compliant code of the error.
+    fn code(self) -> i32;
+}
+
+/// Builds the RPC error, using its custom code and message if one is configured. The errors
+/// without a custom one keep their spec-compliant code and message.
+pub fn rpc_error(codes: &ErrorCodes, err: impl ApiError) -> Error {
+    let (code, message) = match codes.get(&format!("{err:?}")) {
+        Some(custom) => (
+            custom.code,
+            custom.message.clone().unwrap_or_else(|| err.to_string()),
+        ),
+        None => (err.code(), err.to_string()),
+    };
+
+    Error::Call(CallError::Custom(ErrorObject::owned(
+        code, message, None::<()>,
+    )))
+}
@@ -0,0 +1,38 @@
+use blockifier::abi::abi_utils::selector_from_name;
+use serde::{Deserialize, Serialize};
+use starknet::{core::types::FieldElement, providers::jsonrpc::models::BlockId};
+use starknet_api::hash::StarkFelt;
+
+/// A key of an event filter, either a raw felt as in the spec, or the name of the event, which
+/// is hashed to its selector.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(untagged)]
+pub enum EventKey {
+    Felt(FieldElement),
+    Name(String),
+}
+
+impl EventKey {
+    pub fn resolve(&self) -> StarkFelt {
+        match self {
+            EventKey::Felt(felt) => StarkFelt::from(*felt),
+            EventKey::Name(name) => selector_from_name(name).0,
+        }
+    }
+}
+
+/// An event filter whose keys can be given by event name, such as `Transfer`.
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct NamedEventFilter {
+    pub from_block: Option<BlockId>,
+    pub to_block: Option<BlockId>,
+    pub address: Option<FieldElement>,
+    pub keys: Option<Vec<Vec<EventKey>>>,
+}
+
+/// Resolves the keys of each position of a filter to the felts events are matched against.
+pub fn resolve_event_keys(keys: &[Vec<EventKey>]) -> Vec<Vec<StarkFelt>> {
+    keys.iter()
+        .map(|keys| keys.iter().map(EventKey::resolve).collect())
+        .collect()
+}
@@ -0,0 +1,36 @@
+use std::collections::BTreeMap;
+
+use katana_core::starknet::ExecutorConfig;
+
+use crate::config::RpcConfig;
+
+/// Features some Katana builds provide, which this one doesn't. They are always reported as
+/// disabled so that clients can gate on them regardless of the build they talk to.
+const UNAVAILABLE_FEATURES: [&str; 5] = ["explorer", "fork", "messaging", "metrics", "vrf"];
+
+/// Returns whether each feature is enabled on this node, from how it was built and started.
+pub fn node_features(rpc: &RpcConfig, executor: &ExecutorConfig) -> BTreeMap<String, bool> {
+    let mut features = UNAVAILABLE_FEATURES
+        .iter()
+        .map(|feature| (feature.to_string(), false))
+        .collect::<BTreeMap<_, _>>();
+
+    features.extend([
+        ("call_cache".to_string(), executor.call_cache),
+        (
+            "custom_error_codes".to_string(),
+            !rpc.error_codes.is_empty(),
+        ),
+        (
+            "fee_estimate_cache".to_string(),
+            executor.fee_estimate_cache_ttl_ms.is_some(),
+        ),
+        (
+            "response_compression".to_string(),
+            rpc.compression_threshold.is_some(),
+        ),
+        ("unknown_method_log".to_string(), rpc.log_unknown_methods),
+    ]);
+
+    features
+}
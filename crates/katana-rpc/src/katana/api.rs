@@ -1,24 +1,275 @@
-use jsonrpsee::{
-    core::Error,
-    proc_macros::rpc,
-    types::{error::CallError, ErrorObject},
+use std::collections::{BTreeMap, HashMap};
+
+use jsonrpsee::{core::Error, proc_macros::rpc};
+use katana_core::starknet::{ExecutorConfig, PoolPosition};
+use serde::{Deserialize, Serialize};
+use starknet::{
+    core::types::FieldElement,
+    providers::jsonrpc::models::{
+        BlockHashAndNumber, BlockId, BroadcastedInvokeTransactionV1, DeployedContractItem,
+        EventsPage, MaybePendingTransactionReceipt, StateDiff, Transaction,
+    },
 };
+use starknet_api::transaction::Event;
+
+use crate::{error_codes::ApiError, event_filter::NamedEventFilter};
 
 #[derive(thiserror::Error, Clone, Copy, Debug)]
-pub enum KatanaApiError {}
-
-impl From<KatanaApiError> for Error {
-    fn from(err: KatanaApiError) -> Self {
-        Error::Call(CallError::Custom(ErrorObject::owned(
-            err as i32,
-            err.to_string(),
-            None::<()>,
-        )))
+pub enum KatanaApiError {
+    #[error("Requested block range is too large")]
+    BlockRangeTooLarge = 100,
+    #[error("Requested block range starts after its end")]
+    InvalidBlockRange = 101,
+}
+
+impl ApiError for KatanaApiError {
+    fn code(self) -> i32 {
+        self as i32
     }
 }
 
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct MempoolNonce {
+    /// The nonce of the account in the latest committed state.
+    pub state_nonce: FieldElement,
+    /// The nonce to use for the account's next transaction, accounting for its
+    /// transactions in the pending block.
+    pub pending_nonce: FieldElement,
+}
+
+/// The contents of the block being built, before it is mined.
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct PendingBlock {
+    pub timestamp: u64,
+    pub transactions: Vec<Transaction>,
+    /// The receipts of the executed transactions, in the same order as `transactions`.
+    pub receipts: Vec<MaybePendingTransactionReceipt>,
+    /// The state changes of all the transactions in the block so far.
+    pub state_diff: StateDiff,
+}
+
+/// A page of transaction hashes.
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct TransactionsPage {
+    pub transaction_hashes: Vec<FieldElement>,
+    /// The offset of the next page, if there are more results.
+    pub next_offset: Option<usize>,
+}
+
+/// The transaction that has been waiting the longest to be mined.
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct OldestPendingTransaction {
+    pub transaction_hash: FieldElement,
+    /// The seconds elapsed since it was received, on the clock of the block timestamps.
+    pub age_seconds: u64,
+    /// The number of blocks mined since it was received.
+    pub age_blocks: u64,
+}
+
+/// An amount of fee tokens to send to an account.
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct AccountFunding {
+    pub address: FieldElement,
+    pub amount: FieldElement,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct FundedAccounts {
+    /// The hashes of the funding transactions, including the rejected ones.
+    pub transaction_hashes: Vec<FieldElement>,
+    pub funded: Vec<FieldElement>,
+    /// The accounts whose funding transaction was rejected.
+    pub unfunded: Vec<FieldElement>,
+}
+
+/// The outcome of running the validation of a transaction on its own.
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct AccountValidation {
+    pub is_valid: bool,
+    /// Why the validation failed, if it did.
+    pub error: Option<String>,
+    /// The L1 gas equivalent of the resources used by the validation.
+    pub gas_usage: u64,
+    /// The events emitted during the validation.
+    pub events: Vec<Event>,
+}
+
 #[rpc(server, client, namespace = "katana")]
 pub trait KatanaApi {
     #[method(name = "generateBlock")]
     async fn generate_block(&self) -> Result<(), Error>;
+
+    #[method(name = "getExecutionResources")]
+    async fn execution_resources(&self, block_id: BlockId)
+        -> Result<HashMap<String, usize>, Error>;
+
+    #[method(name = "getMempoolNonce")]
+    async fn mempool_nonce(&self, contract_address: FieldElement) -> Result<MempoolNonce, Error>;
+
+    /// Returns the latest block whose timestamp is at or before the given Unix timestamp.
+    #[method(name = "getBlockByTimestamp")]
+    async fn block_by_timestamp(&self, timestamp: u64) -> Result<BlockHashAndNumber, Error>;
+
+    /// Returns the state changes caused by a single transaction, excluding the changes of
+    /// the other transactions in its block.
+    #[method(name = "getTransactionStateDiff")]
+    async fn transaction_state_diff(
+        &self,
+        transaction_hash: FieldElement,
+    ) -> Result<StateDiff, Error>;
+
+    /// Returns the gas price applied to the transaction: the gas price of its block once
+    /// mined, or the gas price of the block being built while pending, clamped to the highest
+    /// price its max fee covers for the gas it used.
+    #[method(name = "getEffectiveGasPrice")]
+    async fn effective_gas_price(
+        &self,
+        transaction_hash: FieldElement,
+    ) -> Result<FieldElement, Error>;
+
+    #[method(name = "getPendingBlock")]
+    async fn pending_block(&self) -> Result<PendingBlock, Error>;
+
+    /// Returns the hashes of the mined transactions sent by the account, newest first.
+    #[method(name = "getTransactionsBySender")]
+    async fn transactions_by_sender(
+        &self,
+        sender_address: FieldElement,
+        from_block: Option<BlockId>,
+        to_block: Option<BlockId>,
+        offset: Option<usize>,
+        limit: Option<usize>,
+    ) -> Result<TransactionsPage, Error>;
+
+    /// Returns the contracts deployed in the block, along with their class hash.
+    #[method(name = "getDeployedContracts")]
+    async fn deployed_contracts(
+        &self,
+        block_id: BlockId,
+    ) -> Result<Vec<DeployedContractItem>, Error>;
+
+    /// Returns the value of the storage slot at the end of each block of the inclusive range.
+    /// Ranges starting after their end are refused.
+    #[method(name = "getStorageAcrossBlocks")]
+    async fn storage_across_blocks(
+        &self,
+        contract_address: FieldElement,
+        key: FieldElement,
+        from_block: BlockId,
+        to_block: BlockId,
+    ) -> Result<Vec<FieldElement>, Error>;
+
+    /// Sets the state root of the next mined block, instead of the computed one, to test how
+    /// clients handle invalid roots. The following blocks use the computed roots again.
+    #[method(name = "overrideStateRoot")]
+    async fn override_state_root(&self, state_root: FieldElement) -> Result<(), Error>;
+
+    /// Reverts the chain to the given block, dropping the later blocks and the transactions not
+    /// yet mined, then mines the replacement transactions in a new block on top of it. Returns
+    /// the new tip of the chain.
+    #[method(name = "reorgTo")]
+    async fn reorg_to(
+        &self,
+        block_number: u64,
+        transactions: Vec<BroadcastedInvokeTransactionV1>,
+    ) -> Result<BlockHashAndNumber, Error>;
+
+    /// Same as `starknet_blockNumber`.
+    #[method(name = "getBlockNumber")]
+    async fn block_number(&self) -> Result<u64, Error>;
+
+    /// Mines empty blocks until the latest block is `block_number`, the first one including
+    /// the pending transactions. Decreasing the block number, or increasing it by more than
+    /// 1000 blocks at once, is rejected. Returns the new latest block.
+    #[method(name = "setBlockNumber")]
+    async fn set_block_number(&self, block_number: u64) -> Result<BlockHashAndNumber, Error>;
+
+    /// Advances the time by `seconds` and mines `blocks` blocks, the first one with the pending
+    /// transactions. With `distribute`, the time is spread evenly across the mined blocks,
+    /// otherwise it is all added before the first one. Returns the mined blocks.
+    #[method(name = "advanceTimeAndMine")]
+    async fn advance_time_and_mine(
+        &self,
+        seconds: u64,
+        blocks: u64,
+        distribute: bool,
+    ) -> Result<Vec<BlockHashAndNumber>, Error>;
+
+    /// Runs only the `__validate__` entry point of the sender for the transaction, without
+    /// executing it nor charging any fee, to debug signatures and validation logic.
+    #[method(name = "validateAccountTransaction")]
+    async fn validate_account_transaction(
+        &self,
+        transaction: BroadcastedInvokeTransactionV1,
+        block_id: BlockId,
+    ) -> Result<AccountValidation, Error>;
+
+    /// Returns the settings transactions are executed with, such as the current gas price
+    /// and the execution limits.
+    #[method(name = "getExecutorConfig")]
+    async fn executor_config(&self) -> Result<ExecutorConfig, Error>;
+
+    /// Returns where a transaction that is not yet mined stands in the mining order, and how
+    /// many blocks are expected to be mined until it is included.
+    #[method(name = "getPoolPosition")]
+    async fn pool_position(&self, transaction_hash: FieldElement) -> Result<PoolPosition, Error>;
+
+    /// Returns the transaction that has been waiting the longest to be mined, or null when
+    /// there is none, to diagnose transactions that aren't getting mined.
+    #[method(name = "getOldestPendingTx")]
+    async fn oldest_pending_transaction(&self) -> Result<Option<OldestPendingTransaction>, Error>;
+
+    /// Sends fee tokens from the first predeployed account to each account, batching the
+    /// transfers in as few transactions as possible, and reports which accounts were funded.
+    #[method(name = "fundAccounts")]
+    async fn fund_accounts(&self, accounts: Vec<AccountFunding>) -> Result<FundedAccounts, Error>;
+
+    /// Removes all the transactions that are not yet mined and returns how many were removed.
+    #[method(name = "clearPool")]
+    async fn clear_pool(&self) -> Result<usize, Error>;
+
+    /// Returns the hash of the class whose compiled (CASM) class hash is given, for tooling
+    /// referencing classes by their compiled class hash.
+    #[method(name = "getClassHashByCompiledHash")]
+    async fn class_hash_by_compiled_class_hash(
+        &self,
+        block_id: BlockId,
+        compiled_class_hash: FieldElement,
+    ) -> Result<FieldElement, Error>;
+
+    /// Returns whether each optional feature, such as the explorer or the call cache, is
+    /// enabled on this node.
+    #[method(name = "getFeatures")]
+    async fn features(&self) -> Result<BTreeMap<String, bool>, Error>;
+
+    /// Returns the events matching the filter, like `starknet_getEvents`, except that its keys
+    /// may also be event names, such as `Transfer`, which are hashed to their selector.
+    #[method(name = "getEvents")]
+    async fn events(
+        &self,
+        filter: NamedEventFilter,
+        continuation_token: Option<String>,
+        chunk_size: u64,
+    ) -> Result<EventsPage, Error>;
+
+    /// Returns the hash the StarknetMessaging contract on L1 identifies an L1 -> L2 message
+    /// with, as a hex string, to correlate the L1 and L2 sides of the message.
+    #[method(name = "computeMessageHash")]
+    async fn compute_message_hash(
+        &self,
+        from_address: FieldElement,
+        to_address: FieldElement,
+        selector: FieldElement,
+        payload: Vec<FieldElement>,
+        nonce: FieldElement,
+    ) -> Result<String, Error>;
+
+    /// Returns whether the contract declares support for the interface through SRC5
+    /// introspection. Contracts not implementing SRC5 are reported as unsupported.
+    #[method(name = "supportsInterface")]
+    async fn supports_interface(
+        &self,
+        contract_address: FieldElement,
+        interface_id: FieldElement,
+    ) -> Result<bool, Error>;
 }
@@ -1,20 +1,73 @@
-use std::sync::Arc;
+use std::{
+    collections::{BTreeMap, HashMap},
+    sync::Arc,
+};
 
-use jsonrpsee::core::{async_trait, Error};
-use katana_core::sequencer::Sequencer;
+use blockifier::transaction::account_transaction::AccountTransaction;
+use jsonrpsee::{
+    core::{async_trait, Error},
+    types::error::CallError,
+};
+use katana_core::{
+    sequencer::Sequencer,
+    starknet::{messaging::compute_l1_to_l2_message_hash, ExecutorConfig, PoolPosition},
+    util::starkfelt_to_u128,
+};
+use starknet::{
+    core::types::FieldElement,
+    providers::jsonrpc::models::{
+        BlockHashAndNumber, BlockId, BlockTag, BroadcastedInvokeTransactionV1,
+        DeployedContractItem, EventsPage, MaybePendingTransactionReceipt, StateDiff,
+    },
+};
+use starknet_api::{
+    block::{BlockNumber, BlockTimestamp},
+    core::{CompiledClassHash, ContractAddress, EntryPointSelector, Nonce, PatriciaKey},
+    hash::{StarkFelt, StarkHash},
+    patricia_key,
+    state::StorageKey,
+    transaction::{InvokeTransaction, TransactionHash},
+};
 use tokio::sync::RwLock;
 
-use self::api::KatanaApiServer;
+use self::api::{
+    AccountFunding, AccountValidation, FundedAccounts, KatanaApiError, KatanaApiServer,
+    MempoolNonce, OldestPendingTransaction, PendingBlock, TransactionsPage,
+};
+use crate::{
+    config::RpcConfig,
+    error_codes::{rpc_error, ApiError},
+    event_filter::{resolve_event_keys, NamedEventFilter},
+    features::node_features,
+    starknet::{api::StarknetApiError, state_query_error},
+    utils::{
+        event::to_rpc_emitted_event,
+        transaction::{
+            broadcasted_invoke_v1_to_inner, convert_inner_to_rpc_pending_receipt,
+            convert_inner_to_rpc_tx,
+        },
+    },
+};
 
 pub mod api;
 
+const DEFAULT_TRANSACTIONS_PAGE_SIZE: usize = 100;
+const MAX_TRANSACTIONS_PAGE_SIZE: usize = 1000;
+const MAX_STORAGE_SERIES_BLOCKS: u64 = 1000;
+
 pub struct KatanaRpc<S> {
     sequencer: Arc<RwLock<S>>,
+    config: RpcConfig,
 }
 
 impl<S: Sequencer + Send + Sync + 'static> KatanaRpc<S> {
-    pub fn new(sequencer: Arc<RwLock<S>>) -> Self {
-        Self { sequencer }
+    pub fn new(sequencer: Arc<RwLock<S>>, config: RpcConfig) -> Self {
+        Self { sequencer, config }
+    }
+
+    // Builds the error with the custom code configured for it, if any
+    fn error(&self, err: impl ApiError) -> Error {
+        rpc_error(&self.config.error_codes, err)
     }
 }
 
@@ -24,4 +77,435 @@ impl<S: Sequencer + Send + Sync + 'static> KatanaApiServer for KatanaRpc<S> {
         self.sequencer.write().await.generate_new_block()?;
         Ok(())
     }
+
+    async fn execution_resources(
+        &self,
+        block_id: BlockId,
+    ) -> Result<HashMap<String, usize>, Error> {
+        self.sequencer
+            .read()
+            .await
+            .execution_resources(block_id)
+            .ok_or(self.error(StarknetApiError::BlockNotFound))
+    }
+
+    async fn mempool_nonce(&self, contract_address: FieldElement) -> Result<MempoolNonce, Error> {
+        let contract_address = ContractAddress(patricia_key!(contract_address));
+        let mut sequencer = self.sequencer.write().await;
+
+        let state_nonce = sequencer
+            .nonce_at(BlockId::Tag(BlockTag::Latest), contract_address)
+            .map_err(|_| self.error(StarknetApiError::ContractError))?;
+        let pending_nonce = sequencer
+            .pending_nonce_at(contract_address)
+            .map_err(|_| self.error(StarknetApiError::ContractError))?;
+
+        Ok(MempoolNonce {
+            state_nonce: state_nonce.0.into(),
+            pending_nonce: pending_nonce.0.into(),
+        })
+    }
+
+    async fn block_by_timestamp(&self, timestamp: u64) -> Result<BlockHashAndNumber, Error> {
+        let (hash, number) = self
+            .sequencer
+            .read()
+            .await
+            .block_hash_and_number_by_timestamp(BlockTimestamp(timestamp))
+            .ok_or(self.error(StarknetApiError::BlockNotFound))?;
+
+        Ok(BlockHashAndNumber {
+            block_number: number.0,
+            block_hash: hash.0.into(),
+        })
+    }
+
+    async fn transaction_state_diff(
+        &self,
+        transaction_hash: FieldElement,
+    ) -> Result<StateDiff, Error> {
+        self.sequencer
+            .read()
+            .await
+            .transaction_state_diff(&TransactionHash(StarkFelt::from(transaction_hash)))
+            .ok_or(self.error(StarknetApiError::TxnHashNotFound))
+    }
+
+    async fn effective_gas_price(
+        &self,
+        transaction_hash: FieldElement,
+    ) -> Result<FieldElement, Error> {
+        self.sequencer
+            .read()
+            .await
+            .effective_gas_price(&TransactionHash(StarkFelt::from(transaction_hash)))
+            .map(|gas_price| FieldElement::from(StarkFelt::from(gas_price)))
+            .ok_or(self.error(StarknetApiError::TxnHashNotFound))
+    }
+
+    async fn pending_block(&self) -> Result<PendingBlock, Error> {
+        let sequencer = self.sequencer.read().await;
+        let block = sequencer
+            .block(BlockId::Tag(BlockTag::Pending))
+            .ok_or(self.error(StarknetApiError::BlockNotFound))?;
+
+        let mut transactions = Vec::with_capacity(block.transactions().len());
+        let mut receipts = Vec::with_capacity(block.transactions().len());
+        for tx in block.transactions() {
+            let receipt = sequencer
+                .transaction_receipt(&tx.transaction_hash())
+                .ok_or(self.error(StarknetApiError::TxnHashNotFound))?;
+            receipts.push(MaybePendingTransactionReceipt::PendingReceipt(
+                convert_inner_to_rpc_pending_receipt(receipt)
+                    .map_err(|_| self.error(StarknetApiError::InternalServerError))?,
+            ));
+            transactions.push(
+                convert_inner_to_rpc_tx(tx.clone())
+                    .map_err(|_| self.error(StarknetApiError::InternalServerError))?,
+            );
+        }
+
+        Ok(PendingBlock {
+            timestamp: block.header().timestamp.0,
+            transactions,
+            receipts,
+            state_diff: sequencer.pending_state_diff(),
+        })
+    }
+
+    async fn transactions_by_sender(
+        &self,
+        sender_address: FieldElement,
+        from_block: Option<BlockId>,
+        to_block: Option<BlockId>,
+        offset: Option<usize>,
+        limit: Option<usize>,
+    ) -> Result<TransactionsPage, Error> {
+        let limit = limit.unwrap_or(DEFAULT_TRANSACTIONS_PAGE_SIZE);
+        if limit > MAX_TRANSACTIONS_PAGE_SIZE {
+            return Err(self.error(StarknetApiError::PageSizeTooBig));
+        }
+
+        let hashes = self
+            .sequencer
+            .read()
+            .await
+            .transactions_by_sender(
+                ContractAddress(patricia_key!(sender_address)),
+                from_block.unwrap_or(BlockId::Number(0)),
+                to_block.unwrap_or(BlockId::Tag(BlockTag::Latest)),
+            )
+            .map_err(|_| self.error(StarknetApiError::BlockNotFound))?;
+
+        let offset = offset.unwrap_or(0);
+        let end = offset.saturating_add(limit);
+
+        Ok(TransactionsPage {
+            transaction_hashes: hashes
+                .iter()
+                .skip(offset)
+                .take(limit)
+                .map(|hash| hash.0.into())
+                .collect(),
+            next_offset: (end < hashes.len()).then_some(end),
+        })
+    }
+
+    async fn deployed_contracts(
+        &self,
+        block_id: BlockId,
+    ) -> Result<Vec<DeployedContractItem>, Error> {
+        self.sequencer
+            .read()
+            .await
+            .deployed_contracts(block_id)
+            .ok_or(self.error(StarknetApiError::BlockNotFound))
+    }
+
+    async fn storage_across_blocks(
+        &self,
+        contract_address: FieldElement,
+        key: FieldElement,
+        from_block: BlockId,
+        to_block: BlockId,
+    ) -> Result<Vec<FieldElement>, Error> {
+        let sequencer = self.sequencer.read().await;
+
+        let block_number = |block_id| {
+            sequencer
+                .block(block_id)
+                .map(|block| block.block_number())
+                .ok_or(self.error(StarknetApiError::BlockNotFound))
+        };
+        let from_block = block_number(from_block)?;
+        let to_block = block_number(to_block)?;
+
+        if from_block > to_block {
+            return Err(self.error(KatanaApiError::InvalidBlockRange));
+        }
+        if to_block.0 - from_block.0 >= MAX_STORAGE_SERIES_BLOCKS {
+            return Err(self.error(KatanaApiError::BlockRangeTooLarge));
+        }
+
+        let values = sequencer
+            .storage_across_blocks(
+                ContractAddress(patricia_key!(contract_address)),
+                StorageKey(patricia_key!(key)),
+                from_block,
+                to_block,
+            )
+            .map_err(|e| {
+                state_query_error(&self.config.error_codes, e, StarknetApiError::BlockNotFound)
+            })?;
+
+        Ok(values.into_iter().map(FieldElement::from).collect())
+    }
+
+    async fn override_state_root(&self, state_root: FieldElement) -> Result<(), Error> {
+        self.sequencer
+            .write()
+            .await
+            .override_state_root(StarkFelt::from(state_root));
+        Ok(())
+    }
+
+    async fn reorg_to(
+        &self,
+        block_number: u64,
+        transactions: Vec<BroadcastedInvokeTransactionV1>,
+    ) -> Result<BlockHashAndNumber, Error> {
+        let mut sequencer = self.sequencer.write().await;
+        let chain_id = FieldElement::from_hex_be(&sequencer.chain_id().as_hex())
+            .map_err(|_| self.error(StarknetApiError::InternalServerError))?;
+
+        let transactions = transactions
+            .into_iter()
+            .map(|transaction| {
+                broadcasted_invoke_v1_to_inner(transaction, chain_id).map(|transaction| {
+                    AccountTransaction::Invoke(InvokeTransaction::V1(transaction))
+                })
+            })
+            .collect::<Result<Vec<_>, _>>()
+            .map_err(|_| self.error(StarknetApiError::InternalServerError))?;
+
+        let tip = sequencer
+            .reorg_to(BlockNumber(block_number), transactions)
+            .map_err(|e| Error::Call(CallError::Failed(anyhow::anyhow!(e.to_string()))))?;
+
+        Ok(BlockHashAndNumber {
+            block_number: tip.block_number().0,
+            block_hash: tip.block_hash().0.into(),
+        })
+    }
+
+    async fn block_number(&self) -> Result<u64, Error> {
+        Ok(self.sequencer.read().await.block_number().0)
+    }
+
+    async fn set_block_number(&self, block_number: u64) -> Result<BlockHashAndNumber, Error> {
+        let mut sequencer = self.sequencer.write().await;
+        sequencer
+            .set_block_number(BlockNumber(block_number))
+            .map_err(|e| Error::Call(CallError::Failed(anyhow::anyhow!(e.to_string()))))?;
+
+        let (hash, number) = sequencer
+            .block_hash_and_number()
+            .ok_or(self.error(StarknetApiError::NoBlocks))?;
+        Ok(BlockHashAndNumber {
+            block_number: number.0,
+            block_hash: hash.0.into(),
+        })
+    }
+
+    async fn advance_time_and_mine(
+        &self,
+        seconds: u64,
+        blocks: u64,
+        distribute: bool,
+    ) -> Result<Vec<BlockHashAndNumber>, Error> {
+        let mined = self
+            .sequencer
+            .write()
+            .await
+            .advance_time_and_mine(seconds, blocks, distribute)
+            .map_err(|e| Error::Call(CallError::Failed(anyhow::anyhow!(e.to_string()))))?;
+
+        Ok(mined
+            .iter()
+            .map(|block| BlockHashAndNumber {
+                block_number: block.block_number().0,
+                block_hash: block.block_hash().0.into(),
+            })
+            .collect())
+    }
+
+    async fn validate_account_transaction(
+        &self,
+        transaction: BroadcastedInvokeTransactionV1,
+        block_id: BlockId,
+    ) -> Result<AccountValidation, Error> {
+        let sequencer = self.sequencer.read().await;
+        let chain_id = FieldElement::from_hex_be(&sequencer.chain_id().as_hex())
+            .map_err(|_| self.error(StarknetApiError::InternalServerError))?;
+        let transaction = broadcasted_invoke_v1_to_inner(transaction, chain_id)
+            .map_err(|_| self.error(StarknetApiError::InternalServerError))?;
+
+        let result = sequencer
+            .validate_transaction(transaction, block_id)
+            .map_err(|e| {
+                state_query_error(&self.config.error_codes, e, StarknetApiError::BlockNotFound)
+            })?;
+
+        Ok(AccountValidation {
+            is_valid: result.error.is_none(),
+            error: result.error,
+            gas_usage: result.gas_usage,
+            events: result.events,
+        })
+    }
+
+    async fn executor_config(&self) -> Result<ExecutorConfig, Error> {
+        Ok(self.sequencer.read().await.executor_config())
+    }
+
+    async fn pool_position(&self, transaction_hash: FieldElement) -> Result<PoolPosition, Error> {
+        self.sequencer
+            .read()
+            .await
+            .pool_position(&TransactionHash(StarkFelt::from(transaction_hash)))
+            .ok_or(self.error(StarknetApiError::TxnHashNotFound))
+    }
+
+    async fn oldest_pending_transaction(&self) -> Result<Option<OldestPendingTransaction>, Error> {
+        Ok(self
+            .sequencer
+            .read()
+            .await
+            .oldest_pending_transaction()
+            .map(|age| OldestPendingTransaction {
+                transaction_hash: age.transaction_hash.0.into(),
+                age_seconds: age.seconds,
+                age_blocks: age.blocks,
+            }))
+    }
+
+    async fn fund_accounts(&self, accounts: Vec<AccountFunding>) -> Result<FundedAccounts, Error> {
+        let transfers = accounts
+            .into_iter()
+            .map(|account| {
+                let address = ContractAddress(patricia_key!(account.address));
+                starkfelt_to_u128(StarkFelt::from(account.amount)).map(|amount| (address, amount))
+            })
+            .collect::<anyhow::Result<Vec<_>>>()
+            .map_err(|e| Error::Call(CallError::InvalidParams(e)))?;
+
+        let outcome = self
+            .sequencer
+            .write()
+            .await
+            .fund_accounts(&transfers)
+            .map_err(|e| Error::Call(CallError::Failed(anyhow::anyhow!(e.to_string()))))?;
+
+        let to_felts = |addresses: Vec<ContractAddress>| {
+            addresses
+                .into_iter()
+                .map(|address| FieldElement::from(*address.0.key()))
+                .collect()
+        };
+
+        Ok(FundedAccounts {
+            transaction_hashes: outcome
+                .transaction_hashes
+                .into_iter()
+                .map(|hash| hash.0.into())
+                .collect(),
+            funded: to_felts(outcome.funded),
+            unfunded: to_felts(outcome.unfunded),
+        })
+    }
+
+    async fn clear_pool(&self) -> Result<usize, Error> {
+        Ok(self.sequencer.write().await.clear_pool())
+    }
+
+    async fn class_hash_by_compiled_class_hash(
+        &self,
+        block_id: BlockId,
+        compiled_class_hash: FieldElement,
+    ) -> Result<FieldElement, Error> {
+        self.sequencer
+            .read()
+            .await
+            .class_hash_by_compiled_class_hash(
+                block_id,
+                CompiledClassHash(StarkFelt::from(compiled_class_hash)),
+            )
+            .map(|class_hash| class_hash.0.into())
+            .ok_or(self.error(StarknetApiError::ClassHashNotFound))
+    }
+
+    async fn features(&self) -> Result<BTreeMap<String, bool>, Error> {
+        let executor_config = self.sequencer.read().await.executor_config();
+        Ok(node_features(&self.config, &executor_config))
+    }
+
+    async fn events(
+        &self,
+        filter: NamedEventFilter,
+        continuation_token: Option<String>,
+        chunk_size: u64,
+    ) -> Result<EventsPage, Error> {
+        let from_block = filter.from_block.unwrap_or(BlockId::Number(0));
+        let to_block = filter.to_block.unwrap_or(BlockId::Tag(BlockTag::Latest));
+
+        let events = self
+            .sequencer
+            .read()
+            .await
+            .events(
+                from_block,
+                to_block,
+                filter.address.map(StarkFelt::from),
+                filter.keys.as_deref().map(resolve_event_keys),
+                continuation_token,
+                chunk_size,
+            )
+            .map_err(|_| self.error(StarknetApiError::InternalServerError))?;
+
+        Ok(EventsPage {
+            events: events.iter().map(to_rpc_emitted_event).collect(),
+            continuation_token: None,
+        })
+    }
+
+    async fn compute_message_hash(
+        &self,
+        from_address: FieldElement,
+        to_address: FieldElement,
+        selector: FieldElement,
+        payload: Vec<FieldElement>,
+        nonce: FieldElement,
+    ) -> Result<String, Error> {
+        let payload = payload.into_iter().map(StarkFelt::from).collect::<Vec<_>>();
+        let hash = compute_l1_to_l2_message_hash(
+            StarkFelt::from(from_address),
+            ContractAddress(patricia_key!(to_address)),
+            EntryPointSelector(StarkFelt::from(selector)),
+            &payload,
+            Nonce(StarkFelt::from(nonce)),
+        );
+
+        Ok(format!("0x{}", hex::encode(hash)))
+    }
+
+    async fn supports_interface(
+        &self,
+        contract_address: FieldElement,
+        interface_id: FieldElement,
+    ) -> Result<bool, Error> {
+        Ok(self.sequencer.write().await.supports_interface(
+            ContractAddress(patricia_key!(contract_address)),
+            StarkFelt::from(interface_id),
+        ))
+    }
 }
@@ -1,3 +1,4 @@
+use compression::CompressionLayer;
 use config::RpcConfig;
 use jsonrpsee::{
     core::Error,
@@ -5,10 +6,18 @@ use jsonrpsee::{
 };
 use katana::{api::KatanaApiServer, KatanaRpc};
 use katana_core::sequencer::Sequencer;
-use std::{net::SocketAddr, sync::Arc};
+use std::{
+    net::SocketAddr,
+    sync::{Arc, Mutex},
+    time::Duration,
+};
 use tokio::sync::RwLock;
 
+pub mod compression;
 pub mod config;
+pub mod error_codes;
+pub mod event_filter;
+pub mod features;
 mod katana;
 mod starknet;
 mod utils;
@@ -33,14 +42,27 @@ where
     }
 
     pub async fn run(self) -> Result<(SocketAddr, ServerHandle), Error> {
-        let mut methods = KatanaRpc::new(self.sequencer.clone()).into_rpc();
-        methods.merge(StarknetRpc::new(self.sequencer.clone()).into_rpc())?;
+        let mut methods = KatanaRpc::new(self.sequencer.clone(), self.config.clone()).into_rpc();
+        methods.merge(StarknetRpc::new(self.sequencer.clone(), self.config.clone()).into_rpc())?;
 
         let server = ServerBuilder::new()
-            .set_logger(KatanaNodeRpcLogger)
+            .set_logger(KatanaNodeRpcLogger::new(
+                self.config
+                    .log_unknown_methods
+                    .then(|| Arc::new(UnknownMethodLog::default())),
+            ))
+            .set_middleware(
+                tower::ServiceBuilder::new()
+                    .layer(CompressionLayer::new(self.config.compression_threshold)),
+            )
             .build(format!("127.0.0.1:{}", self.config.port))
             .await
-            .map_err(|_| Error::from(StarknetApiError::InternalServerError))?;
+            .map_err(|_| {
+                error_codes::rpc_error(
+                    &self.config.error_codes,
+                    StarknetApiError::InternalServerError,
+                )
+            })?;
 
         let addr = server.local_addr()?;
         let handle = server.start(methods)?;
@@ -53,12 +75,93 @@ use std::time::Instant;
 
 use jsonrpsee::{
     server::logger::{Logger, MethodKind, TransportProtocol},
-    tracing::info,
+    tracing::{info, warn},
     types::Params,
 };
 
+/// Maximum number of unknown method calls logged per window, so that scanners probing the
+/// node don't flood the logs.
+const UNKNOWN_METHOD_LOG_LIMIT: usize = 10;
+const UNKNOWN_METHOD_LOG_WINDOW: Duration = Duration::from_secs(60);
+
+/// Rate-limited audit log of the calls to unknown methods.
+#[derive(Debug)]
+pub struct UnknownMethodLog {
+    limit: usize,
+    window: Duration,
+    state: Mutex<UnknownMethodLogWindow>,
+}
+
+#[derive(Debug, Default)]
+struct UnknownMethodLogWindow {
+    started_at: Option<Instant>,
+    logged: usize,
+    suppressed: usize,
+}
+
+impl Default for UnknownMethodLog {
+    fn default() -> Self {
+        Self::new(UNKNOWN_METHOD_LOG_LIMIT, UNKNOWN_METHOD_LOG_WINDOW)
+    }
+}
+
+impl UnknownMethodLog {
+    pub fn new(limit: usize, window: Duration) -> Self {
+        Self {
+            limit,
+            window,
+            state: Mutex::new(UnknownMethodLogWindow::default()),
+        }
+    }
+
+    /// Logs the call unless the limit of the current window is reached, and returns whether
+    /// it was logged.
+    pub fn record(&self, method_name: &str) -> bool {
+        let mut state = self.state.lock().unwrap();
+
+        let now = Instant::now();
+        if state.started_at.map_or(true, |started_at| {
+            now.duration_since(started_at) >= self.window
+        }) {
+            if state.suppressed > 0 {
+                warn!(
+                    "{} more calls to unknown methods were not logged",
+                    state.suppressed
+                );
+            }
+            *state = UnknownMethodLogWindow {
+                started_at: Some(now),
+                ..Default::default()
+            };
+        }
+
+        if state.logged >= self.limit {
+            state.suppressed += 1;
+            return false;
+        }
+
+        state.logged += 1;
+        warn!("Call to unknown method '{method_name}'");
+        true
+    }
+
+    /// The number of calls logged in the current window.
+    pub fn logged(&self) -> usize {
+        self.state.lock().unwrap().logged
+    }
+}
+
 #[derive(Debug, Clone)]
-pub struct KatanaNodeRpcLogger;
+pub struct KatanaNodeRpcLogger {
+    unknown_methods: Option<Arc<UnknownMethodLog>>,
+}
+
+impl KatanaNodeRpcLogger {
+    /// Calls to unknown methods are only logged when given a log to record them in.
+    pub fn new(unknown_methods: Option<Arc<UnknownMethodLog>>) -> Self {
+        Self { unknown_methods }
+    }
+}
 
 impl Logger for KatanaNodeRpcLogger {
     type Instant = std::time::Instant;
@@ -79,10 +182,14 @@ impl Logger for KatanaNodeRpcLogger {
         &self,
         method_name: &str,
         _params: Params<'_>,
-        _kind: MethodKind,
+        kind: MethodKind,
         _transport: TransportProtocol,
     ) {
         info!("method: '{}'", method_name);
+
+        if let (MethodKind::Unknown, Some(unknown_methods)) = (kind, &self.unknown_methods) {
+            unknown_methods.record(method_name);
+        }
     }
 
     fn on_result(
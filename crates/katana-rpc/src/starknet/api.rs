@@ -1,8 +1,5 @@
-use jsonrpsee::{
-    core::Error,
-    proc_macros::rpc,
-    types::error::{CallError, ErrorObject},
-};
+use jsonrpsee::{core::Error, proc_macros::rpc};
+use serde::{Deserialize, Serialize};
 
 use starknet::{
     core::types::FieldElement,
@@ -16,6 +13,20 @@ use starknet::{
     },
 };
 
+use crate::error_codes::ApiError;
+
+/// The transaction fields to include in block responses.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum TransactionProjection {
+    /// The full transaction bodies.
+    #[default]
+    Full,
+    /// The transactions with their calldata and signatures emptied. The fields are kept as
+    /// `[]` rather than omitted, so that the transactions still match the spec types.
+    Summary,
+}
+
 #[derive(thiserror::Error, Clone, Copy, Debug)]
 pub enum StarknetApiError {
     #[error("Failed to write transaction")]
@@ -44,8 +55,14 @@ pub enum StarknetApiError {
     ContractError = 40,
     #[error("Invalid contract class")]
     InvalidContractClass = 50,
+    #[error("Account balance is smaller than the transaction's max_fee")]
+    InsufficientAccountBalance = 54,
+    #[error("A transaction with the same hash already exists in the mempool")]
+    DuplicateTx = 59,
     #[error("Too many storage keys requested")]
     ProofLimitExceeded = 10000,
+    #[error("Query too deep: the block is older than the maximum historical query depth")]
+    QueryTooDeep = 10001,
     #[error("Too many keys provided in a filter")]
     TooManyKeysInFilter = 34,
     #[error("Internal server error")]
@@ -54,13 +71,9 @@ pub enum StarknetApiError {
     FailedToFetchPendingTransactions = 38,
 }
 
-impl From<StarknetApiError> for Error {
-    fn from(err: StarknetApiError) -> Self {
-        Error::Call(CallError::Custom(ErrorObject::owned(
-            err as i32,
-            err.to_string(),
-            None::<()>,
-        )))
+impl ApiError for StarknetApiError {
+    fn code(self) -> i32 {
+        self as i32
     }
 }
 
@@ -112,7 +125,11 @@ pub trait StarknetApi {
     ) -> Result<Transaction, Error>;
 
     #[method(name = "getBlockWithTxs")]
-    async fn block_with_txs(&self, block_id: BlockId) -> Result<MaybePendingBlockWithTxs, Error>;
+    async fn block_with_txs(
+        &self,
+        block_id: BlockId,
+        projection: Option<TransactionProjection>,
+    ) -> Result<MaybePendingBlockWithTxs, Error>;
 
     #[method(name = "getStateUpdate")]
     async fn state_update(&self, block_id: BlockId) -> Result<StateUpdate, Error>;
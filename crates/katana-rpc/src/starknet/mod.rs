@@ -7,21 +7,27 @@ use jsonrpsee::{
     types::error::CallError,
 };
 use katana_core::{
-    constants::SEQUENCER_ADDRESS,
     sequencer::Sequencer,
-    starknet::transaction::ExternalFunctionCall,
+    starknet::{
+        transaction::ExternalFunctionCall, DuplicateTransaction, InsufficientAccountBalance,
+        QueryTooDeep,
+    },
     util::{blockifier_contract_class_from_flattened_sierra_class, starkfelt_to_u128},
 };
 use starknet::providers::jsonrpc::models::{
     BlockHashAndNumber, BlockId, BlockStatus, BlockWithTxHashes, BlockWithTxs,
     BroadcastedDeclareTransaction, BroadcastedDeployAccountTransaction,
     BroadcastedInvokeTransaction, BroadcastedTransaction, ContractClass, DeclareTransactionResult,
-    DeployAccountTransactionResult, EmittedEvent, EventFilter, EventsPage, FeeEstimate,
-    FunctionCall, InvokeTransactionResult, MaybePendingBlockWithTxHashes, MaybePendingBlockWithTxs,
+    DeployAccountTransactionResult, EventFilter, EventsPage, FeeEstimate, FunctionCall,
+    InvokeTransactionResult, MaybePendingBlockWithTxHashes, MaybePendingBlockWithTxs,
     MaybePendingTransactionReceipt, PendingBlockWithTxs, StateUpdate, Transaction,
 };
 use starknet::{core::types::contract::FlattenedSierraClass, providers::jsonrpc::models::BlockTag};
-use starknet::{core::types::FieldElement, providers::jsonrpc::models::PendingBlockWithTxHashes};
+use starknet::{
+    core::types::{FieldElement, TransactionStatus},
+    providers::jsonrpc::models::PendingBlockWithTxHashes,
+};
+use starknet_api::state::StorageKey;
 use starknet_api::{
     core::{ClassHash, CompiledClassHash, ContractAddress, PatriciaKey},
     hash::StarkFelt,
@@ -36,27 +42,101 @@ use starknet_api::{
     transaction::TransactionHash,
 };
 use starknet_api::{hash::StarkHash, transaction::TransactionSignature};
-use starknet_api::{state::StorageKey, transaction::InvokeTransactionV1};
-use std::sync::Arc;
-use tokio::sync::RwLock;
+use std::{sync::Arc, time::Duration};
+use tokio::{
+    sync::RwLock,
+    time::{sleep, Instant},
+};
+use utils::event::to_rpc_emitted_event;
 use utils::transaction::{
-    compute_declare_v2_transaction_hash, compute_invoke_v1_transaction_hash,
-    convert_inner_to_rpc_tx,
+    broadcasted_invoke_v1_to_inner, compute_declare_v2_transaction_hash, convert_inner_to_rpc_tx,
+    strip_transaction_payload,
+};
+
+use crate::{
+    config::RpcConfig,
+    error_codes::{rpc_error, ErrorCodes},
+    utils,
 };
 
-use crate::utils;
+use self::api::{StarknetApiError, StarknetApiServer, TransactionProjection};
 
-use self::api::{StarknetApiError, StarknetApiServer};
+/// How often the status of a transaction is checked while waiting for it to be mined.
+const INSTANT_CONFIRM_POLL_INTERVAL: Duration = Duration::from_millis(50);
+
+// Rejections with a spec error get it, the others are reported with their message
+fn transaction_error(codes: &ErrorCodes, err: anyhow::Error) -> Error {
+    if err.downcast_ref::<InsufficientAccountBalance>().is_some() {
+        rpc_error(codes, StarknetApiError::InsufficientAccountBalance)
+    } else if err.downcast_ref::<DuplicateTransaction>().is_some() {
+        rpc_error(codes, StarknetApiError::DuplicateTx)
+    } else {
+        Error::Call(CallError::Failed(anyhow::anyhow!(err.to_string())))
+    }
+}
+
+// Historical state queries reaching too far back get their spec error, the others the given
+// one
+pub(crate) fn state_query_error(
+    codes: &ErrorCodes,
+    err: anyhow::Error,
+    fallback: StarknetApiError,
+) -> Error {
+    if err.downcast_ref::<QueryTooDeep>().is_some() {
+        rpc_error(codes, StarknetApiError::QueryTooDeep)
+    } else {
+        rpc_error(codes, fallback)
+    }
+}
 
 pub mod api;
 
 pub struct StarknetRpc<S> {
     sequencer: Arc<RwLock<S>>,
+    config: RpcConfig,
 }
 
 impl<S: Sequencer + Send + Sync + 'static> StarknetRpc<S> {
-    pub fn new(sequencer: Arc<RwLock<S>>) -> Self {
-        Self { sequencer }
+    pub fn new(sequencer: Arc<RwLock<S>>, config: RpcConfig) -> Self {
+        Self { sequencer, config }
+    }
+
+    // Builds the error with the custom code configured for it, if any
+    fn error(&self, err: StarknetApiError) -> Error {
+        rpc_error(&self.config.error_codes, err)
+    }
+
+    // With instant confirmation, waits for the submitted transaction to be mined so that its
+    // receipt is available once the add-transaction call returns
+    async fn wait_until_mined(&self, transaction_hash: TransactionHash) -> Result<(), Error> {
+        let Some(timeout) = self.config.instant_confirm_timeout else {
+            return Ok(());
+        };
+
+        let deadline = Instant::now() + timeout;
+        loop {
+            let status = self
+                .sequencer
+                .read()
+                .await
+                .transaction_status(&transaction_hash);
+
+            match status {
+                Some(TransactionStatus::AcceptedOnL2) => return Ok(()),
+                Some(TransactionStatus::Rejected) => {
+                    return Err(Error::Call(CallError::Failed(anyhow::anyhow!(
+                        "transaction {transaction_hash} was rejected"
+                    ))))
+                }
+                _ if Instant::now() >= deadline => {
+                    return Err(Error::Call(CallError::Failed(anyhow::anyhow!(
+                        "transaction {transaction_hash} was not mined within {}s",
+                        timeout.as_secs()
+                    ))))
+                }
+                _ => sleep(INSTANT_CONFIRM_POLL_INTERVAL).await,
+            }
+        }
     }
 }
 #[allow(unused)]
@@ -76,7 +156,7 @@ impl<S: Sequencer + Send + Sync + 'static> StarknetApiServer for StarknetRpc<S>
             .write()
             .await
             .nonce_at(block_id, ContractAddress(patricia_key!(contract_address)))
-            .map_err(|_| Error::from(StarknetApiError::ContractError))?;
+            .map_err(|_| self.error(StarknetApiError::ContractError))?;
 
         Ok(nonce.0.into())
     }
@@ -94,9 +174,9 @@ impl<S: Sequencer + Send + Sync + 'static> StarknetApiServer for StarknetRpc<S>
             .write()
             .await
             .transaction(&TransactionHash(StarkFelt::from(transaction_hash)))
-            .ok_or(Error::from(StarknetApiError::TxnHashNotFound))?;
+            .ok_or(self.error(StarknetApiError::TxnHashNotFound))?;
 
-        convert_inner_to_rpc_tx(tx).map_err(|_| Error::from(StarknetApiError::InternalServerError))
+        convert_inner_to_rpc_tx(tx).map_err(|_| self.error(StarknetApiError::InternalServerError))
     }
 
     async fn block_transaction_count(&self, block_id: BlockId) -> Result<u64, Error> {
@@ -105,13 +185,13 @@ impl<S: Sequencer + Send + Sync + 'static> StarknetApiServer for StarknetRpc<S>
             .read()
             .await
             .block(block_id)
-            .ok_or(Error::from(StarknetApiError::BlockNotFound))?;
+            .ok_or(self.error(StarknetApiError::BlockNotFound))?;
 
         block
             .transactions()
             .len()
             .try_into()
-            .map_err(|_| Error::from(StarknetApiError::InternalServerError))
+            .map_err(|_| self.error(StarknetApiError::InternalServerError))
     }
 
     async fn class_at(
@@ -119,7 +199,7 @@ impl<S: Sequencer + Send + Sync + 'static> StarknetApiServer for StarknetRpc<S>
         block_id: BlockId,
         contract_address: FieldElement,
     ) -> Result<ContractClass, Error> {
-        Err(Error::from(StarknetApiError::InternalServerError))
+        Err(self.error(StarknetApiError::InternalServerError))
     }
 
     async fn block_hash_and_number(&self) -> Result<BlockHashAndNumber, Error> {
@@ -128,7 +208,7 @@ impl<S: Sequencer + Send + Sync + 'static> StarknetApiServer for StarknetRpc<S>
             .read()
             .await
             .block_hash_and_number()
-            .ok_or(Error::from(StarknetApiError::NoBlocks))?;
+            .ok_or(self.error(StarknetApiError::NoBlocks))?;
 
         Ok(BlockHashAndNumber {
             block_number: number.0,
@@ -145,9 +225,9 @@ impl<S: Sequencer + Send + Sync + 'static> StarknetApiServer for StarknetRpc<S>
             .read()
             .await
             .block(block_id)
-            .ok_or(Error::from(StarknetApiError::BlockNotFound))?;
+            .ok_or(self.error(StarknetApiError::BlockNotFound))?;
 
-        let sequencer_address = FieldElement::from(*SEQUENCER_ADDRESS);
+        let sequencer_address = FieldElement::from(*block.header().sequencer.0.key());
         let transactions = block
             .transactions()
             .iter()
@@ -190,30 +270,40 @@ impl<S: Sequencer + Send + Sync + 'static> StarknetApiServer for StarknetRpc<S>
             .read()
             .await
             .block(block_id)
-            .ok_or(Error::from(StarknetApiError::BlockNotFound))?;
+            .ok_or(self.error(StarknetApiError::BlockNotFound))?;
 
         let transaction = block
             .transactions()
             .get(index)
-            .ok_or(Error::from(StarknetApiError::InvalidTxnIndex))?;
+            .ok_or(self.error(StarknetApiError::InvalidTxnIndex))?;
 
         convert_inner_to_rpc_tx(transaction.clone())
-            .map_err(|_| Error::from(StarknetApiError::InternalServerError))
+            .map_err(|_| self.error(StarknetApiError::InternalServerError))
     }
 
-    async fn block_with_txs(&self, block_id: BlockId) -> Result<MaybePendingBlockWithTxs, Error> {
+    async fn block_with_txs(
+        &self,
+        block_id: BlockId,
+        projection: Option<TransactionProjection>,
+    ) -> Result<MaybePendingBlockWithTxs, Error> {
         let block = self
             .sequencer
             .read()
             .await
             .block(block_id)
-            .ok_or(Error::from(StarknetApiError::BlockNotFound))?;
+            .ok_or(self.error(StarknetApiError::BlockNotFound))?;
 
-        let sequencer_address = FieldElement::from(*SEQUENCER_ADDRESS);
+        let sequencer_address = FieldElement::from(*block.header().sequencer.0.key());
         let transactions = block
             .transactions()
             .iter()
-            .map(|tx| convert_inner_to_rpc_tx(tx.clone()).unwrap())
+            .map(|tx| {
+                let mut tx = convert_inner_to_rpc_tx(tx.clone()).unwrap();
+                if projection == Some(TransactionProjection::Summary) {
+                    strip_transaction_payload(&mut tx);
+                }
+                tx
+            })
             .collect::<Vec<_>>();
         let timestamp = block.header().timestamp.0;
         let parent_hash = block.header().parent_hash.0.into();
@@ -246,14 +336,14 @@ impl<S: Sequencer + Send + Sync + 'static> StarknetApiServer for StarknetRpc<S>
             .read()
             .await
             .state_update(block_id)
-            .map_err(|_| Error::from(StarknetApiError::BlockNotFound))
+            .map_err(|_| self.error(StarknetApiError::BlockNotFound))
     }
 
     async fn transaction_receipt(
         &self,
         transaction_hash: FieldElement,
     ) -> Result<MaybePendingTransactionReceipt, Error> {
-        Err(Error::from(StarknetApiError::InternalServerError))
+        Err(self.error(StarknetApiError::InternalServerError))
     }
 
     async fn class_hash_at(
@@ -266,7 +356,7 @@ impl<S: Sequencer + Send + Sync + 'static> StarknetApiServer for StarknetRpc<S>
             .write()
             .await
             .class_hash_at(block_id, ContractAddress(patricia_key!(contract_address)))
-            .map_err(|_| Error::from(StarknetApiError::ContractError))?;
+            .map_err(|_| self.error(StarknetApiError::ContractError))?;
 
         Ok(class_hash.0.into())
     }
@@ -276,7 +366,7 @@ impl<S: Sequencer + Send + Sync + 'static> StarknetApiServer for StarknetRpc<S>
         block_id: BlockId,
         class_hash: FieldElement,
     ) -> Result<ContractClass, Error> {
-        Err(Error::from(StarknetApiError::InternalServerError))
+        Err(self.error(StarknetApiError::InternalServerError))
     }
 
     async fn events(
@@ -304,39 +394,16 @@ impl<S: Sequencer + Send + Sync + 'static> StarknetApiServer for StarknetRpc<S>
                 continuation_token,
                 chunk_size,
             )
-            .map_err(|_| Error::from(StarknetApiError::InternalServerError))?;
+            .map_err(|_| self.error(StarknetApiError::InternalServerError))?;
 
         Ok(EventsPage {
-            events: events
-                .iter()
-                .map(|e| EmittedEvent {
-                    block_number: e.block_number.0,
-                    block_hash: (e.block_hash.0).into(),
-                    transaction_hash: (e.transaction_hash.0).into(),
-                    from_address: (*e.inner.from_address.0.key()).into(),
-                    keys: e
-                        .inner
-                        .content
-                        .keys
-                        .iter()
-                        .map(|key| (key.0).into())
-                        .collect(),
-                    data: e
-                        .inner
-                        .content
-                        .data
-                        .0
-                        .iter()
-                        .map(|fe| (*fe).into())
-                        .collect(),
-                })
-                .collect(),
+            events: events.iter().map(to_rpc_emitted_event).collect(),
             continuation_token: None,
         })
     }
 
     async fn pending_transactions(&self) -> Result<Vec<Transaction>, Error> {
-        Err(Error::from(StarknetApiError::InternalServerError))
+        Err(self.error(StarknetApiError::InternalServerError))
     }
 
     async fn call(
@@ -357,7 +424,9 @@ impl<S: Sequencer + Send + Sync + 'static> StarknetApiServer for StarknetRpc<S>
             .read()
             .await
             .call(block_id, call)
-            .map_err(|_| Error::from(StarknetApiError::ContractError))?;
+            .map_err(|e| {
+                state_query_error(&self.config.error_codes, e, StarknetApiError::ContractError)
+            })?;
 
         let mut values = vec![];
 
@@ -383,7 +452,9 @@ impl<S: Sequencer + Send + Sync + 'static> StarknetApiServer for StarknetRpc<S>
                 StorageKey(patricia_key!(key)),
                 block_id,
             )
-            .map_err(|e| Error::from(StarknetApiError::ContractError))?;
+            .map_err(|e| {
+                state_query_error(&self.config.error_codes, e, StarknetApiError::ContractError)
+            })?;
 
         Ok(value.into())
     }
@@ -418,7 +489,8 @@ impl<S: Sequencer + Send + Sync + 'static> StarknetApiServer for StarknetRpc<S>
                 )),
                 TransactionSignature(signature.into_iter().map(StarkFelt::from).collect()),
             )
-            .map_err(|e| Error::Call(CallError::Failed(anyhow::anyhow!(e.to_string()))))?;
+            .map_err(|e| transaction_error(&self.config.error_codes, e))?;
+        self.wait_until_mined(transaction_hash).await?;
 
         Ok(DeployAccountTransactionResult {
             transaction_hash: FieldElement::from(transaction_hash.0),
@@ -432,17 +504,17 @@ impl<S: Sequencer + Send + Sync + 'static> StarknetApiServer for StarknetRpc<S>
         block_id: BlockId,
     ) -> Result<FeeEstimate, Error> {
         let chain_id = FieldElement::from_hex_be(&self.sequencer.read().await.chain_id().as_hex())
-            .map_err(|_| Error::from(StarknetApiError::InternalServerError))?;
+            .map_err(|_| self.error(StarknetApiError::InternalServerError))?;
 
         let transaction = match request {
             BroadcastedTransaction::Declare(BroadcastedDeclareTransaction::V2(tx)) => {
                 let raw_class_str = serde_json::to_string(&tx.contract_class)?;
                 let class_hash = serde_json::from_str::<FlattenedSierraClass>(&raw_class_str)
-                    .map_err(|_| Error::from(StarknetApiError::InvalidContractClass))?
+                    .map_err(|_| self.error(StarknetApiError::InvalidContractClass))?
                     .class_hash();
                 let contract_class =
                     blockifier_contract_class_from_flattened_sierra_class(&raw_class_str)
-                        .map_err(|_| Error::from(StarknetApiError::InternalServerError))?;
+                        .map_err(|_| self.error(StarknetApiError::InternalServerError))?;
 
                 let transaction_hash = compute_declare_v2_transaction_hash(
                     tx.sender_address,
@@ -459,7 +531,7 @@ impl<S: Sequencer + Send + Sync + 'static> StarknetApiServer for StarknetRpc<S>
                     sender_address: ContractAddress(patricia_key!(tx.sender_address)),
                     nonce: Nonce(StarkFelt::from(tx.nonce)),
                     max_fee: Fee(starkfelt_to_u128(StarkFelt::from(tx.max_fee))
-                        .map_err(|_| Error::from(StarknetApiError::InternalServerError))?),
+                        .map_err(|_| self.error(StarknetApiError::InternalServerError))?),
                     signature: TransactionSignature(
                         tx.signature.into_iter().map(StarkFelt::from).collect(),
                     ),
@@ -475,40 +547,13 @@ impl<S: Sequencer + Send + Sync + 'static> StarknetApiServer for StarknetRpc<S>
             }
 
             BroadcastedTransaction::Invoke(BroadcastedInvokeTransaction::V1(transaction)) => {
-                let transaction_hash = compute_invoke_v1_transaction_hash(
-                    transaction.sender_address,
-                    &transaction.calldata,
-                    transaction.max_fee,
-                    chain_id,
-                    transaction.nonce,
-                );
-
-                let transaction = InvokeTransactionV1 {
-                    transaction_hash: TransactionHash(StarkFelt::from(transaction_hash)),
-                    sender_address: ContractAddress(patricia_key!(transaction.sender_address)),
-                    nonce: Nonce(StarkFelt::from(transaction.nonce)),
-                    calldata: Calldata(Arc::new(
-                        transaction
-                            .calldata
-                            .into_iter()
-                            .map(StarkFelt::from)
-                            .collect(),
-                    )),
-                    max_fee: Fee(starkfelt_to_u128(StarkFelt::from(transaction.max_fee))
-                        .map_err(|_| Error::from(StarknetApiError::InternalServerError))?),
-                    signature: TransactionSignature(
-                        transaction
-                            .signature
-                            .into_iter()
-                            .map(StarkFelt::from)
-                            .collect(),
-                    ),
-                };
+                let transaction = broadcasted_invoke_v1_to_inner(transaction, chain_id)
+                    .map_err(|_| self.error(StarknetApiError::InternalServerError))?;
 
                 AccountTransaction::Invoke(InvokeTransaction::V1(transaction))
             }
 
-            _ => return Err(Error::from(StarknetApiError::InternalServerError)),
+            _ => return Err(self.error(StarknetApiError::InternalServerError)),
         };
 
         let fee_estimate = self
@@ -516,7 +561,13 @@ impl<S: Sequencer + Send + Sync + 'static> StarknetApiServer for StarknetRpc<S>
             .read()
             .await
             .estimate_fee(transaction, block_id)
-            .map_err(|e| Error::from(StarknetApiError::InternalServerError))?;
+            .map_err(|e| {
+                state_query_error(
+                    &self.config.error_codes,
+                    e,
+                    StarknetApiError::InternalServerError,
+                )
+            })?;
 
         Ok(FeeEstimate {
             gas_price: fee_estimate.gas_price,
@@ -530,20 +581,20 @@ impl<S: Sequencer + Send + Sync + 'static> StarknetApiServer for StarknetRpc<S>
         transaction: BroadcastedDeclareTransaction,
     ) -> Result<DeclareTransactionResult, Error> {
         let chain_id = FieldElement::from_hex_be(&self.sequencer.read().await.chain_id().as_hex())
-            .map_err(|_| Error::from(StarknetApiError::InternalServerError))?;
+            .map_err(|_| self.error(StarknetApiError::InternalServerError))?;
 
         let (transaction_hash, class_hash, transaction) = match transaction {
             BroadcastedDeclareTransaction::V1(_) => {
-                return Err(Error::from(StarknetApiError::InternalServerError))
+                return Err(self.error(StarknetApiError::InternalServerError))
             }
             BroadcastedDeclareTransaction::V2(tx) => {
                 let raw_class_str = serde_json::to_string(&tx.contract_class)?;
                 let class_hash = serde_json::from_str::<FlattenedSierraClass>(&raw_class_str)
-                    .map_err(|_| Error::from(StarknetApiError::InvalidContractClass))?
+                    .map_err(|_| self.error(StarknetApiError::InvalidContractClass))?
                     .class_hash();
                 let contract_class =
                     blockifier_contract_class_from_flattened_sierra_class(&raw_class_str)
-                        .map_err(|_| Error::from(StarknetApiError::InternalServerError))?;
+                        .map_err(|_| self.error(StarknetApiError::InternalServerError))?;
 
                 let transaction_hash = compute_declare_v2_transaction_hash(
                     tx.sender_address,
@@ -560,7 +611,7 @@ impl<S: Sequencer + Send + Sync + 'static> StarknetApiServer for StarknetRpc<S>
                     sender_address: ContractAddress(patricia_key!(tx.sender_address)),
                     nonce: Nonce(StarkFelt::from(tx.nonce)),
                     max_fee: Fee(starkfelt_to_u128(StarkFelt::from(tx.max_fee))
-                        .map_err(|_| Error::from(StarknetApiError::InternalServerError))?),
+                        .map_err(|_| self.error(StarknetApiError::InternalServerError))?),
                     signature: TransactionSignature(
                         tx.signature.into_iter().map(StarkFelt::from).collect(),
                     ),
@@ -583,7 +634,10 @@ impl<S: Sequencer + Send + Sync + 'static> StarknetApiServer for StarknetRpc<S>
         self.sequencer
             .write()
             .await
-            .add_account_transaction(transaction);
+            .add_account_transaction(transaction)
+            .map_err(|e| transaction_error(&self.config.error_codes, e))?;
+        self.wait_until_mined(TransactionHash(StarkFelt::from(transaction_hash)))
+            .await?;
 
         Ok(DeclareTransactionResult {
             transaction_hash,
@@ -599,49 +653,26 @@ impl<S: Sequencer + Send + Sync + 'static> StarknetApiServer for StarknetRpc<S>
             BroadcastedInvokeTransaction::V1(transaction) => {
                 let chain_id =
                     FieldElement::from_hex_be(&self.sequencer.read().await.chain_id().as_hex())
-                        .map_err(|_| Error::from(StarknetApiError::InternalServerError))?;
-
-                let transaction_hash = compute_invoke_v1_transaction_hash(
-                    transaction.sender_address,
-                    &transaction.calldata,
-                    transaction.max_fee,
-                    chain_id,
-                    transaction.nonce,
-                );
+                        .map_err(|_| self.error(StarknetApiError::InternalServerError))?;
 
-                let transaction = InvokeTransactionV1 {
-                    transaction_hash: TransactionHash(StarkFelt::from(transaction_hash)),
-                    sender_address: ContractAddress(patricia_key!(transaction.sender_address)),
-                    nonce: Nonce(StarkFelt::from(transaction.nonce)),
-                    calldata: Calldata(Arc::new(
-                        transaction
-                            .calldata
-                            .into_iter()
-                            .map(StarkFelt::from)
-                            .collect(),
-                    )),
-                    max_fee: Fee(starkfelt_to_u128(StarkFelt::from(transaction.max_fee))
-                        .map_err(|_| Error::from(StarknetApiError::InternalServerError))?),
-                    signature: TransactionSignature(
-                        transaction
-                            .signature
-                            .into_iter()
-                            .map(StarkFelt::from)
-                            .collect(),
-                    ),
-                };
+                let transaction = broadcasted_invoke_v1_to_inner(transaction, chain_id)
+                    .map_err(|_| self.error(StarknetApiError::InternalServerError))?;
+                let transaction_hash = FieldElement::from(transaction.transaction_hash.0);
 
                 self.sequencer
                     .write()
                     .await
                     .add_account_transaction(AccountTransaction::Invoke(InvokeTransaction::V1(
                         transaction,
-                    )));
+                    )))
+                    .map_err(|e| transaction_error(&self.config.error_codes, e))?;
+                self.wait_until_mined(TransactionHash(StarkFelt::from(transaction_hash)))
+                    .await?;
 
                 Ok(InvokeTransactionResult { transaction_hash })
             }
 
-            _ => Err(Error::from(StarknetApiError::InternalServerError)),
+            _ => Err(self.error(StarknetApiError::InternalServerError)),
         }
     }
 }
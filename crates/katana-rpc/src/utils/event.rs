@@ -0,0 +1,26 @@
+use katana_core::starknet::event::EmittedEvent;
+use starknet::providers::jsonrpc::models::EmittedEvent as RpcEmittedEvent;
+
+pub fn to_rpc_emitted_event(event: &EmittedEvent) -> RpcEmittedEvent {
+    RpcEmittedEvent {
+        block_number: event.block_number.0,
+        block_hash: (event.block_hash.0).into(),
+        transaction_hash: (event.transaction_hash.0).into(),
+        from_address: (*event.inner.from_address.0.key()).into(),
+        keys: event
+            .inner
+            .content
+            .keys
+            .iter()
+            .map(|key| (key.0).into())
+            .collect(),
+        data: event
+            .inner
+            .content
+            .data
+            .0
+            .iter()
+            .map(|fe| (*fe).into())
+            .collect(),
+    }
+}
@@ -1,4 +1,5 @@
 #![allow(unused)]
 
 pub mod contract;
+pub mod event;
 pub mod transaction;
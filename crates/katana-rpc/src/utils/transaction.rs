@@ -1,20 +1,29 @@
-use std::str::FromStr;
+use std::{str::FromStr, sync::Arc};
 
 use anyhow::{Ok, Result};
+use katana_core::util::starkfelt_to_u128;
 use starknet::{
     core::{crypto::compute_hash_on_elements, types::FieldElement},
     providers::jsonrpc::models::{
-        DeclareTransaction, DeclareTransactionV1, DeclareTransactionV2, DeployAccountTransaction,
-        InvokeTransaction, InvokeTransactionV1, L1HandlerTransaction, Transaction,
+        BroadcastedInvokeTransactionV1, DeclareTransaction, DeclareTransactionV1,
+        DeclareTransactionV2, DeployAccountTransaction, Event, InvokeTransaction,
+        InvokeTransactionV1, L1HandlerTransaction, MsgToL1, PendingDeclareTransactionReceipt,
+        PendingDeployAccountTransactionReceipt, PendingInvokeTransactionReceipt,
+        PendingL1HandlerTransactionReceipt, PendingTransactionReceipt, Transaction,
     },
 };
 use starknet_api::{
-    hash::StarkFelt,
+    core::{ContractAddress, Nonce, PatriciaKey},
+    hash::{StarkFelt, StarkHash},
+    patricia_key,
     transaction::{
-        DeclareTransaction as InnerDeclareTransaction,
-        DeployAccountTransaction as InnerDeployAccountTransaction,
+        Calldata, DeclareTransaction as InnerDeclareTransaction,
+        DeployAccountTransaction as InnerDeployAccountTransaction, Event as InnerEvent, Fee,
         InvokeTransaction as InnerInvokeTransaction,
-        L1HandlerTransaction as InnerL1HandlerTransaction, Transaction as InnerTransaction,
+        InvokeTransactionV1 as InnerInvokeTransactionV1,
+        L1HandlerTransaction as InnerL1HandlerTransaction, MessageToL1 as InnerMessageToL1,
+        Transaction as InnerTransaction, TransactionHash, TransactionOutput,
+        TransactionReceipt as InnerTransactionReceipt, TransactionSignature,
     },
 };
 
@@ -102,6 +111,42 @@ pub fn compute_invoke_v1_transaction_hash(
     ])
 }
 
+/// Converts a broadcasted invoke transaction into the transaction executed by the sequencer,
+/// computing its hash on the given chain.
+pub fn broadcasted_invoke_v1_to_inner(
+    transaction: BroadcastedInvokeTransactionV1,
+    chain_id: FieldElement,
+) -> Result<InnerInvokeTransactionV1> {
+    let transaction_hash = compute_invoke_v1_transaction_hash(
+        transaction.sender_address,
+        &transaction.calldata,
+        transaction.max_fee,
+        chain_id,
+        transaction.nonce,
+    );
+
+    Ok(InnerInvokeTransactionV1 {
+        transaction_hash: TransactionHash(StarkFelt::from(transaction_hash)),
+        sender_address: ContractAddress(patricia_key!(transaction.sender_address)),
+        nonce: Nonce(StarkFelt::from(transaction.nonce)),
+        calldata: Calldata(Arc::new(
+            transaction
+                .calldata
+                .into_iter()
+                .map(StarkFelt::from)
+                .collect(),
+        )),
+        max_fee: Fee(starkfelt_to_u128(StarkFelt::from(transaction.max_fee))?),
+        signature: TransactionSignature(
+            transaction
+                .signature
+                .into_iter()
+                .map(StarkFelt::from)
+                .collect(),
+        ),
+    })
+}
+
 pub fn convert_stark_felt_array_to_field_element_array(
     calldata: &[StarkFelt],
 ) -> Result<Vec<FieldElement>> {
@@ -111,6 +156,29 @@ pub fn convert_stark_felt_array_to_field_element_array(
     })
 }
 
+/// Clears the calldata and signatures of the transaction, leaving only its summary fields. The
+/// cleared fields are serialized as `[]`.
+pub fn strip_transaction_payload(transaction: &mut Transaction) {
+    match transaction {
+        Transaction::Invoke(InvokeTransaction::V0(tx)) => {
+            tx.calldata.clear();
+            tx.signature.clear();
+        }
+        Transaction::Invoke(InvokeTransaction::V1(tx)) => {
+            tx.calldata.clear();
+            tx.signature.clear();
+        }
+        Transaction::Declare(DeclareTransaction::V1(tx)) => tx.signature.clear(),
+        Transaction::Declare(DeclareTransaction::V2(tx)) => tx.declare_txn_v1.signature.clear(),
+        Transaction::DeployAccount(tx) => {
+            tx.constructor_calldata.clear();
+            tx.signature.clear();
+        }
+        Transaction::Deploy(tx) => tx.constructor_calldata.clear(),
+        Transaction::L1Handler(tx) => tx.calldata.clear(),
+    }
+}
+
 pub fn convert_inner_to_rpc_tx(transaction: InnerTransaction) -> Result<Transaction> {
     let tx = match transaction {
         InnerTransaction::Invoke(invoke) => Transaction::Invoke(convert_invoke_to_rpc_tx(invoke)?),
@@ -128,6 +196,73 @@ pub fn convert_inner_to_rpc_tx(transaction: InnerTransaction) -> Result<Transact
     Ok(tx)
 }
 
+/// Converts the receipt of a transaction of the pending block, which has no block hash nor
+/// number yet.
+pub fn convert_inner_to_rpc_pending_receipt(
+    receipt: InnerTransactionReceipt,
+) -> Result<PendingTransactionReceipt> {
+    let transaction_hash = receipt.transaction_hash.0.into();
+
+    Ok(match receipt.output {
+        TransactionOutput::Invoke(output) => {
+            PendingTransactionReceipt::Invoke(PendingInvokeTransactionReceipt {
+                transaction_hash,
+                actual_fee: FieldElement::from_str(&output.actual_fee.0.to_string())?,
+                messages_sent: convert_inner_to_rpc_messages(&output.messages_sent)?,
+                events: convert_inner_to_rpc_events(&output.events),
+            })
+        }
+        TransactionOutput::Declare(output) => {
+            PendingTransactionReceipt::Declare(PendingDeclareTransactionReceipt {
+                transaction_hash,
+                actual_fee: FieldElement::from_str(&output.actual_fee.0.to_string())?,
+                messages_sent: convert_inner_to_rpc_messages(&output.messages_sent)?,
+                events: convert_inner_to_rpc_events(&output.events),
+            })
+        }
+        TransactionOutput::DeployAccount(output) => {
+            PendingTransactionReceipt::DeployAccount(PendingDeployAccountTransactionReceipt {
+                transaction_hash,
+                actual_fee: FieldElement::from_str(&output.actual_fee.0.to_string())?,
+                messages_sent: convert_inner_to_rpc_messages(&output.messages_sent)?,
+                events: convert_inner_to_rpc_events(&output.events),
+            })
+        }
+        TransactionOutput::L1Handler(output) => {
+            PendingTransactionReceipt::L1Handler(PendingL1HandlerTransactionReceipt {
+                transaction_hash,
+                actual_fee: FieldElement::from_str(&output.actual_fee.0.to_string())?,
+                messages_sent: convert_inner_to_rpc_messages(&output.messages_sent)?,
+                events: convert_inner_to_rpc_events(&output.events),
+            })
+        }
+        TransactionOutput::Deploy(_) => unimplemented!("deploy transaction not supported"),
+    })
+}
+
+fn convert_inner_to_rpc_messages(messages: &[InnerMessageToL1]) -> Result<Vec<MsgToL1>> {
+    messages
+        .iter()
+        .map(|message| {
+            Ok(MsgToL1 {
+                to_address: FieldElement::from_byte_slice_be(message.to_address.0.as_bytes())?,
+                payload: convert_stark_felt_array_to_field_element_array(&message.payload.0)?,
+            })
+        })
+        .collect()
+}
+
+fn convert_inner_to_rpc_events(events: &[InnerEvent]) -> Vec<Event> {
+    events
+        .iter()
+        .map(|event| Event {
+            from_address: (*event.from_address.0.key()).into(),
+            keys: event.content.keys.iter().map(|key| key.0.into()).collect(),
+            data: event.content.data.0.iter().map(|fe| (*fe).into()).collect(),
+        })
+        .collect()
+}
+
 fn convert_l1_handle_to_rpc(
     transaction: InnerL1HandlerTransaction,
 ) -> Result<L1HandlerTransaction> {
@@ -1,24 +1,201 @@
+use std::future::{ready, Ready};
+use std::io::Read;
 use std::path::PathBuf;
+use std::sync::Arc;
+use std::time::Duration;
 use std::{fs, str::FromStr};
 
 use anyhow::{Ok, Result};
+use assert_matches::assert_matches;
+use blockifier::abi::abi_utils::selector_from_name;
+use flate2::read::GzDecoder;
+use hyper::{
+    header::{ACCEPT_ENCODING, CONTENT_ENCODING},
+    Body, Request, Response,
+};
+use jsonrpsee::{
+    core::client::ClientT,
+    http_client::HttpClientBuilder,
+    rpc_params,
+    server::{ServerBuilder, ServerHandle},
+    types::error::{CallError, METHOD_NOT_FOUND_CODE},
+    RpcModule,
+};
+use katana_core::constants::{FEE_TOKEN_ADDRESS, TEST_ACCOUNT_CONTRACT_PATH};
+use katana_core::sequencer::{KatanaSequencer, Sequencer};
+use katana_core::starknet::StarknetConfig;
+use katana_rpc::compression::CompressionLayer;
+use katana_rpc::error_codes::CustomError;
+use katana_rpc::event_filter::{resolve_event_keys, EventKey};
+use katana_rpc::features::node_features;
+use katana_rpc::{config::RpcConfig, KatanaNodeRpc, KatanaNodeRpcLogger, UnknownMethodLog};
 use starknet::core::types::contract::{FlattenedSierraClass, SierraClass};
+use starknet::core::types::TransactionStatus;
 use starknet::{
     core::types::FieldElement,
     providers::jsonrpc::{
         models::{
-            BroadcastedDeclareTransaction, BroadcastedDeclareTransactionV2, SierraContractClass,
+            BlockId, BlockTag, BroadcastedDeclareTransaction, BroadcastedDeclareTransactionV2,
+            BroadcastedInvokeTransaction, BroadcastedInvokeTransactionV1, SierraContractClass,
         },
         HttpTransport, JsonRpcClient,
     },
 };
+use starknet_api::{
+    core::{ContractAddress, PatriciaKey},
+    hash::{StarkFelt, StarkHash},
+    patricia_key,
+    transaction::TransactionHash,
+};
+use tokio::sync::RwLock;
+use tower::{Layer, Service};
 use url::Url;
 
+fn test_account_path() -> PathBuf {
+    [
+        env!("CARGO_MANIFEST_DIR"),
+        "../katana-core",
+        TEST_ACCOUNT_CONTRACT_PATH,
+    ]
+    .iter()
+    .collect()
+}
+
+fn test_rpc_config() -> RpcConfig {
+    RpcConfig {
+        port: 0,
+        log_unknown_methods: false,
+        compression_threshold: None,
+        error_codes: Default::default(),
+        instant_confirm_timeout: None,
+    }
+}
+
+// Serves the RPC of a started sequencer on a random port, for the tests to call it
+async fn start_node(
+    starknet: StarknetConfig,
+    rpc: RpcConfig,
+) -> (Arc<RwLock<KatanaSequencer>>, Url, ServerHandle) {
+    let mut sequencer = KatanaSequencer::new(starknet);
+    sequencer.start();
+
+    let sequencer = Arc::new(RwLock::new(sequencer));
+    let (addr, handle) = KatanaNodeRpc::new(sequencer.clone(), rpc)
+        .run()
+        .await
+        .unwrap();
+
+    (
+        sequencer,
+        Url::parse(&format!("http://{addr}")).unwrap(),
+        handle,
+    )
+}
+
 fn get_flattened_sierra_class(raw_contract_class: &str) -> Result<FlattenedSierraClass> {
     let contract_artifact: SierraClass = serde_json::from_str(raw_contract_class)?;
     Ok(contract_artifact.flatten()?)
 }
 
+#[tokio::test]
+async fn test_unknown_method() {
+    let unknown_methods = Arc::new(UnknownMethodLog::default());
+    let server = ServerBuilder::new()
+        .set_logger(KatanaNodeRpcLogger::new(Some(unknown_methods.clone())))
+        .build("127.0.0.1:0")
+        .await
+        .unwrap();
+    let addr = server.local_addr().unwrap();
+
+    let mut module = RpcModule::new(());
+    module
+        .register_method("katana_known", |_, _| std::result::Result::Ok(()))
+        .unwrap();
+    let _handle = server.start(module).unwrap();
+
+    let client = HttpClientBuilder::default()
+        .build(format!("http://{addr}"))
+        .unwrap();
+
+    client
+        .request::<(), _>("katana_known", rpc_params![])
+        .await
+        .unwrap();
+    assert_eq!(unknown_methods.logged(), 0);
+
+    let err = client
+        .request::<serde_json::Value, _>("katana_doesNotExist", rpc_params![])
+        .await
+        .unwrap_err();
+
+    assert_matches!(
+        err,
+        jsonrpsee::core::Error::Call(CallError::Custom(err)) if err.code() == METHOD_NOT_FOUND_CODE
+    );
+    assert_eq!(unknown_methods.logged(), 1);
+}
+
+#[test]
+fn test_unknown_method_log_rate_limit() {
+    let log = UnknownMethodLog::new(2, Duration::from_millis(200));
+
+    assert!(log.record("katana_first"));
+    assert!(log.record("katana_second"));
+    assert!(!log.record("katana_third"));
+
+    // A new window starts once the previous one elapsed
+    std::thread::sleep(Duration::from_millis(250));
+    assert!(log.record("katana_fourth"));
+}
+
+#[tokio::test]
+async fn test_get_block_with_txs_summary() {
+    let (sequencer, url, _handle) = start_node(
+        StarknetConfig {
+            total_accounts: 1,
+            blocks_on_demand: true,
+            ..Default::default()
+        },
+        test_rpc_config(),
+    )
+    .await;
+
+    {
+        let mut sequencer = sequencer.write().await;
+        sequencer
+            .fund_accounts(&[(ContractAddress(patricia_key!("0x1000")), 1000)])
+            .unwrap();
+        sequencer.generate_new_block().unwrap();
+    }
+
+    let client = HttpClientBuilder::default().build(url).unwrap();
+    let block_with_txs = |projection: &'static str| {
+        let client = &client;
+        async move {
+            let block: serde_json::Value = client
+                .request(
+                    "starknet_getBlockWithTxs",
+                    rpc_params!["latest", projection],
+                )
+                .await
+                .unwrap();
+            block["transactions"].as_array().unwrap().clone()
+        }
+    };
+
+    let full = block_with_txs("full").await;
+    assert_eq!(full.len(), 1);
+    assert!(!full[0]["calldata"].as_array().unwrap().is_empty());
+    assert!(!full[0]["signature"].as_array().unwrap().is_empty());
+
+    // The payload fields are kept, empty, so that the transactions keep their spec shape
+    let summary = block_with_txs("summary").await;
+    assert_eq!(summary.len(), 1);
+    assert_eq!(summary[0]["transaction_hash"], full[0]["transaction_hash"]);
+    assert_eq!(summary[0]["calldata"], serde_json::json!([]));
+    assert_eq!(summary[0]["signature"], serde_json::json!([]));
+}
+
 #[ignore]
 #[tokio::test]
 async fn test_send_declare_v2_tx() {
@@ -60,3 +237,344 @@ async fn test_send_declare_v2_tx() {
     println!("{res:?}");
     assert!(res.is_ok())
 }
+
+#[tokio::test]
+async fn test_instant_confirm() {
+    let (sequencer, url, _handle) = start_node(
+        StarknetConfig {
+            total_accounts: 2,
+            blocks_on_demand: true,
+            allow_zero_max_fee: true,
+            account_path: Some(test_account_path()),
+            ..Default::default()
+        },
+        RpcConfig {
+            instant_confirm_timeout: Some(Duration::from_secs(10)),
+            ..test_rpc_config()
+        },
+    )
+    .await;
+
+    let (sender, recipient) = {
+        let sequencer = sequencer.read().await;
+        let accounts = &sequencer.starknet.predeployed_accounts.accounts;
+        (
+            FieldElement::from(*accounts[0].account_address.0.key()),
+            FieldElement::from(*accounts[1].account_address.0.key()),
+        )
+    };
+
+    let provider = JsonRpcClient::new(HttpTransport::new(url));
+    let submission = tokio::spawn(async move {
+        provider
+            .add_invoke_transaction(&BroadcastedInvokeTransaction::V1(
+                BroadcastedInvokeTransactionV1 {
+                    sender_address: sender,
+                    calldata: vec![
+                        FieldElement::from(*FEE_TOKEN_ADDRESS),
+                        FieldElement::from(selector_from_name("transfer").0),
+                        FieldElement::from(3_u64),
+                        recipient,
+                        FieldElement::from(0x99_u64),
+                        FieldElement::ZERO,
+                    ],
+                    max_fee: FieldElement::ZERO,
+                    signature: vec![],
+                    nonce: FieldElement::ZERO,
+                },
+            ))
+            .await
+    });
+
+    // Blocks are mined on demand, so the call doesn't return before a block is generated
+    tokio::time::sleep(Duration::from_millis(500)).await;
+    assert!(!submission.is_finished());
+
+    sequencer.write().await.generate_new_block().unwrap();
+
+    let result = submission.await.unwrap().unwrap();
+    let transaction_hash = TransactionHash(StarkFelt::from(result.transaction_hash));
+    assert_eq!(
+        sequencer.read().await.transaction_status(&transaction_hash),
+        Some(TransactionStatus::AcceptedOnL2)
+    );
+    assert!(sequencer
+        .read()
+        .await
+        .transaction_receipt(&transaction_hash)
+        .is_some());
+}
+
+#[test]
+fn test_node_features() {
+    let sequencer = KatanaSequencer::new(StarknetConfig {
+        call_cache: true,
+        ..Default::default()
+    });
+    let rpc_config = RpcConfig {
+        compression_threshold: Some(1024),
+        ..test_rpc_config()
+    };
+
+    let features = node_features(&rpc_config, &sequencer.executor_config());
+
+    assert_eq!(features.get("explorer"), Some(&false));
+    assert_eq!(features.get("vrf"), Some(&false));
+    assert_eq!(features.get("call_cache"), Some(&true));
+    assert_eq!(features.get("response_compression"), Some(&true));
+    assert_eq!(features.get("fee_estimate_cache"), Some(&false));
+    assert_eq!(features.get("custom_error_codes"), Some(&false));
+}
+
+#[tokio::test]
+async fn test_custom_error_codes() {
+    let starknet = || StarknetConfig {
+        total_accounts: 1,
+        reject_insufficient_balance: true,
+        ..Default::default()
+    };
+    let (sequencer, custom_url, _custom_handle) = start_node(
+        starknet(),
+        RpcConfig {
+            error_codes: [(
+                "InsufficientAccountBalance".to_string(),
+                CustomError {
+                    code: 1001,
+                    message: Some("Not enough funds".to_string()),
+                },
+            )]
+            .into(),
+            ..test_rpc_config()
+        },
+    )
+    .await;
+    // Running alongside, without custom errors
+    let (_, default_url, _default_handle) = start_node(starknet(), test_rpc_config()).await;
+
+    let sender = FieldElement::from(
+        *sequencer
+            .read()
+            .await
+            .starknet
+            .predeployed_accounts
+            .accounts[0]
+            .account_address
+            .0
+            .key(),
+    );
+    // The max fee exceeds the balance of the sender
+    let transaction = BroadcastedInvokeTransaction::V1(BroadcastedInvokeTransactionV1 {
+        sender_address: sender,
+        calldata: vec![],
+        max_fee: FieldElement::from(u128::MAX),
+        signature: vec![],
+        nonce: FieldElement::ZERO,
+    });
+
+    let add_invoke_transaction = |url: Url| {
+        let transaction = transaction.clone();
+        async move {
+            let client = HttpClientBuilder::default().build(url).unwrap();
+            match client
+                .request::<serde_json::Value, _>(
+                    "starknet_addInvokeTransaction",
+                    rpc_params![transaction],
+                )
+                .await
+                .unwrap_err()
+            {
+                jsonrpsee::core::Error::Call(CallError::Custom(err)) => err,
+                err => panic!("unexpected error {err:?}"),
+            }
+        }
+    };
+
+    let custom = add_invoke_transaction(custom_url).await;
+    assert_eq!(custom.code(), 1001);
+    assert_eq!(custom.message(), "Not enough funds");
+
+    // The other node keeps the spec codes
+    let default = add_invoke_transaction(default_url).await;
+    assert_eq!(default.code(), 54);
+    assert_eq!(
+        default.message(),
+        "Account balance is smaller than the transaction's max_fee"
+    );
+}
+
+#[tokio::test]
+async fn test_storage_across_inverted_blocks() {
+    let (sequencer, url, _handle) = start_node(
+        StarknetConfig {
+            blocks_on_demand: true,
+            ..Default::default()
+        },
+        test_rpc_config(),
+    )
+    .await;
+    for _ in 0..2 {
+        sequencer.write().await.generate_new_block().unwrap();
+    }
+
+    let client = HttpClientBuilder::default().build(url).unwrap();
+    let storage_across_blocks = |from_block: u64, to_block: u64| {
+        client.request::<Vec<FieldElement>, _>(
+            "katana_getStorageAcrossBlocks",
+            rpc_params![
+                FieldElement::from(*FEE_TOKEN_ADDRESS),
+                FieldElement::ONE,
+                BlockId::Number(from_block),
+                BlockId::Number(to_block)
+            ],
+        )
+    };
+
+    assert_eq!(storage_across_blocks(0, 1).await.unwrap().len(), 2);
+    assert_matches!(
+        storage_across_blocks(1, 0).await.unwrap_err(),
+        jsonrpsee::core::Error::Call(CallError::Custom(err)) if err.code() == 101
+    );
+}
+
+#[tokio::test]
+async fn test_compute_message_hash() {
+    let (_sequencer, url, _handle) = start_node(StarknetConfig::default(), test_rpc_config()).await;
+
+    let client = HttpClientBuilder::default().build(url).unwrap();
+    let hash = client
+        .request::<String, _>(
+            "katana_computeMessageHash",
+            rpc_params![
+                FieldElement::from(0x1234_u64),
+                FieldElement::from(0x100_u64),
+                FieldElement::from(selector_from_name("test_storage_read_write").0),
+                vec![FieldElement::from(0x42_u64)],
+                FieldElement::ZERO
+            ],
+        )
+        .await
+        .unwrap();
+
+    // The keccak of the abi-encoded words (0x1234, 0x100, 0, selector, 1, 0x42), computed
+    // independently of the node
+    assert_eq!(
+        hash,
+        "0x022352966734b2cb669bb8513f2ce24775abe4ee25d0d0603bb8ac25ef3b0410"
+    );
+}
+
+#[test]
+fn test_events_by_name() {
+    let mut sequencer = KatanaSequencer::new(StarknetConfig {
+        total_accounts: 1,
+        blocks_on_demand: true,
+        ..Default::default()
+    });
+    sequencer.start();
+
+    // Funding emits ERC20 `Transfer` events
+    sequencer
+        .fund_accounts(&[(ContractAddress(patricia_key!("0x1000")), 1000)])
+        .unwrap();
+    sequencer.generate_new_block().unwrap();
+    // The upper bound of the range is exclusive
+    sequencer.generate_new_block().unwrap();
+
+    let keys: Vec<Vec<EventKey>> = serde_json::from_str(r#"[["Transfer"]]"#).unwrap();
+    assert_eq!(keys, vec![vec![EventKey::Name("Transfer".to_string())]]);
+
+    let events_with_keys = |keys| {
+        sequencer
+            .events(
+                BlockId::Number(0),
+                BlockId::Tag(BlockTag::Latest),
+                None,
+                Some(keys),
+                None,
+                100,
+            )
+            .unwrap()
+            .iter()
+            .map(|event| (event.transaction_hash, event.inner.clone()))
+            .collect::<Vec<_>>()
+    };
+
+    let by_name = events_with_keys(resolve_event_keys(&keys));
+    let by_selector = events_with_keys(vec![vec![selector_from_name("Transfer").0]]);
+
+    assert!(!by_name.is_empty());
+    assert_eq!(by_name, by_selector);
+}
+
+// Responds to every request with the same body. `Ok` is anyhow's in this file, hence the
+// fully qualified results.
+struct StaticResponse(Vec<u8>);
+
+impl Service<Request<Body>> for StaticResponse {
+    type Response = Response<Body>;
+    type Error = Box<dyn std::error::Error + Send + Sync>;
+    type Future = Ready<Result<Self::Response, Self::Error>>;
+
+    fn poll_ready(
+        &mut self,
+        _cx: &mut std::task::Context<'_>,
+    ) -> std::task::Poll<Result<(), Self::Error>> {
+        std::task::Poll::Ready(std::result::Result::Ok(()))
+    }
+
+    fn call(&mut self, _request: Request<Body>) -> Self::Future {
+        ready(std::result::Result::Ok(Response::new(Body::from(
+            self.0.clone(),
+        ))))
+    }
+}
+
+#[tokio::test]
+async fn test_response_compression() {
+    let body = serde_json::to_vec(&serde_json::json!({
+        "jsonrpc": "2.0",
+        "id": 1,
+        "result": vec!["0x49d36570d4e46f48e99674bd3fcc84644ddd6b96f7c741b1562b82f9e004dc7"; 100],
+    }))
+    .unwrap();
+
+    let request = |accept_encoding: Option<&str>| {
+        let mut request = Request::builder();
+        if let Some(accept_encoding) = accept_encoding {
+            request = request.header(ACCEPT_ENCODING, accept_encoding);
+        }
+        request.body(Body::empty()).unwrap()
+    };
+    let send = |threshold: usize, accept_encoding: Option<&str>| {
+        let mut service =
+            CompressionLayer::new(Some(threshold)).layer(StaticResponse(body.clone()));
+        service.call(request(accept_encoding))
+    };
+
+    let response = send(1024, Some("deflate, gzip")).await.unwrap();
+    assert_eq!(response.headers()[CONTENT_ENCODING], "gzip");
+
+    let compressed = hyper::body::to_bytes(response.into_body()).await.unwrap();
+    assert!(compressed.len() < body.len());
+
+    let mut decompressed = Vec::new();
+    GzDecoder::new(&compressed[..])
+        .read_to_end(&mut decompressed)
+        .unwrap();
+    assert_eq!(decompressed, body);
+
+    // Not compressed for clients not accepting gzip, nor below the threshold
+    for (threshold, accept_encoding) in [
+        (1024, None),
+        (1024, Some("deflate")),
+        (1024, Some("gzip;q=0")),
+        (body.len() + 1, Some("gzip")),
+    ] {
+        let response = send(threshold, accept_encoding).await.unwrap();
+        assert!(!response.headers().contains_key(CONTENT_ENCODING));
+        assert_eq!(
+            hyper::body::to_bytes(response.into_body()).await.unwrap(),
+            body
+        );
+    }
+}